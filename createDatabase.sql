@@ -14,9 +14,22 @@ CREATE TABLE IF NOT EXISTS contracts
     validate_signature boolean,
     contract           json,
     active             bool default true,
+    expired            bool default false,
     parent             text REFERENCES contracts
 );
 
+-- contract_versions keeps the archived bodies of updated contracts; the row in contracts always holds the actual
+-- version, so that the contract id stays the reference of the derived data and no query has to skip archived rows
+CREATE TABLE IF NOT EXISTS contract_versions
+(
+    contract text        NOT NULL REFERENCES contracts ON DELETE CASCADE,
+    version  text        NOT NULL,
+    creation timestamptz,
+    body     json        NOT NULL,
+    archived timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (contract, version)
+);
+
 CREATE TABLE IF NOT EXISTS organisations
 (
     id   bigserial PRIMARY KEY,
@@ -83,6 +96,8 @@ CREATE TABLE IF NOT EXISTS contract_machine_sensors
     id             bigserial PRIMARY KEY,
     contract       text REFERENCES contracts,
     machine_sensor bigint REFERENCES machine_sensors,
+    -- active is unset, if the sensor is not part of the actual version of the contract
+    active         bool NOT NULL DEFAULT true,
     UNIQUE (contract, machine_sensor)
 );
 
@@ -132,11 +147,12 @@ CREATE TABLE IF NOT EXISTS analysis
 
 CREATE TABLE IF NOT EXISTS update_message
 (
-    machine_sensor integer REFERENCES machine_sensors,
-    time           timestamp,
-    meta           json,
-    columns        json,
-    data           json
+    id                      BIGSERIAL PRIMARY KEY,
+    contract_machine_sensor bigint REFERENCES contract_machine_sensors,
+    time                    timestamptz,
+    meta                    json,
+    columns                 json,
+    data                    json
 );
 
 CREATE TABLE IF NOT EXISTS technical_containers
@@ -165,10 +181,18 @@ CREATE TABLE IF NOT EXISTS token
 (
     token TEXT PRIMARY KEY,
     valid TIMESTAMPTZ NOT NULL,
-    write_contract BOOL NOT NULL DEFAULT false
+    write_contract BOOL NOT NULL DEFAULT false,
+    delete_contract BOOL NOT NULL DEFAULT false,
+    write_result BOOL NOT NULL DEFAULT false,
+    admin BOOL NOT NULL DEFAULT false,
+    subject TEXT,
+    encrypted_refresh_token BYTEA,
+    created TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     CONSTRAINT token_valid CHECK (valid > NOW())
 );
 
+CREATE INDEX IF NOT EXISTS token_subject_idx ON token (subject);
+
 CREATE TABLE IF NOT EXISTS token_permission
 (
     token        TEXT   NOT NULL,
@@ -177,4 +201,132 @@ CREATE TABLE IF NOT EXISTS token_permission
     CONSTRAINT token_permission_organisation_fk FOREIGN KEY (organisation) REFERENCES organisations (id) ON DELETE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS login_state
+(
+    state    TEXT PRIMARY KEY,
+    nonce    TEXT        NOT NULL,
+    verifier TEXT        NOT NULL,
+    redirect TEXT        NOT NULL DEFAULT '',
+    valid    TIMESTAMPTZ NOT NULL
+);
+
+-- code is the hash of the one-time code, which is exchanged for the session token after a login with redirect
+CREATE TABLE IF NOT EXISTS login_code
+(
+    code    TEXT PRIMARY KEY,
+    token   TEXT REFERENCES token ON DELETE CASCADE NOT NULL,
+    session TIMESTAMPTZ NOT NULL,
+    valid   TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id           TEXT PRIMARY KEY,
+    hash         TEXT        NOT NULL,
+    organisation BIGINT      NOT NULL REFERENCES organisations,
+    write        BOOL        NOT NULL DEFAULT false,
+    description  TEXT        NOT NULL DEFAULT '',
+    created      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked      TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS api_key_contracts
+(
+    api_key  TEXT REFERENCES api_keys ON DELETE CASCADE NOT NULL,
+    contract TEXT REFERENCES contracts NOT NULL,
+    UNIQUE (api_key, contract)
+);
+
+CREATE TABLE IF NOT EXISTS api_key_machines
+(
+    api_key TEXT REFERENCES api_keys ON DELETE CASCADE NOT NULL,
+    machine TEXT NOT NULL,
+    UNIQUE (api_key, machine)
+);
+
+CREATE TABLE IF NOT EXISTS contract_removals
+(
+    id        BIGSERIAL PRIMARY KEY,
+    contract  TEXT REFERENCES contracts NOT NULL,
+    blame     TEXT NOT NULL,
+    reason    TEXT,
+    signature TEXT,
+    removed   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS outbox
+(
+    id           BIGSERIAL PRIMARY KEY,
+    topic        TEXT        NOT NULL,
+    payload      BYTEA       NOT NULL,
+    qos          SMALLINT    NOT NULL DEFAULT 1,
+    retain       BOOL        NOT NULL DEFAULT false,
+    attempts     INT         NOT NULL DEFAULT 0,
+    -- next_attempt is moved behind the claim timeout, while a dispatcher publishes the message
+    next_attempt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- failed is set, when the message is dead-lettered after the maximal count of attempts
+    failed       TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_topic_idx ON outbox (topic, id);
+
+CREATE TABLE IF NOT EXISTS pipeline_executions
+(
+    id           BIGSERIAL PRIMARY KEY,
+    contract     TEXT REFERENCES contracts NOT NULL,
+    pipeline     INT         NOT NULL,
+    -- pipeline_key identifies the pipeline independent of its position in the contract
+    pipeline_key TEXT        NOT NULL,
+    trigger      TEXT        NOT NULL,
+    state        TEXT        NOT NULL,
+    requested    TIMESTAMPTZ NOT NULL,
+    updated      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS pipeline_executions_key_idx ON pipeline_executions (contract, pipeline_key, trigger);
+
+-- migrations of databases, which are created by previous versions; every statement can be executed repeatedly
+ALTER TABLE contracts
+    ADD COLUMN IF NOT EXISTS expired bool default false;
+
+ALTER TABLE contract_machine_sensors
+    ADD COLUMN IF NOT EXISTS active bool NOT NULL DEFAULT true;
+
+-- the sessions of previous versions could write the results, the write_result column keeps this permission
+ALTER TABLE token
+    ADD COLUMN IF NOT EXISTS delete_contract BOOL NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS write_result BOOL NOT NULL DEFAULT true,
+    ADD COLUMN IF NOT EXISTS admin BOOL NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS subject TEXT,
+    ADD COLUMN IF NOT EXISTS encrypted_refresh_token BYTEA,
+    ADD COLUMN IF NOT EXISTS created TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+ALTER TABLE token
+    ALTER COLUMN write_result SET DEFAULT false;
+
+-- updates were stored per machine sensor; they are bound to the sensor of the contract
+ALTER TABLE update_message
+    ADD COLUMN IF NOT EXISTS id BIGSERIAL PRIMARY KEY,
+    ADD COLUMN IF NOT EXISTS contract_machine_sensor bigint REFERENCES contract_machine_sensors,
+    ALTER COLUMN time TYPE timestamptz;
+
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1
+                  FROM information_schema.columns
+                  WHERE table_name = 'update_message'
+                    AND column_name = 'machine_sensor') THEN
+            UPDATE update_message AS u
+            SET contract_machine_sensor = cms.id
+            FROM contract_machine_sensors AS cms
+            WHERE cms.machine_sensor = u.machine_sensor
+              AND u.contract_machine_sensor IS NULL;
+
+            ALTER TABLE update_message
+                DROP COLUMN machine_sensor;
+        END IF;
+    END
+$$;
+
 COMMIT;
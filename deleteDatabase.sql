@@ -8,6 +8,8 @@ DROP TABLE connection CASCADE;
 
 DROP TABLE partners CASCADE;
 
+DROP TABLE contract_versions CASCADE;
+
 DROP TABLE contracts CASCADE;
 
 DROP TABLE systems CASCADE;
@@ -28,6 +30,16 @@ DROP TABLE token CASCADE;
 
 DROP TABLE token_permission CASCADE;
 
+DROP TABLE login_state CASCADE;
+
+DROP TABLE login_code CASCADE;
+
+DROP TABLE api_keys CASCADE;
+
+DROP TABLE api_key_contracts CASCADE;
+
+DROP TABLE api_key_machines CASCADE;
+
 DROP TABLE pipelines CASCADE;
 
 DROP TABLE analysis CASCADE;
@@ -42,3 +54,8 @@ DROP TABLE read_permissions CASCADE;
 
 DROP TABLE write_permissions CASCADE;
 
+DROP TABLE contract_removals CASCADE;
+
+DROP TABLE outbox CASCADE;
+
+DROP TABLE pipeline_executions CASCADE;
@@ -2,6 +2,7 @@
 package config
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 
@@ -37,6 +38,12 @@ func TestConfigConfiguration(t *testing.T) {
 	configuration.Database.Port = 789
 	configuration.Mqtt.Address = "127.0.0.1"
 	configuration.Mqtt.Port = 5432
+	configuration.UserMgmt.Mechanisms = []string{"session", "jwt"}
+	configuration.UserMgmt.Redirects = []string{"http://127.0.0.1:8000/callback"}
+	configuration.UserMgmt.Claims.Groups = "groups"
+	configuration.UserMgmt.Claims.GroupPrefix = "/orgs/"
+	configuration.UserMgmt.Claims.Capabilities = map[string][]string{"contract_create": {"contractCreate", "contractDelete"}}
+	configuration.UserMgmt.Claims.DefaultCapabilities = []string{"resultWrite"}
 
 	bytes, err := yaml.Marshal(configuration)
 	var conf Configurations
@@ -48,7 +55,7 @@ func TestConfigConfiguration(t *testing.T) {
 		t.Errorf("could not unparse from yaml to configuration")
 	}
 
-	if conf != configuration {
+	if !reflect.DeepEqual(conf, configuration) {
 		t.Errorf("conf != con\n\t%v\n\t%v\n", configuration, conf)
 	}
 }
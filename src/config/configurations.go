@@ -1,5 +1,12 @@
 package config
 
+// Topic configures the topic template and the delivery of a kind of mqtt messages
+type Topic struct {
+	Template string `yaml:"template"`
+	QoS      byte   `yaml:"qos"`
+	Retain   bool   `yaml:"retain"`
+}
+
 // Configurations contains all other configuration details
 type Configurations struct {
 	Webserver struct {
@@ -12,11 +19,50 @@ type Configurations struct {
 		Database string `yaml:"database"`
 	} `yaml:"database"`
 	Mqtt struct {
-		Address string `yaml:"address"`
-		Port    int    `yaml:"port"`
+		Address              string `yaml:"address"`
+		Port                 int    `yaml:"port"`
+		ResultTopic          string `yaml:"resultTopic"`
+		DeadLetterTopic      string `yaml:"deadLetterTopic"`
+		ClientID             string `yaml:"clientId"`
+		KeepAlive            string `yaml:"keepAlive"`
+		MaxReconnectInterval string `yaml:"maxReconnectInterval"`
+		TLS                  struct {
+			Enabled    bool   `yaml:"enabled"`
+			CA         string `yaml:"ca"`
+			ClientCert string `yaml:"clientCert"`
+			ServerName string `yaml:"serverName"`
+		} `yaml:"tls"`
+		Topics struct {
+			MachineData       Topic `yaml:"machineData"`
+			ContractEvents    Topic `yaml:"contractEvents"`
+			AnalysisResults   Topic `yaml:"analysisResults"`
+			ExecutionRequests Topic `yaml:"executionRequests"`
+		} `yaml:"topics"`
 	} `yaml:"mqtt"`
 	UserMgmt struct {
-		UserMgmt      string `yaml:"userMgmt"`
-		ServerAddress string `yaml:"serverAddress"`
+		UserMgmt           string   `yaml:"userMgmt"`
+		ServerAddress      string   `yaml:"serverAddress"`
+		Mechanisms         []string `yaml:"mechanisms"`
+		Audience           string   `yaml:"audience"`
+		Redirects          []string `yaml:"redirects"`
+		MaxSessionLifetime string   `yaml:"maxSessionLifetime"`
+		Claims             struct {
+			Groups              string              `yaml:"groups"`
+			Roles               string              `yaml:"roles"`
+			GroupPrefix         string              `yaml:"groupPrefix"`
+			GroupPattern        string              `yaml:"groupPattern"`
+			Capabilities        map[string][]string `yaml:"capabilities"`
+			DefaultCapabilities []string            `yaml:"defaultCapabilities"`
+		} `yaml:"claims"`
 	} `yaml:"userMgmt"`
+	Signature struct {
+		KeyStore string `yaml:"keyStore"`
+	} `yaml:"signature"`
+	Scheduler struct {
+		Interval string `yaml:"interval"`
+	} `yaml:"scheduler"`
+	Retention struct {
+		Interval string `yaml:"interval"`
+		DryRun   bool   `yaml:"dryRun"`
+	} `yaml:"retention"`
 }
@@ -3,8 +3,9 @@ package config
 // Password is the configuration which contains the user and password configurations
 type Password struct {
 	Mqtt struct {
-		User     string `yaml:"user"`
-		Password string `yaml:"password"`
+		User      string `yaml:"user"`
+		Password  string `yaml:"password"`
+		ClientKey string `yaml:"clientKey"`
 	} `yaml:"mqtt"`
 	Database struct {
 		User     string `yaml:"user"`
@@ -13,5 +14,6 @@ type Password struct {
 	UserMgmt struct {
 		ClientId     string `yaml:"clientID"`
 		ClientSecret string `yaml:"clientSecret"`
+		SessionKey   string `yaml:"sessionKey"`
 	} `yaml:"userMgmt"`
 }
@@ -0,0 +1,62 @@
+// Package duration parses the durations of the contracts, which are defined as ISO 8601 durations
+package duration
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// iso8601 matches durations like P1Y2M3W4DT5H6M7.5S; every component is optional
+var iso8601 = regexp.MustCompile(`^P(?:(\d+(?:[.,]\d+)?)Y)?(?:(\d+(?:[.,]\d+)?)M)?(?:(\d+(?:[.,]\d+)?)W)?(?:(\d+(?:[.,]\d+)?)D)?(?:T(?:(\d+(?:[.,]\d+)?)H)?(?:(\d+(?:[.,]\d+)?)M)?(?:(\d+(?:[.,]\d+)?)S)?)?$`)
+
+// units are the lengths of the components of an ISO 8601 duration; a year has 365 days and a month 30 days
+var units = []time.Duration{
+	365 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+	7 * 24 * time.Hour,
+	24 * time.Hour,
+	time.Hour,
+	time.Minute,
+	time.Second,
+}
+
+// Parse parses an ISO 8601 duration (e.g. P30D or PT12H). For compatibility durations in the
+// format of time.ParseDuration (e.g. 720h) are accepted, too. Only positive durations are valid.
+func Parse(value string) (time.Duration, error) {
+	if !strings.HasPrefix(value, "P") {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		if d <= 0 {
+			return 0, fmt.Errorf("duration %q is not positive", value)
+		}
+		return d, nil
+	}
+
+	match := iso8601.FindStringSubmatch(value)
+	if match == nil || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	var d time.Duration
+	for i, component := range match[1:] {
+		if component == "" {
+			continue
+		}
+
+		count, err := strconv.ParseFloat(strings.Replace(component, ",", ".", 1), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %s", value, err)
+		}
+		d += time.Duration(count * float64(units[i]))
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("duration %q is not positive", value)
+	}
+	return d, nil
+}
@@ -0,0 +1,41 @@
+package duration
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	testTable := []struct {
+		description string
+		value       string
+		duration    time.Duration
+		err         bool
+	}{
+		{"days", "P30D", 30 * 24 * time.Hour, false},
+		{"years and months", "P1Y2M", (365 + 60) * 24 * time.Hour, false},
+		{"weeks", "P2W", 14 * 24 * time.Hour, false},
+		{"time", "PT1H30M", 90 * time.Minute, false},
+		{"fraction", "PT0.5S", 500 * time.Millisecond, false},
+		{"date and time", "P1DT12H", 36 * time.Hour, false},
+		{"go duration", "720h", 720 * time.Hour, false},
+		{"empty", "", 0, true},
+		{"without components", "P", 0, true},
+		{"time without components", "P1DT", 0, true},
+		{"zero", "P0D", 0, true},
+		{"negative go duration", "-1h", 0, true},
+		{"invalid", "30 days", 0, true},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			d, err := Parse(v.value)
+			if (err != nil) != v.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d != v.duration {
+				t.Errorf("expected %s, got %s", v.duration, d)
+			}
+		})
+	}
+}
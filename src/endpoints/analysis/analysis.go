@@ -2,15 +2,23 @@ package analysis
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"k8s.io/klog"
 
 	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/analysis/models"
 	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/auth"
+	contractModels "github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/outbox"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/signature"
 )
 
 type Analysis interface {
@@ -20,10 +28,39 @@ type Analysis interface {
 type analysis struct {
 	authHelper auth.Helper
 	analysis   AnalyseLogic
+	messages   outbox.Outbox
+	topic      mqtt.Topic
 }
 
-func NewAnalysisEndpoint(analysisLogic AnalyseLogic, authHelper auth.Helper) Analysis {
-	return analysis{analysis: analysisLogic, authHelper: authHelper}
+// NewAnalysisEndpoint creates the analysis endpoint; the uploaded results are published on the topic, if its
+// template is not empty
+func NewAnalysisEndpoint(analysisLogic AnalyseLogic, authHelper auth.Helper, messages outbox.Outbox, topic mqtt.Topic) Analysis {
+	return analysis{analysis: analysisLogic, authHelper: authHelper, messages: messages, topic: topic}
+}
+
+// publish queues the uploaded results, which will be published on the mqtt broker
+func (a analysis) publish(params map[string]string, data []models.Analysis) {
+	if a.topic.Template == "" {
+		return
+	}
+
+	for _, result := range data {
+		payload, err := json.Marshal(result)
+		if err != nil {
+			klog.Errorf("cannot marshal result: %s", err)
+			continue
+		}
+
+		msg, err := a.topic.Msg(payload, params)
+		if err != nil {
+			klog.Errorf("cannot create message of result: %s", err)
+			continue
+		}
+
+		if err := a.messages.Enqueue(msg); err != nil {
+			klog.Errorf("cannot queue result: %s", err)
+		}
+	}
 }
 
 func (a analysis) handlePost(w http.ResponseWriter, r *http.Request) {
@@ -54,6 +91,14 @@ func (a analysis) handlePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the results are published on the topic, of which the contract, machine and sensor are single levels
+	params := map[string]string{"contract": ur[2], "machine": ur[3], "sensor": ur[4]}
+	if err := a.topic.Validate(params); err != nil {
+		klog.Infof("cannot publish the results: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
 	// read data from request
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -69,14 +114,24 @@ func (a analysis) handlePost(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(400)
 		return
 	}
+	rawBodies, err := signature.TransmittedBodies(body)
+	if err != nil {
+		klog.Errorf("could not parse the bodies of the results: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+	for i := range data {
+		data[i].RawBody = rawBodies[i]
+	}
 
 	// handle request
 	if err := a.analysis.InsertResult(ur[2], ur[3], ur[4], data); err != nil {
-		w.WriteHeader(500)
+		w.WriteHeader(errorStatusCode(err))
 		klog.Errorf("could not insert data: %s\n", err)
 		return
 	}
 
+	a.publish(params, data)
 	w.WriteHeader(201)
 }
 
@@ -120,13 +175,17 @@ func (a analysis) handleGet(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// receive the result, which should be send to the client
-		resSet, err := a.analysis.GetResultSet(contractId, parsedQuery)
+		resSet, next, err := a.analysis.GetResultSet(contractId, parsedQuery)
 		if err != nil {
 			klog.Errorf("error occurred in GetResultSet: %v\n", err)
-			w.WriteHeader(500)
+			w.WriteHeader(errorStatusCode(err))
 			return
 		}
 
+		if next != "" {
+			w.Header().Set(pagination.NextCursorHeader, next)
+		}
+
 		// if the output is empty we should not send "NULL" to the client
 		if string(resSet) == "null" {
 			return
@@ -141,6 +200,11 @@ func (a analysis) handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 
 	case 4:
+		if ur[3] == "stream" {
+			a.handleStream(w, r, ur[2])
+			return
+		}
+
 		// query specific analyses
 
 		resultId, err := strconv.ParseInt(ur[3], 10, 64)
@@ -153,7 +217,8 @@ func (a analysis) handleGet(w http.ResponseWriter, r *http.Request) {
 		ret, err := a.analysis.GetSpecificResult(ur[2], resultId)
 		if err != nil {
 			klog.Errorf("could not query specific result: %s\n", err)
-			w.WriteHeader(500)
+			w.WriteHeader(errorStatusCode(err))
+			return
 		}
 		// sending result
 		if _, err := w.Write(ret); err != nil {
@@ -163,6 +228,110 @@ func (a analysis) handleGet(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// keepAliveInterval is the interval, in which comments are sent on an idle result stream
+var keepAliveInterval = 30 * time.Second
+
+// writeEvent sends a result as server-sent event
+func writeEvent(w http.ResponseWriter, result models.Result) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: result\ndata: %s\n\n", result.ID, data)
+	return err
+}
+
+// handleStream sends the new results of the contract as server-sent events
+func (a analysis) handleStream(w http.ResponseWriter, r *http.Request, contractID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		klog.Errorf("response writer does not support streaming")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var lastEventID int64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		var err error
+		if lastEventID, err = strconv.ParseInt(id, 10, 64); err != nil {
+			klog.Infof("cannot parse last event id: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	missed, results, cancel, err := a.analysis.SubscribeResults(contractID, r.URL.Query(), lastEventID)
+	if err != nil {
+		klog.Errorf("cannot subscribe to results: %s", err)
+		w.WriteHeader(errorStatusCode(err))
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, result := range missed {
+		if err := writeEvent(w, result); err != nil {
+			klog.Errorf("cannot send result: %s", err)
+			return
+		}
+		lastEventID = result.ID
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case result, ok := <-results:
+			if !ok {
+				klog.Infof("result stream of contract %s has been closed", contractID)
+				return
+			}
+
+			// the result has already been sent with the missed results
+			if result.ID <= lastEventID {
+				continue
+			}
+
+			if err := writeEvent(w, result); err != nil {
+				klog.Errorf("cannot send result: %s", err)
+				return
+			}
+			lastEventID = result.ID
+		}
+		flusher.Flush()
+	}
+}
+
+// errorStatusCode maps the errors of the analysis logic to http status codes
+func errorStatusCode(err error) int {
+	var stateErr contractModels.StateError
+	switch {
+	case errors.As(err, &stateErr):
+		return stateErr.State.StatusCode()
+	case errors.Is(err, contractModels.ErrContractNotFound), errors.Is(err, signature.ErrContractNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, signature.ErrInvalidSignature):
+		return http.StatusForbidden
+	case errors.Is(err, pagination.ErrInvalidPage), errors.Is(err, models.ErrInvalidFilter), errors.Is(err, models.ErrInvalidResult):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (a analysis) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	klog.Infof("receive http request on url: %s and with method: %s", r.URL.String(), r.Method)
 	switch r.Method {
@@ -5,28 +5,57 @@ import (
 	"fmt"
 
 	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/analysis/models"
+	contractModels "github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/signature"
 )
 
 type AnalyseLogic interface {
-	GetResultSet(string, map[string][]string) ([]byte, error)
+	// GetResultSet returns a page of the results of a contract and the cursor of the next page
+	GetResultSet(string, map[string][]string) ([]byte, string, error)
 	InsertResult(string, string, string, []models.Analysis) error
 	GetSpecificResult(string, int64) ([]byte, error)
+
+	// SubscribeResults returns the stored results after the last event id, a channel receiving the new
+	// results of the contract and a function to cancel the subscription
+	SubscribeResults(string, map[string][]string, int64) ([]models.Result, <-chan models.Result, func(), error)
 }
 
 type analyseLogic struct {
 	analysisHandler models.AnalysisHandler
 	resultHandler   models.ResultListHandler
+	signatures      signature.ContractVerifier
+	states          contractModels.StateHandler
+	broker          Broker
 }
 
-func NewAnalyseLogic(resultHandler models.ResultListHandler, analysisHandler models.AnalysisHandler) AnalyseLogic {
-	return analyseLogic{resultHandler: resultHandler, analysisHandler: analysisHandler}
+func NewAnalyseLogic(resultHandler models.ResultListHandler, analysisHandler models.AnalysisHandler, signatures signature.ContractVerifier, states contractModels.StateHandler, broker Broker) AnalyseLogic {
+	return analyseLogic{resultHandler: resultHandler, analysisHandler: analysisHandler, signatures: signatures, states: states, broker: broker}
 }
 
-func (a analyseLogic) GetResultSet(contractID string, queryOptions map[string][]string) ([]byte, error) {
-	return a.resultHandler.Get(contractID, queryOptions)
+func (a analyseLogic) GetResultSet(contractID string, queryOptions map[string][]string) ([]byte, string, error) {
+	if err := a.states.Check(contractID); err != nil {
+		return nil, "", err
+	}
+
+	page, queryOptions, err := pagination.Parse(queryOptions)
+	if err != nil {
+		return nil, "", err
+	}
+
+	filter, err := models.ParseResultFilter(queryOptions)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return a.resultHandler.Get(contractID, filter, page)
 }
 
 func (a analyseLogic) GetSpecificResult(contractID string, resultID int64) ([]byte, error) {
+	if err := a.states.Check(contractID); err != nil {
+		return nil, err
+	}
+
 	data, err := a.analysisHandler.Query(contractID, resultID)
 	if err != nil {
 		return nil, err
@@ -35,16 +64,69 @@ func (a analyseLogic) GetSpecificResult(contractID string, resultID int64) ([]by
 	return json.Marshal(data)
 }
 
-func (a analyseLogic) InsertResult(contractID, machineID, sensorId string, models []models.Analysis) error {
-	for _, model := range models {
+func (a analyseLogic) InsertResult(contractID, machineID, sensorId string, analyses []models.Analysis) error {
+	if err := a.states.Check(contractID); err != nil {
+		return err
+	}
+
+	// all results are checked, before any of them is stored
+	for _, model := range analyses {
 		if !model.Validate() {
-			return fmt.Errorf("on of the transmitted models is not valid")
+			return fmt.Errorf("one of the transmitted models is not valid: %w", models.ErrInvalidResult)
 		}
 
-		if err := a.analysisHandler.Insert(contractID, machineID, sensorId, model); err != nil {
+		if err := a.signatures.Verify(contractID, model.SignedBody(), model.Signature); err != nil {
 			return err
 		}
 	}
 
+	ids, err := a.analysisHandler.Insert(contractID, machineID, sensorId, analyses)
+	if err != nil {
+		return err
+	}
+
+	for i, model := range analyses {
+		a.broker.Publish(contractID, models.Result{
+			ID:       ids[i],
+			Machine:  machineID,
+			Sensor:   sensorId,
+			Date:     model.Body.Timestamp,
+			Analysis: model,
+		})
+	}
+
 	return nil
 }
+
+func (a analyseLogic) SubscribeResults(contractID string, queryOptions map[string][]string, lastEventID int64) ([]models.Result, <-chan models.Result, func(), error) {
+	if err := a.states.Check(contractID); err != nil {
+		return nil, nil, nil, err
+	}
+
+	filter, err := models.ParseResultFilter(queryOptions)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// subscribe before querying the missed results, so that no result gets lost in between
+	results, cancel := a.broker.Subscribe(contractID, filter)
+	if lastEventID == 0 {
+		return nil, results, cancel, nil
+	}
+
+	// the missed results are queried page by page, until the stored results are caught up
+	var missed []models.Result
+	for {
+		page, err := a.resultHandler.Since(contractID, filter, lastEventID)
+		if err != nil {
+			cancel()
+			return nil, nil, nil, err
+		}
+
+		missed = append(missed, page...)
+		if len(page) < pagination.MaxLimit {
+			return missed, results, cancel, nil
+		}
+		lastEventID = page[len(page)-1].ID
+	}
+}
@@ -2,6 +2,7 @@
 package analysis
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -11,17 +12,26 @@ import (
 
 	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/analysis/models"
 	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/auth"
+	contractModels "github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/signature"
 )
 
 // using this varibale to control the behavior of the GetAllAnalysess function
 
 type testAnalysisHandler struct{}
 
-func (testAnalysisHandler) Insert(contract, machine, sensor string, ana models.Analysis) error {
+func (testAnalysisHandler) Insert(contract, machine, sensor string, analyses []models.Analysis) ([]int64, error) {
 	if contract == "error" {
-		return fmt.Errorf("error")
+		return nil, fmt.Errorf("error")
 	}
-	return nil
+
+	ids := make([]int64, len(analyses))
+	for i := range analyses {
+		ids[i] = int64(i + 1)
+	}
+	return ids, nil
 }
 
 func (testAnalysisHandler) Query(contract string, resultID int64) (models.Analysis, error) {
@@ -33,26 +43,81 @@ func (testAnalysisHandler) Query(contract string, resultID int64) (models.Analys
 
 type testResultHandler struct{}
 
-func (t testResultHandler) Get(contract string, query map[string][]string) ([]byte, error) {
+func (t testResultHandler) Get(contract string, filter models.ResultFilter, page pagination.Page) ([]byte, string, error) {
 	switch contract {
 	case "error":
-		return nil, fmt.Errorf("error")
+		return nil, "", fmt.Errorf("error")
 	case "one":
-		return []byte("[{\"resultId\":1,\"machine\":\"\",\"date\":0}]"), nil
+		return []byte("[{\"resultId\":1,\"machine\":\"\",\"date\":0}]"), "", nil
 	case "two":
-		return []byte("[{\"resultId\":1,\"machine\":\"\",\"date\":0},{\"resultId\":2,\"machine\":\"\",\"date\":0}]"), nil
+		if page.Limit == 1 {
+			return []byte("[{\"resultId\":1,\"machine\":\"\",\"date\":0}]"), "next", nil
+		}
+		return []byte("[{\"resultId\":1,\"machine\":\"\",\"date\":0},{\"resultId\":2,\"machine\":\"\",\"date\":0}]"), "", nil
+	default:
+		return nil, "", nil
+	}
+}
+
+func (t testResultHandler) Since(contract string, filter models.ResultFilter, id int64) ([]models.Result, error) {
+	switch contract {
+	case "error":
+		return nil, fmt.Errorf("error")
+	case "one":
+		return []models.Result{{ID: id + 1, Machine: "m", Sensor: "s"}}, nil
+	case "paged":
+		// 2500 results are stored, which are returned in pages of at most pagination.MaxLimit results
+		var results []models.Result
+		for next := id + 1; next <= 2500 && len(results) < pagination.MaxLimit; next++ {
+			results = append(results, models.Result{ID: next})
+		}
+		return results, nil
 	default:
 		return nil, nil
 	}
 }
 
+type testSignatureVerifier struct{}
+
+func (testSignatureVerifier) Verify(contract string, body interface{}, sig signature.Signature) error {
+	if contract == "unknown-contract" {
+		return signature.ErrContractNotFound
+	}
+	if contract == "invalid-signature" {
+		return signature.ErrInvalidSignature
+	}
+	return nil
+}
+
+type testStateHandler struct{}
+
+func (testStateHandler) GetState(contract string) (contractModels.State, error) {
+	panic("implement me")
+}
+
+func (testStateHandler) Check(contract string) error {
+	switch contract {
+	case "expired":
+		return contractModels.StateError{Contract: contract, State: contractModels.StateExpired}
+	case "future":
+		return contractModels.StateError{Contract: contract, State: contractModels.StateNotYetValid}
+	case "unknown":
+		return contractModels.ErrContractNotFound
+	}
+	return nil
+}
+
+func (testStateHandler) Expire(hook contractModels.ChangeHook) ([]string, error) {
+	panic("implement me")
+}
+
 type testAuthHelper struct{}
 
 func (h testAuthHelper) TokenValid(r *http.Request) (bool, error) {
 	panic("implement me")
 }
 
-func (testAuthHelper) CreateSession(s string, i []string, x []string, t time.Time) error {
+func (testAuthHelper) CreateSession(s string, p auth.Permissions, t time.Time, subject, refreshToken string) error {
 	panic("implement me")
 }
 
@@ -72,6 +137,22 @@ func (testAuthHelper) ContractWriteAccess(r *http.Request) (bool, int, error) {
 	return true, 0, nil
 }
 
+func (testAuthHelper) ContractDeleteAccess(r *http.Request) (bool, int, error) {
+	return true, 0, nil
+}
+
+func (testAuthHelper) AdminAccess(r *http.Request) (bool, int, error) {
+	return true, 0, nil
+}
+
+func (testAuthHelper) Organisations(r *http.Request) ([]string, int, error) {
+	return []string{"org"}, 0, nil
+}
+
+func (testAuthHelper) ReadScope(r *http.Request) (auth.Scope, int, error) {
+	return auth.Scope{Organisations: []string{"org"}}, 0, nil
+}
+
 var (
 	aHandler models.AnalysisHandler   = testAnalysisHandler{}
 	tHandler models.ResultListHandler = testResultHandler{}
@@ -82,6 +163,9 @@ var analyses Analysis = analysis{
 	analysis: analyseLogic{
 		analysisHandler: aHandler,
 		resultHandler:   tHandler,
+		signatures:      testSignatureVerifier{},
+		states:          testStateHandler{},
+		broker:          NewBroker(),
 	},
 	authHelper: aHelper,
 }
@@ -149,6 +233,36 @@ func TestAnalysesPost(t *testing.T) {
 			"a/analyses/error/c/v",
 			validModel,
 		},
+		{
+			"invalid result",
+			400,
+			"a/analyses/t/c/v",
+			`[{"body": {"timestamp": "yesterday", "type": "text"}}]`,
+		},
+		{
+			"invalid signature",
+			403,
+			"a/analyses/invalid-signature/c/v",
+			validModel,
+		},
+		{
+			"unknown contract of the signature",
+			404,
+			"a/analyses/unknown-contract/c/v",
+			validModel,
+		},
+		{
+			"expired contract",
+			410,
+			"a/analyses/expired/c/v",
+			validModel,
+		},
+		{
+			"contract not yet valid",
+			403,
+			"a/analyses/future/c/v",
+			validModel,
+		},
 		{
 			"success",
 			201,
@@ -176,90 +290,198 @@ func TestAnalysesPost(t *testing.T) {
 	}
 }
 
+func TestAnalysesPostPublish(t *testing.T) {
+	messages := &testOutbox{}
+	endpoint := analyses.(analysis)
+	endpoint.messages = messages
+	endpoint.topic = mqtt.Topic{Template: "kosmos/analyses/{contract}/{machine}/{sensor}", QoS: 1}
+
+	req, err := http.NewRequest("POST", "a/analyses/t/c/v", strings.NewReader(validModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("token", "abc")
+
+	rr := httptest.NewRecorder()
+	endpoint.ServeHTTP(rr, req)
+
+	if rr.Code != 201 {
+		t.Fatalf("handler returnes wrong status code: got %d want %d", rr.Code, 201)
+	}
+
+	if len(messages.messages) != 1 {
+		t.Fatalf("expected one queued result, got %d", len(messages.messages))
+	}
+
+	if msg := messages.messages[0]; msg.Topic != "kosmos/analyses/t/c/v" || msg.QoS != 1 {
+		t.Errorf("unexpected message: %v", msg)
+	}
+}
+
+func TestAnalysesPostInvalidTopicLevel(t *testing.T) {
+	messages := &testOutbox{}
+	endpoint := analyses.(analysis)
+	endpoint.messages = messages
+	endpoint.topic = mqtt.Topic{Template: "kosmos/analyses/{contract}/{machine}/{sensor}", QoS: 1}
+
+	req, err := http.NewRequest("POST", "a/analyses/t/c/+", strings.NewReader(validModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("token", "abc")
+
+	rr := httptest.NewRecorder()
+	endpoint.ServeHTTP(rr, req)
+
+	if rr.Code != 400 {
+		t.Errorf("handler returnes wrong status code: got %d want %d", rr.Code, 400)
+	}
+
+	if len(messages.messages) != 0 {
+		t.Errorf("expected no queued result, got %d", len(messages.messages))
+	}
+}
+
 func TestAnalysesGet(t *testing.T) {
 	testTable := []struct {
 		description string
 		statusCode  int
 		path        string
 		data        string
+		next        string
 	}{
 		{
 			"internal error",
 			500,
 			"/analysis/error",
 			"",
+			"",
 		},
 		{
 			"bad request",
 			400,
 			"/analysis",
 			"",
+			"",
+		},
+		{
+			"expired contract",
+			410,
+			"/analysis/expired",
+			"",
+			"",
+		},
+		{
+			"unknown contract",
+			404,
+			"/analysis/unknown/432",
+			"",
+			"",
 		},
 		{
 			"success but not found",
 			200,
 			"/analysis/abc",
 			"",
+			"",
 		},
 		{
 			"analysis one result",
 			200,
 			"/analysis/one",
 			"[{\"resultId\":1,\"machine\":\"\",\"date\":0}]",
+			"",
 		},
 		{
 			"analysis two results",
 			200,
 			"/analysis/two",
 			"[{\"resultId\":1,\"machine\":\"\",\"date\":0},{\"resultId\":2,\"machine\":\"\",\"date\":0}]",
+			"",
 		},
 		{
 			"internal error",
 			500,
 			"/analysis/error/",
 			"",
+			"",
 		},
 		{
 			"sucess empty response",
 			200,
 			"/analyses/abc/",
 			"",
+			"",
 		},
 		{
 			"success one result without machine",
 			200,
 			"/analysis/one/",
 			"[{\"resultId\":1,\"machine\":\"\",\"date\":0}]",
+			"",
 		},
 		{
 			"success two result without machine",
 			200,
 			"/analysis/two/",
 			"[{\"resultId\":1,\"machine\":\"\",\"date\":0},{\"resultId\":2,\"machine\":\"\",\"date\":0}]",
+			"",
 		},
 		{
 			"parse result id",
 			400,
 			"/analysis/error/ab",
 			"",
+			"",
 		},
 		{
 			"internal error",
 			500,
 			"/analysis/error/432",
 			"",
+			"",
 		},
 		{
 			"success analysis with empty response",
 			200,
 			"/analysis/abc/430",
 			"{\"body\":{\"from\":\"\",\"timestamp\":\"\",\"model\":{\"url\":\"\",\"tag\":\"\"},\"type\":\"\",\"calculated\":{\"message\":{\"machine\":\"\",\"sensor\":\"\"},\"received\":\"\"},\"results\":null},\"signature\":\"\"}",
+			"",
 		},
 		{
 			"",
 			200,
 			"/analysis/one/432",
 			"{\"body\":{\"from\":\"\",\"timestamp\":\"\",\"model\":{\"url\":\"\",\"tag\":\"\"},\"type\":\"\",\"calculated\":{\"message\":{\"machine\":\"\",\"sensor\":\"\"},\"received\":\"\"},\"results\":null},\"signature\":\"\"}",
+			"",
+		},
+		{
+			"first page",
+			200,
+			"/analysis/two?limit=1",
+			"[{\"resultId\":1,\"machine\":\"\",\"date\":0}]",
+			"next",
+		},
+		{
+			"invalid limit",
+			400,
+			"/analysis/two?limit=0",
+			"",
+			"",
+		},
+		{
+			"invalid cursor",
+			400,
+			"/analysis/two?cursor=abc",
+			"",
+			"",
+		},
+		{
+			"invalid start",
+			400,
+			"/analysis/two?start=yesterday",
+			"",
+			"",
 		},
 	}
 
@@ -283,9 +505,164 @@ func TestAnalysesGet(t *testing.T) {
 				t.Errorf("%v\thandler returnes wrong data in body: got\n\t %s \nwant \n\t%s", test, rr.Body.String(), test.data)
 			}
 
+			if next := rr.Header().Get(pagination.NextCursorHeader); next != test.next {
+				t.Errorf("handler returnes wrong next cursor: got %s want %s", next, test.next)
+			}
+		})
+	}
+
+}
+
+func TestAnalysesStream(t *testing.T) {
+	testTable := []struct {
+		description string
+		statusCode  int
+		path        string
+		lastEventID string
+		data        string
+	}{
+		{
+			"missed results",
+			200,
+			"/analysis/one/stream",
+			"4",
+			"id: 5\nevent: result\ndata: {\"resultID\":5,\"machine\":\"m\",\"sensor\":\"s\",\"date\":\"\",\"result\":{\"body\":{\"from\":\"\",\"timestamp\":\"\",\"model\":{\"url\":\"\",\"tag\":\"\"},\"type\":\"\",\"calculated\":{\"message\":{\"machine\":\"\",\"sensor\":\"\"},\"received\":\"\"},\"results\":null},\"signature\":\"\"}}\n\n",
+		},
+		{
+			"no missed results",
+			200,
+			"/analysis/abc/stream",
+			"",
+			"",
+		},
+		{
+			"invalid last event id",
+			400,
+			"/analysis/one/stream",
+			"abc",
+			"",
+		},
+		{
+			"expired contract",
+			410,
+			"/analysis/expired/stream",
+			"",
+			"",
+		},
+		{
+			"internal error",
+			500,
+			"/analysis/error/stream",
+			"1",
+			"",
+		},
+	}
+
+	for _, test := range testTable {
+		t.Run(test.description, func(t *testing.T) {
+			// the request is cancelled, so that the handler returns after sending the missed results
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			req, err := http.NewRequestWithContext(ctx, "GET", test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("token", "abc")
+			if test.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", test.lastEventID)
+			}
+
+			rr := httptest.NewRecorder()
+
+			analyses.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != test.statusCode {
+				t.Errorf("handler returnes wrong status code: got %d want %d", status, test.statusCode)
+			}
+
+			if rr.Body.String() != test.data {
+				t.Errorf("handler returnes wrong data in body: got\n\t %q \nwant \n\t%q", rr.Body.String(), test.data)
+			}
 		})
 	}
+}
+
+func TestAnalyseLogic_SubscribeResultsPaged(t *testing.T) {
+	logic := analyseLogic{resultHandler: tHandler, states: testStateHandler{}, broker: NewBroker()}
+
+	missed, _, cancel, err := logic.SubscribeResults("paged", nil, 1000)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer cancel()
+
+	if len(missed) != 1500 {
+		t.Fatalf("expected 1500 missed results, got %d", len(missed))
+	}
+
+	if first, last := missed[0].ID, missed[len(missed)-1].ID; first != 1001 || last != 2500 {
+		t.Errorf("missed results are not complete: first %d, last %d", first, last)
+	}
+}
+
+func TestAnalysesStreamLive(t *testing.T) {
+	b := NewBroker().(broker)
+	endpoint := analysis{
+		analysis: analyseLogic{
+			analysisHandler: aHandler,
+			resultHandler:   tHandler,
+			signatures:      testSignatureVerifier{},
+			states:          testStateHandler{},
+			broker:          b,
+		},
+		authHelper: aHelper,
+	}
 
+	req, err := http.NewRequest("GET", "/analysis/live/stream?machine=m", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("token", "abc")
+
+	rr := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		endpoint.ServeHTTP(rr, req)
+		close(done)
+	}()
+
+	subscribed := func() bool {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		return len(b.subscriptions["live"]) == 1
+	}
+	for !subscribed() {
+		time.Sleep(time.Millisecond)
+	}
+
+	b.Publish("live", models.Result{ID: 1, Machine: "other"})
+	b.Publish("live", models.Result{ID: 2, Machine: "m"})
+
+	// closing the subscription ends the stream after the queued results are sent
+	b.mutex.Lock()
+	for sub := range b.subscriptions["live"] {
+		b.remove("live", sub)
+	}
+	b.mutex.Unlock()
+	<-done
+
+	if !strings.HasPrefix(rr.Body.String(), "id: 2\nevent: result\n") {
+		t.Errorf("handler returnes wrong data in body: %q", rr.Body.String())
+	}
+
+	if strings.Contains(rr.Body.String(), "id: 1\n") {
+		t.Errorf("handler returnes filtered result: %q", rr.Body.String())
+	}
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("handler returnes wrong content type: %s", contentType)
+	}
 }
 
 func TestAnalysesDefault(t *testing.T) {
@@ -0,0 +1,187 @@
+package analysis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"k8s.io/klog"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/analysis/models"
+	contractModels "github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+	mqttModels "github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/outbox"
+)
+
+// ContractResolver returns the contracts of a machine and sensor
+type ContractResolver interface {
+	GetContracts(machine, sensor string) ([]string, error)
+}
+
+// ExecutionTracker tracks the state of the pipeline executions, which requested the received results
+type ExecutionTracker interface {
+	// Complete marks the execution as completed, if it belongs to one of the contracts
+	Complete(execution int64, contracts []string) error
+	// Fail marks the execution as failed
+	Fail(execution int64) error
+}
+
+// ResultIngester stores the analysis results, which are received from the mqtt broker
+type ResultIngester interface {
+	// Handle stores a received result in every active contract of its machine and sensor.
+	// Results, which cannot be stored in any contract, are published on the dead letter topic.
+	Handle(topic string, payload []byte)
+}
+
+// NewResultIngester creates a new result ingester. The executions, which requested the results, are completed
+// or failed by the tracker; if tracker is nil, the executions are not tracked.
+func NewResultIngester(logic AnalyseLogic, contracts ContractResolver, messages outbox.Outbox, deadLetterTopic string, tracker ExecutionTracker) ResultIngester {
+	return resultIngester{logic: logic, contracts: contracts, messages: messages, deadLetterTopic: deadLetterTopic, tracker: tracker}
+}
+
+type resultIngester struct {
+	logic           AnalyseLogic
+	contracts       ContractResolver
+	messages        outbox.Outbox
+	deadLetterTopic string
+	tracker         ExecutionTracker
+}
+
+// deadLetter is published for every result, which cannot be stored
+type deadLetter struct {
+	Topic   string `json:"topic"`
+	Reason  string `json:"reason"`
+	Payload string `json:"payload"`
+}
+
+// toAnalysis converts a received mqtt message to an analysis result
+func toAnalysis(msg mqttModels.Analyse) models.Analysis {
+	var analysis models.Analysis
+	analysis.Body.From = msg.From
+	analysis.Body.Timestamp = msg.Timestamp
+	analysis.Body.Model = models.Model{URL: msg.Model.Url, Tag: msg.Model.Tag}
+	analysis.Body.Type = msg.Type
+	analysis.Body.Calculated.Message.Machine = msg.Calculated.Message.Machine
+	analysis.Body.Calculated.Message.Sensor = msg.Calculated.Message.Sensor
+	analysis.Body.Calculated.Received = msg.Calculated.Received
+	analysis.Body.Results = msg.Results
+	analysis.Signature = msg.Signature
+	return analysis
+}
+
+func (i resultIngester) Handle(topic string, payload []byte) {
+	if err := i.ingest(payload); err != nil {
+		klog.Errorf("cannot ingest result received on topic %s: %s", topic, err)
+		i.deadLetter(topic, payload, err)
+	}
+}
+
+func (i resultIngester) ingest(payload []byte) error {
+	var msg mqttModels.Analyse
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return fmt.Errorf("cannot parse result: %s", err)
+	}
+
+	analysis := toAnalysis(msg)
+	rawBody, err := signedPayload(payload)
+	if err != nil {
+		return fmt.Errorf("cannot parse result: %s", err)
+	}
+	analysis.RawBody = rawBody
+
+	contracts, err := i.store(analysis)
+	i.track(msg.Execution, contracts, err)
+	return err
+}
+
+// signedPayload returns the fields of the received message, which are covered by the signature. These are all fields
+// as they are transmitted, except of the signature itself and the id of the execution.
+func signedPayload(payload []byte) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+
+	delete(fields, "signature")
+	delete(fields, "execution")
+	return json.Marshal(fields)
+}
+
+// track completes the execution of the result in the contracts, in which the result is stored, or fails the
+// execution, if the result cannot be stored
+func (i resultIngester) track(execution int64, contracts []string, err error) {
+	if i.tracker == nil || execution == 0 {
+		return
+	}
+
+	if err != nil {
+		if err := i.tracker.Fail(execution); err != nil {
+			klog.Errorf("cannot fail execution %d: %s", execution, err)
+		}
+		return
+	}
+
+	if err := i.tracker.Complete(execution, contracts); err != nil {
+		klog.Errorf("cannot complete execution %d: %s", execution, err)
+	}
+}
+
+// store inserts the result into every active contract of its machine and sensor and returns the contracts,
+// in which the result is stored
+func (i resultIngester) store(analysis models.Analysis) ([]string, error) {
+	if !analysis.Validate() {
+		return nil, models.ErrInvalidResult
+	}
+
+	machine := analysis.Body.Calculated.Message.Machine
+	sensor := analysis.Body.Calculated.Message.Sensor
+	contracts, err := i.contracts.GetContracts(machine, sensor)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get contracts: %s", err)
+	}
+
+	var inserted []string
+	var insertErr error
+	for _, contract := range contracts {
+		err := i.logic.InsertResult(contract, machine, sensor, []models.Analysis{analysis})
+		var stateErr contractModels.StateError
+		if errors.As(err, &stateErr) {
+			klog.Infof("skip contract %s: %s", contract, err)
+			continue
+		}
+		if err != nil {
+			insertErr = fmt.Errorf("cannot insert result into contract %s: %w", contract, err)
+			continue
+		}
+		inserted = append(inserted, contract)
+	}
+
+	switch {
+	case len(inserted) == 0 && insertErr != nil:
+		return nil, insertErr
+	case len(inserted) == 0:
+		return nil, fmt.Errorf("no active contract of machine %s and sensor %s found", machine, sensor)
+	case insertErr != nil:
+		// the result has been stored in the other contracts
+		klog.Errorf("%s", insertErr)
+	}
+
+	return inserted, nil
+}
+
+func (i resultIngester) deadLetter(topic string, payload []byte, reason error) {
+	if i.deadLetterTopic == "" {
+		return
+	}
+
+	data, err := json.Marshal(deadLetter{Topic: topic, Reason: reason.Error(), Payload: string(payload)})
+	if err != nil {
+		klog.Errorf("cannot create dead letter: %s", err)
+		return
+	}
+
+	if err := i.messages.Enqueue(mqtt.Msg{Topic: i.deadLetterTopic, Msg: data, QoS: 1}); err != nil {
+		klog.Errorf("cannot queue dead letter: %s", err)
+	}
+}
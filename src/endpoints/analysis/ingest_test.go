@@ -0,0 +1,160 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+)
+
+// testOutbox records the queued messages
+type testOutbox struct {
+	messages []mqtt.Msg
+}
+
+func (o *testOutbox) Enqueue(msg mqtt.Msg) error {
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+type testContractResolver struct{}
+
+func (testContractResolver) GetContracts(machine, sensor string) ([]string, error) {
+	switch machine {
+	case "error":
+		return nil, fmt.Errorf("error")
+	case "expired":
+		return []string{"expired"}, nil
+	case "partial":
+		return []string{"error", "t"}, nil
+	default:
+		return []string{"t"}, nil
+	}
+}
+
+// testTracker records the states of the executions
+type testTracker struct {
+	completed map[int64][]string
+	failed    []int64
+}
+
+func (t *testTracker) Complete(execution int64, contracts []string) error {
+	t.completed[execution] = contracts
+	return nil
+}
+
+func (t *testTracker) Fail(execution int64) error {
+	t.failed = append(t.failed, execution)
+	return nil
+}
+
+func ingestPayload(machine string) string {
+	return fmt.Sprintf(`{
+  "from": "creator of this message",
+  "timestamp": "2020-08-12T15:46:10.821Z",
+  "model": {"url": "abc", "tag": "ab"},
+  "type": "text",
+  "calculated": {"message": {"machine": "%s", "sensor": "134wdsf"}, "received": "2020-08-12T15:47:10.821Z"},
+  "results": {"total": "stop", "predict": 80, "parts": []},
+  "signature": "",
+  "execution": 1
+}`, machine)
+}
+
+func TestResultIngester_Handle(t *testing.T) {
+	testTable := []struct {
+		description string
+		payload     string
+		deadLetter  bool
+		completed   []string
+		failed      bool
+	}{
+		{
+			"stored result",
+			ingestPayload("abc"),
+			false,
+			[]string{"t"},
+			false,
+		},
+		{
+			"stored in one of the contracts",
+			ingestPayload("partial"),
+			false,
+			[]string{"t"},
+			false,
+		},
+		{
+			"invalid json",
+			"{",
+			true,
+			nil,
+			false,
+		},
+		{
+			"invalid result",
+			`{"type": "unknown", "timestamp": "2020-08-12T15:46:10.821Z", "execution": 1}`,
+			true,
+			nil,
+			true,
+		},
+		{
+			"contracts cannot be queried",
+			ingestPayload("error"),
+			true,
+			nil,
+			true,
+		},
+		{
+			"no active contract",
+			ingestPayload("expired"),
+			true,
+			nil,
+			true,
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			messages := &testOutbox{}
+			tracker := &testTracker{completed: make(map[int64][]string)}
+			ingester := NewResultIngester(analyses.(analysis).analysis, testContractResolver{}, messages, "dead-letter", tracker)
+
+			ingester.Handle("kosmos/analyses/results/abc", []byte(v.payload))
+
+			if !reflect.DeepEqual(tracker.completed[1], v.completed) {
+				t.Errorf("expected execution completed in %v, got %v", v.completed, tracker.completed[1])
+			}
+
+			if failed := len(tracker.failed) == 1 && tracker.failed[0] == 1; failed != v.failed {
+				t.Errorf("expected execution failed %t, got %v", v.failed, tracker.failed)
+			}
+
+			if !v.deadLetter {
+				if len(messages.messages) != 0 {
+					t.Errorf("unexpected dead letter: %s", messages.messages[0].Msg)
+				}
+				return
+			}
+
+			if len(messages.messages) != 1 {
+				t.Fatalf("expected dead letter")
+			}
+
+			msg := messages.messages[0]
+			if msg.Topic != "dead-letter" {
+				t.Errorf("dead letter is published on topic %s", msg.Topic)
+			}
+
+			var letter deadLetter
+			if err := json.Unmarshal(msg.Msg, &letter); err != nil {
+				t.Fatalf("cannot parse dead letter: %s", err)
+			}
+
+			if letter.Topic != "kosmos/analyses/results/abc" || letter.Payload != v.payload || letter.Reason == "" {
+				t.Errorf("unexpected dead letter: %v", letter)
+			}
+		})
+	}
+}
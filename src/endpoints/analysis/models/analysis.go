@@ -3,14 +3,21 @@ package models
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"k8s.io/klog"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/signature"
 )
 
+// ErrInvalidResult will be returned, if a transmitted analysis result is not valid
+var ErrInvalidResult = errors.New("invalid analysis result")
+
 type AnalysisHandler interface {
-	Insert(string, string, string, Analysis) error
+	// Insert stores the results in one transaction and returns their ids
+	Insert(string, string, string, []Analysis) ([]int64, error)
 	Query(string, int64) (Analysis, error)
 }
 
@@ -22,39 +29,52 @@ func NewAnalysisHandler(db *sql.DB) AnalysisHandler {
 	return analysisHandler{db: db}
 }
 
-func (a analysisHandler) Insert(contractID string, machineID string, sensorID string, analysis Analysis) error {
-	query, err := a.db.Query("SELECT cms.id FROM contract_machine_sensors AS cms JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE cms.contract = $1 AND ms.machine = $2 AND s.transmitted_id = $3",
-		contractID,
-		machineID,
-		sensorID,
-	)
-
+func (a analysisHandler) Insert(contractID string, machineID string, sensorID string, analyses []Analysis) ([]int64, error) {
+	tx, err := a.db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	defer func() {
-		if err := query.Close(); err != nil {
-			klog.Errorf("cannot close query object: %s", err)
+	ids, err := a.insert(tx, contractID, machineID, sensorID, analyses)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			klog.Errorf("cannot rollback transaction: %s", rbErr)
 		}
-	}()
+		return nil, err
+	}
+
+	return ids, tx.Commit()
+}
 
+func (a analysisHandler) insert(tx *sql.Tx, contractID string, machineID string, sensorID string, analyses []Analysis) ([]int64, error) {
 	var cmsId int64
-	if !query.Next() {
-		return fmt.Errorf("no matching contract-machine-sensor combination found")
+	err := tx.QueryRow("SELECT cms.id FROM contract_machine_sensors AS cms JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE cms.contract = $1 AND cms.active AND ms.machine = $2 AND s.transmitted_id = $3",
+		contractID,
+		machineID,
+		sensorID,
+	).Scan(&cmsId)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no matching contract-machine-sensor combination found")
 	}
-
-	if err := query.Scan(&cmsId); err != nil {
-		return err
+	if err != nil {
+		return nil, err
 	}
 
-	data, err := json.Marshal(analysis)
-	if err != nil {
-		return err
+	var ids []int64
+	for _, analysis := range analyses {
+		data, err := json.Marshal(analysis)
+		if err != nil {
+			return nil, err
+		}
+
+		var id int64
+		if err := tx.QueryRow("INSERT INTO analysis_result (contract_machine_sensor, time, result) VALUES ($1, $2, $3) RETURNING id", cmsId, analysis.Body.Timestamp, string(data)).Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
 	}
 
-	_, err = a.db.Exec("INSERT INTO analysis_result (contract_machine_sensor, time, result) VALUES ($1, $2, $3)", cmsId, analysis.Body.Timestamp, string(data))
-	return err
+	return ids, nil
 }
 
 func (a analysisHandler) Query(contractID string, resultID int64) (Analysis, error) {
@@ -102,8 +122,19 @@ type Body struct {
 }
 
 type Analysis struct {
-	Body      Body   `json:"body"`
-	Signature string `json:"signature"`
+	Body      Body                `json:"body"`
+	Signature signature.Signature `json:"signature"`
+	// RawBody is the body as it is received
+	RawBody json.RawMessage `json:"-"`
+}
+
+// SignedBody returns the part of the analysis, which is covered by the signature; the received body is preferred
+// over the parsed one, because fields unknown to the connector are signed as well
+func (a Analysis) SignedBody() interface{} {
+	if a.RawBody != nil {
+		return a.RawBody
+	}
+	return a.Body
 }
 
 func (a Analysis) Validate() bool {
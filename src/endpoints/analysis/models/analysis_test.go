@@ -1,7 +1,6 @@
 package models
 
 import (
-	"database/sql/driver"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -11,6 +10,8 @@ import (
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/signature"
 )
 
 func TestAnalysis_Validate(t *testing.T) {
@@ -84,7 +85,7 @@ var ana = Analysis{
 		},
 		Results: nil,
 	},
-	Signature: "",
+	Signature: signature.Signature{},
 }
 
 func TestAnalysisHandler_Insert(t *testing.T) {
@@ -96,8 +97,8 @@ func TestAnalysisHandler_Insert(t *testing.T) {
 		cmsID       int64
 		cmsIdSQL    *sqlmock.Rows
 		err         error
-		analysis    Analysis
-		result      driver.Result
+		analyses    []Analysis
+		resultIDs   []int64
 	}{
 		{
 			"successfully insertion",
@@ -107,8 +108,8 @@ func TestAnalysisHandler_Insert(t *testing.T) {
 			6,
 			sqlmock.NewRows([]string{"id"}).AddRow(6),
 			nil,
-			ana,
-			sqlmock.NewResult(0, 0),
+			[]Analysis{ana, ana},
+			[]int64{7, 8},
 		},
 		{
 			"error not matching contract-machine-sensor",
@@ -118,8 +119,8 @@ func TestAnalysisHandler_Insert(t *testing.T) {
 			0,
 			sqlmock.NewRows([]string{"id"}),
 			fmt.Errorf("no matching contract-machine-sensor combination found"),
-			Analysis{},
-			sqlmock.NewResult(0, 0),
+			[]Analysis{{}},
+			nil,
 		},
 	}
 
@@ -132,21 +133,33 @@ func TestAnalysisHandler_Insert(t *testing.T) {
 
 			defer db.Close()
 
-			data, err := json.Marshal(v.analysis)
-			if err != nil {
-				t.Fatalf("cannot marshal analysis")
-			}
-
-			mock.ExpectQuery("SELECT cms.id FROM contract_machine_sensors AS cms JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE cms.contract = $1 AND ms.machine = $2 AND s.transmitted_id = $3").
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT cms.id FROM contract_machine_sensors AS cms JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE cms.contract = $1 AND cms.active AND ms.machine = $2 AND s.transmitted_id = $3").
 				WithArgs(v.contractID, v.machineID, v.sensorID).
 				WillReturnRows(v.cmsIdSQL)
 
-			mock.ExpectExec("INSERT INTO analysis_result (contract_machine_sensor, time, result) VALUES ($1, $2, $3)").
-				WithArgs(v.cmsID, v.analysis.Body.Timestamp, string(data)).
-				WillReturnResult(v.result)
+			for i, id := range v.resultIDs {
+				data, err := json.Marshal(v.analyses[i])
+				if err != nil {
+					t.Fatalf("cannot marshal analysis")
+				}
+
+				mock.ExpectQuery("INSERT INTO analysis_result (contract_machine_sensor, time, result) VALUES ($1, $2, $3) RETURNING id").
+					WithArgs(v.cmsID, v.analyses[i].Body.Timestamp, string(data)).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(id))
+			}
+
+			if v.err == nil {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
 
 			aH := analysisHandler{db: db}
-			err = aH.Insert(v.contractID, v.machineID, v.sensorID, v.analysis)
+			ids, err := aH.Insert(v.contractID, v.machineID, v.sensorID, v.analyses)
+			if !reflect.DeepEqual(ids, v.resultIDs) {
+				t.Errorf("returned ids != expected ids\n\t%v != %v", ids, v.resultIDs)
+			}
 			if err != nil && v.err != nil {
 				if err.Error() != v.err.Error() {
 					t.Errorf("returned error != expected error\n\t%s != %s", err, v.err)
@@ -154,6 +167,10 @@ func TestAnalysisHandler_Insert(t *testing.T) {
 			} else if err != nil || v.err != nil {
 				t.Errorf("returned error != expected error\n\t%s != %s", err, v.err)
 			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
 		})
 	}
 }
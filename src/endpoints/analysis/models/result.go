@@ -3,15 +3,171 @@ package models
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"k8s.io/klog"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
 )
 
+// ErrInvalidFilter will be returned, if the filter of the result list cannot be parsed
+var ErrInvalidFilter = errors.New("invalid result filter")
+
+// ResultFilter restricts the results of a contract. Each list matches a result, if it is empty
+// or contains the value of the result.
+type ResultFilter struct {
+	Machines []string
+	Sensors  []string
+	// Models contains the urls of the models, optionally followed by a colon and the tag
+	Models []string
+	Types  []string
+	Start  time.Time
+	End    time.Time
+}
+
+// splitValues returns the values of repeated query parameters and comma separated lists
+func splitValues(values []string) []string {
+	var split []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				split = append(split, v)
+			}
+		}
+	}
+	return split
+}
+
+// ParseResultFilter creates a result filter from the query parameters of a request
+func ParseResultFilter(queryParams map[string][]string) (ResultFilter, error) {
+	var filter ResultFilter
+	for i, v := range queryParams {
+		switch i {
+		case "machine":
+			filter.Machines = append(filter.Machines, splitValues(v)...)
+		case "sensor":
+			filter.Sensors = append(filter.Sensors, splitValues(v)...)
+		case "model":
+			filter.Models = append(filter.Models, splitValues(v)...)
+		case "type":
+			filter.Types = append(filter.Types, splitValues(v)...)
+		case "start", "end":
+			if len(v) != 1 {
+				return filter, fmt.Errorf("%w: unexpected length of the query parameter %s", ErrInvalidFilter, i)
+			}
+
+			date, err := time.Parse(time.RFC3339, v[0])
+			if err != nil {
+				return filter, fmt.Errorf("%w: %s", ErrInvalidFilter, err)
+			}
+
+			if i == "start" {
+				filter.Start = date
+			} else {
+				filter.End = date
+			}
+		}
+	}
+
+	return filter, nil
+}
+
+// conditions appends the sql conditions and their arguments of the filter
+func (f ResultFilter) conditions(queryWhere []string, argWhere []interface{}) ([]string, []interface{}) {
+	if len(f.Machines) > 0 {
+		argWhere = append(argWhere, pq.Array(f.Machines))
+		queryWhere = append(queryWhere, fmt.Sprintf("ms.machine = ANY($%d)", len(argWhere)))
+	}
+
+	if len(f.Sensors) > 0 {
+		argWhere = append(argWhere, pq.Array(f.Sensors))
+		queryWhere = append(queryWhere, fmt.Sprintf("s.transmitted_id = ANY($%d)", len(argWhere)))
+	}
+
+	if len(f.Models) > 0 {
+		argWhere = append(argWhere, pq.Array(f.Models))
+		queryWhere = append(queryWhere, fmt.Sprintf("(ar.result->'body'->'model'->>'url' = ANY($%d) OR concat(ar.result->'body'->'model'->>'url', ':', ar.result->'body'->'model'->>'tag') = ANY($%d))", len(argWhere), len(argWhere)))
+	}
+
+	if len(f.Types) > 0 {
+		argWhere = append(argWhere, pq.Array(f.Types))
+		queryWhere = append(queryWhere, fmt.Sprintf("ar.result->'body'->>'type' = ANY($%d)", len(argWhere)))
+	}
+
+	if !f.Start.IsZero() {
+		argWhere = append(argWhere, f.Start)
+		queryWhere = append(queryWhere, fmt.Sprintf("ar.time >= $%d", len(argWhere)))
+	}
+
+	if !f.End.IsZero() {
+		argWhere = append(argWhere, f.End)
+		queryWhere = append(queryWhere, fmt.Sprintf("ar.time <= $%d", len(argWhere)))
+	}
+
+	return queryWhere, argWhere
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches checks, if a result of the machine and sensor is included by the filter
+func (f ResultFilter) Matches(machine, sensor string, analysis Analysis) bool {
+	if len(f.Machines) > 0 && !contains(f.Machines, machine) {
+		return false
+	}
+
+	if len(f.Sensors) > 0 && !contains(f.Sensors, sensor) {
+		return false
+	}
+
+	model := analysis.Body.Model
+	if len(f.Models) > 0 && !contains(f.Models, model.URL) && !contains(f.Models, fmt.Sprintf("%s:%s", model.URL, model.Tag)) {
+		return false
+	}
+
+	if len(f.Types) > 0 && !contains(f.Types, analysis.Body.Type) {
+		return false
+	}
+
+	if f.Start.IsZero() && f.End.IsZero() {
+		return true
+	}
+
+	date, err := time.Parse(time.RFC3339, analysis.Body.Timestamp)
+	if err != nil {
+		return false
+	}
+
+	return !date.Before(f.Start) && (f.End.IsZero() || !date.After(f.End))
+}
+
+// Result is a stored analysis result together with its machine and sensor
+type Result struct {
+	ID       int64    `json:"resultID"`
+	Machine  string   `json:"machine"`
+	Sensor   string   `json:"sensor"`
+	Date     string   `json:"date"`
+	Analysis Analysis `json:"result"`
+}
+
 type ResultListHandler interface {
-	Get(string, map[string][]string) ([]byte, error)
+	// Get returns a page of the results of a contract and the cursor of the next page
+	Get(string, ResultFilter, pagination.Page) ([]byte, string, error)
+
+	// Since returns the results of a contract, which have been inserted after the result with the id.
+	// At most pagination.MaxLimit results are returned; the next page starts after the last returned result.
+	Since(string, ResultFilter, int64) ([]Result, error)
 }
 
 type resultList struct {
@@ -28,113 +184,33 @@ func NewResultList(db *sql.DB) ResultListHandler {
 	return resultListHandler{db: db}
 }
 
-func (r resultListHandler) Get(contractID string, queryParams map[string][]string) ([]byte, error) {
+func (r resultListHandler) Get(contractID string, filter ResultFilter, page pagination.Page) ([]byte, string, error) {
 	var queryWhere []string
 	var argWhere []interface{}
 
 	klog.V(2).Infof("contractID %s", contractID)
 
-	queryWhere = append(queryWhere, "contract = $1")
+	queryWhere = append(queryWhere, "cms.contract = $1")
 	argWhere = append(argWhere, contractID)
 
-	var counter = 2
-	for i, v := range queryParams {
-		if len(v) != 1 {
-			return nil, fmt.Errorf("unexpected length of the query parameters")
-		}
-
-		switch i {
-		case "machine":
-			ids, err := func() ([]string, error) {
-				query, err := r.db.Query("SELECT cms.id FROM contract_machine_sensors as cms JOIN machine_sensors ms on cms.machine_sensor = ms.id WHERE ms.machine = $1",
-					v[0],
-				)
-				if err != nil {
-					return nil, err
-				}
-
-				defer func() {
-					if err := query.Close(); err != nil {
-						klog.Errorf("cannot close query object: %s", err)
-					}
-				}()
-
-				var ids []string
-				for query.Next() {
-					var id int64
-					if err := query.Scan(&id); err != nil {
-						return nil, err
-					}
-
-					ids = append(ids, fmt.Sprintf("%d", id))
-				}
-
-				return ids, nil
-			}()
-
-			if err != nil {
-				return nil, err
-			}
-
-			queryWhere = append(queryWhere, fmt.Sprintf("contract_machine_sensor in ($%d)", counter))
-			argWhere = append(argWhere, strings.Join(ids, ","))
-		case "sensor":
-			ids, err := func() ([]string, error) {
-				query, err := r.db.Query("SELECT cms.id FROM contract_machine_sensors as cms JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE s.transmitted_id = $1",
-					v[0],
-				)
-				if err != nil {
-					return nil, err
-				}
-
-				defer func() {
-					if err := query.Close(); err != nil {
-						klog.Errorf("cannot close query object: %s", err)
-					}
-				}()
-
-				var ids []string
-				for query.Next() {
-					var id int64
-					if err := query.Scan(&id); err != nil {
-						return nil, err
-					}
-					ids = append(ids, fmt.Sprintf("%d", id))
-				}
-
-				return ids, nil
-			}()
-
-			if err != nil {
-				return nil, err
-			}
+	queryWhere, argWhere = filter.conditions(queryWhere, argWhere)
 
-			queryWhere = append(queryWhere, fmt.Sprintf("contract_machine_sensor in ($%d)", counter))
-			argWhere = append(argWhere, strings.Join(ids, ","))
-		case "start":
-			_, err := time.Parse(time.RFC3339, v[0])
-			if err != nil {
-				return nil, err
-			}
-			queryWhere = append(queryWhere, fmt.Sprintf("time >= $%d", counter))
-			argWhere = append(argWhere, v[0])
-		case "end":
-			_, err := time.Parse(time.RFC3339, v[0])
-			if err != nil {
-				return nil, err
-			}
-			queryWhere = append(queryWhere, fmt.Sprintf("time <= $%d", counter))
-			argWhere = append(argWhere, v[0])
-		}
-		counter++
+	if condition, args := page.Condition("ar.time", "ar.id", len(argWhere)+1); condition != "" {
+		queryWhere = append(queryWhere, condition)
+		argWhere = append(argWhere, args...)
 	}
 
+	limits, limitArgs := page.Limits(len(argWhere) + 1)
+
 	where := fmt.Sprintf("WHERE %s", strings.Join(queryWhere, " AND "))
 	klog.V(2).Infof("WHERE clause: %s\nvalues: %v", where, argWhere)
 
-	query, err := r.db.Query(fmt.Sprintf("SELECT ar.id, ar.time, ms.machine FROM analysis_result AS ar JOIN contract_machine_sensors cms on cms.id = ar.contract_machine_sensor JOIN machine_sensors ms on cms.machine_sensor = ms.id %s", where), argWhere...)
+	query, err := r.db.Query(
+		fmt.Sprintf("SELECT ar.id, ar.time, ms.machine FROM analysis_result AS ar JOIN contract_machine_sensors cms on cms.id = ar.contract_machine_sensor JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id %s %s %s", where, page.OrderBy("ar.time", "ar.id"), limits),
+		append(argWhere, limitArgs...)...,
+	)
 	if err != nil {
-		return nil, fmt.Errorf("return query: %s", err)
+		return nil, "", fmt.Errorf("return query: %s", err)
 	}
 
 	defer func() {
@@ -144,12 +220,18 @@ func (r resultListHandler) Get(contractID string, queryParams map[string][]strin
 	}()
 
 	var res []resultList
+	count := 0
 	for query.Next() {
+		// the additional row shows only, that a next page exists
+		if count++; count > page.Limit {
+			continue
+		}
+
 		var id int64
 		var machineID, timestamp string
 
 		if err := query.Scan(&id, &timestamp, &machineID); err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
 		res = append(res, resultList{
@@ -159,5 +241,52 @@ func (r resultListHandler) Get(contractID string, queryParams map[string][]strin
 		})
 	}
 
-	return json.Marshal(res)
+	var next string
+	if count > page.Limit {
+		last := res[len(res)-1]
+		date, err := time.Parse(time.RFC3339Nano, last.Date)
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot parse time of result %d: %s", last.Id, err)
+		}
+		next = page.Next(count, pagination.Cursor{Time: date, ID: strconv.FormatInt(last.Id, 10)})
+	}
+
+	data, err := json.Marshal(res)
+	return data, next, err
+}
+
+func (r resultListHandler) Since(contractID string, filter ResultFilter, id int64) ([]Result, error) {
+	queryWhere, argWhere := filter.conditions([]string{"cms.contract = $1", "ar.id > $2"}, []interface{}{contractID, id})
+	argWhere = append(argWhere, pagination.MaxLimit)
+
+	query, err := r.db.Query(
+		fmt.Sprintf("SELECT ar.id, ar.time, ms.machine, s.transmitted_id, ar.result FROM analysis_result AS ar JOIN contract_machine_sensors cms on cms.id = ar.contract_machine_sensor JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE %s ORDER BY ar.id LIMIT $%d", strings.Join(queryWhere, " AND "), len(argWhere)),
+		argWhere...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	var results []Result
+	for query.Next() {
+		var result Result
+		var data string
+		if err := query.Scan(&result.ID, &result.Date, &result.Machine, &result.Sensor, &data); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(data), &result.Analysis); err != nil {
+			return nil, fmt.Errorf("cannot parse result %d: %s", result.ID, err)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
 }
@@ -2,198 +2,320 @@ package models
 
 import (
 	"database/sql/driver"
-	"fmt"
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
 )
 
-func TestResultListHandler_Get(t *testing.T) {
+func TestParseResultFilter(t *testing.T) {
+	start := time.Date(2020, 9, 23, 10, 24, 55, 0, time.UTC)
+
 	testTable := []struct {
 		description string
-		contractID  string
 		params      map[string][]string
+		filter      ResultFilter
 		err         error
-		ret         []byte
-		args        []driver.Value
-		rows        *sqlmock.Rows
-		machineRow  *sqlmock.Rows
-		sensorRow   *sqlmock.Rows
 	}{
 		{
-			"empty get without params",
-			"contract",
-			nil,
-			nil,
-			[]byte("null"),
-			[]driver.Value{"contract"},
-			sqlmock.NewRows([]string{"id", "time", "machine"}),
+			"no params",
 			nil,
+			ResultFilter{},
 			nil,
 		},
 		{
-			"get without params",
-			"contract",
-			nil,
-			nil,
-			[]byte("[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"}]"),
-			[]driver.Value{"contract"},
-			sqlmock.NewRows([]string{"id", "time", "machine"}).AddRow(4, "data", "mach"),
-			nil,
+			"repeated params",
+			map[string][]string{"machine": {"a", "b"}, "sensor": {"s"}},
+			ResultFilter{Machines: []string{"a", "b"}, Sensors: []string{"s"}},
 			nil,
 		},
 		{
-			"get two results without params",
-			"contract",
-			nil,
-			nil,
-			[]byte("[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"},{\"resultID\":5,\"machine\":\"mach\",\"date\":\"data\"}]"),
-			[]driver.Value{"contract"},
-			sqlmock.NewRows([]string{"id", "time", "machine"}).AddRow(4, "data", "mach").AddRow(5, "data", "mach"),
-			nil,
+			"comma separated lists",
+			map[string][]string{"model": {"url:tag, url2"}, "type": {"text,", "time_series"}},
+			ResultFilter{Models: []string{"url:tag", "url2"}, Types: []string{"text", "time_series"}},
 			nil,
 		},
 		{
-			"empty get with not nil params",
-			"contract",
-			map[string][]string{},
-			nil,
-			[]byte("null"),
-			[]driver.Value{"contract"},
-			sqlmock.NewRows([]string{"id", "time", "machine"}),
-			nil,
+			"start",
+			map[string][]string{"start": {"2020-09-23T10:24:55Z"}},
+			ResultFilter{Start: start},
 			nil,
 		},
 		{
-			"empty get with start array != 1",
-			"contract",
-			map[string][]string{
-				"start": {"2020-09-23T10:24:55Z", "b"},
-			},
-			fmt.Errorf("unexpected length of the query parameters"),
-			[]byte{},
-			[]driver.Value{"contract", "2020-09-23T10:24:55Z"},
-			sqlmock.NewRows([]string{"id", "time", "machine"}),
-			nil,
-			nil,
+			"start array != 1",
+			map[string][]string{"start": {"2020-09-23T10:24:55Z", "b"}},
+			ResultFilter{},
+			ErrInvalidFilter,
 		},
 		{
-			"empty get with start",
-			"contract",
-			map[string][]string{
-				"start": {"2020-09-23T10:24:55Z"},
-			},
-			nil,
-			[]byte{},
-			[]driver.Value{"contract", "2020-09-23T10:24:55Z"},
+			"invalid end",
+			map[string][]string{"end": {"yesterday"}},
+			ResultFilter{},
+			ErrInvalidFilter,
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			filter, err := ParseResultFilter(v.params)
+			if !errors.Is(err, v.err) {
+				t.Fatalf("expected error != returned error\n\t%s != %s", v.err, err)
+			}
+
+			if err == nil && !reflect.DeepEqual(filter, v.filter) {
+				t.Errorf("returned filter != expected filter\n\t%v != %v", filter, v.filter)
+			}
+		})
+	}
+}
+
+func TestResultListHandler_Get(t *testing.T) {
+	start := time.Date(2020, 9, 23, 10, 24, 55, 0, time.UTC)
+	selectQuery := "SELECT ar.id, ar.time, ms.machine FROM analysis_result AS ar JOIN contract_machine_sensors cms on cms.id = ar.contract_machine_sensor JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE cms.contract = $1"
+	order := " ORDER BY ar.time ASC, ar.id ASC"
+
+	testTable := []struct {
+		description string
+		filter      ResultFilter
+		query       string
+		args        []driver.Value
+		rows        *sqlmock.Rows
+		ret         string
+	}{
+		{
+			"empty get without filter",
+			ResultFilter{},
+			selectQuery + order + " LIMIT $2 OFFSET $3",
+			[]driver.Value{"contract"},
 			sqlmock.NewRows([]string{"id", "time", "machine"}),
-			nil,
-			nil,
+			"null",
 		},
 		{
-			"get with start",
-			"contract",
-			map[string][]string{
-				"start": {"2020-09-23T10:24:55Z"},
-			},
-			nil,
-			[]byte("[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"}]"),
-			[]driver.Value{"contract", "2020-09-23T10:24:55Z"},
+			"get without filter",
+			ResultFilter{},
+			selectQuery + order + " LIMIT $2 OFFSET $3",
+			[]driver.Value{"contract"},
 			sqlmock.NewRows([]string{"id", "time", "machine"}).AddRow(4, "data", "mach"),
-			nil,
-			nil,
+			"[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"}]",
 		},
 		{
-			"get with start 2 results",
-			"contract",
-			map[string][]string{
-				"start": {"2020-09-23T10:24:55Z"},
-			},
-			nil,
-			[]byte("[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"},{\"resultID\":5,\"machine\":\"mach\",\"date\":\"data\"}]"),
-			[]driver.Value{"contract", "2020-09-23T10:24:55Z"},
+			"get two results without filter",
+			ResultFilter{},
+			selectQuery + order + " LIMIT $2 OFFSET $3",
+			[]driver.Value{"contract"},
 			sqlmock.NewRows([]string{"id", "time", "machine"}).AddRow(4, "data", "mach").AddRow(5, "data", "mach"),
-			nil,
-			nil,
+			"[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"},{\"resultID\":5,\"machine\":\"mach\",\"date\":\"data\"}]",
+		},
+		{
+			"get with start",
+			ResultFilter{Start: start},
+			selectQuery + " AND ar.time >= $2" + order + " LIMIT $3 OFFSET $4",
+			[]driver.Value{"contract", start},
+			sqlmock.NewRows([]string{"id", "time", "machine"}).AddRow(4, "data", "mach"),
+			"[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"}]",
 		},
 		{
 			"get with end",
-			"contract",
-			map[string][]string{
-				"end": {"2020-09-23T10:24:55Z"},
-			},
-			nil,
-			[]byte("[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"}]"),
-			[]driver.Value{"contract", "2020-09-23T10:24:55Z"},
+			ResultFilter{End: start},
+			selectQuery + " AND ar.time <= $2" + order + " LIMIT $3 OFFSET $4",
+			[]driver.Value{"contract", start},
 			sqlmock.NewRows([]string{"id", "time", "machine"}).AddRow(4, "data", "mach"),
-			nil,
-			nil,
+			"[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"}]",
 		},
 		{
 			"get with machine",
-			"contract",
-			map[string][]string{
-				"machine": {"machine"},
-			},
-			nil,
-			[]byte("[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"}]"),
-			[]driver.Value{"contract", "5"},
+			ResultFilter{Machines: []string{"machine"}},
+			selectQuery + " AND ms.machine = ANY($2)" + order + " LIMIT $3 OFFSET $4",
+			[]driver.Value{"contract", "{\"machine\"}"},
 			sqlmock.NewRows([]string{"id", "time", "machine"}).AddRow(4, "data", "mach"),
-			sqlmock.NewRows([]string{"machine"}).AddRow(5),
-			nil,
+			"[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"}]",
 		},
 		{
-			"get with sensor",
-			"contract",
-			map[string][]string{
-				"sensor": {"sensor"},
-			},
-			nil,
-			[]byte("[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"}]"),
-			[]driver.Value{"contract", "5"},
+			"get with multiple machines and sensors",
+			ResultFilter{Machines: []string{"m1", "m2"}, Sensors: []string{"s1", "s2"}},
+			selectQuery + " AND ms.machine = ANY($2) AND s.transmitted_id = ANY($3)" + order + " LIMIT $4 OFFSET $5",
+			[]driver.Value{"contract", "{\"m1\",\"m2\"}", "{\"s1\",\"s2\"}"},
+			sqlmock.NewRows([]string{"id", "time", "machine"}).AddRow(4, "data", "m1").AddRow(5, "data", "m2"),
+			"[{\"resultID\":4,\"machine\":\"m1\",\"date\":\"data\"},{\"resultID\":5,\"machine\":\"m2\",\"date\":\"data\"}]",
+		},
+		{
+			"get with model and type",
+			ResultFilter{Models: []string{"url:tag"}, Types: []string{"text"}},
+			selectQuery + " AND (ar.result->'body'->'model'->>'url' = ANY($2) OR concat(ar.result->'body'->'model'->>'url', ':', ar.result->'body'->'model'->>'tag') = ANY($2)) AND ar.result->'body'->>'type' = ANY($3)" + order + " LIMIT $4 OFFSET $5",
+			[]driver.Value{"contract", "{\"url:tag\"}", "{\"text\"}"},
 			sqlmock.NewRows([]string{"id", "time", "machine"}).AddRow(4, "data", "mach"),
-			nil,
-			sqlmock.NewRows([]string{"sensor"}).AddRow(5),
+			"[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"data\"}]",
 		},
 	}
 
 	for _, v := range testTable {
 		t.Run(v.description, func(t *testing.T) {
-			db, mock, err := sqlmock.New()
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 			if err != nil {
 				t.Fatalf("cannot create mocked database: %s", err)
 			}
 
 			defer db.Close()
 
-			if v.machineRow != nil {
-				mock.ExpectQuery("SELECT cms.id FROM contract_machine_sensors as cms JOIN machine_sensors ms on cms.machine_sensor = ms.id").
-					WithArgs("machine").
-					WillReturnRows(v.machineRow)
+			mock.ExpectQuery(v.query).
+				WithArgs(append(v.args, pagination.DefaultLimit+1, 0)...).
+				WillReturnRows(v.rows)
+
+			data, _, err := NewResultList(db).Get("contract", v.filter, pagination.Page{Limit: pagination.DefaultLimit, Order: pagination.Ascending})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
 			}
 
-			if v.sensorRow != nil {
-				mock.ExpectQuery("SELECT cms.id FROM contract_machine_sensors as cms JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id").
-					WithArgs("sensor").
-					WillReturnRows(v.sensorRow)
+			if string(data) != v.ret {
+				t.Errorf("returned data != expected data\n\t%s != %s", string(data), v.ret)
 			}
 
-			mock.ExpectQuery("SELECT ar.id, ar.time, ms.machine FROM analysis_result AS ar JOIN contract_machine_sensors cms on cms.id = ar.contract_machine_sensor JOIN machine_sensors ms on cms.machine_sensor = ms.id").
-				WithArgs(v.args...).
-				WillReturnRows(v.rows)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestResultListHandler_GetPage(t *testing.T) {
+	first := time.Date(2020, 9, 23, 10, 24, 55, 0, time.UTC)
+	second := first.Add(time.Minute)
+	cursor := pagination.Cursor{Time: first, ID: "4"}
+
+	testTable := []struct {
+		description string
+		page        pagination.Page
+		query       string
+		args        []driver.Value
+		rows        *sqlmock.Rows
+		ret         string
+		next        string
+	}{
+		{
+			"first page",
+			pagination.Page{Limit: 1, Order: pagination.Ascending},
+			"SELECT ar.id, ar.time, ms.machine FROM analysis_result AS ar JOIN contract_machine_sensors cms on cms.id = ar.contract_machine_sensor JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE cms.contract = $1 ORDER BY ar.time ASC, ar.id ASC LIMIT $2 OFFSET $3",
+			[]driver.Value{"contract", 2, 0},
+			sqlmock.NewRows([]string{"id", "time", "machine"}).AddRow(4, first, "mach").AddRow(5, second, "mach"),
+			"[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"2020-09-23T10:24:55Z\"}]",
+			cursor.Encode(),
+		},
+		{
+			"last page after cursor in descending order",
+			pagination.Page{Limit: 1, Order: pagination.Descending, Cursor: &pagination.Cursor{Time: second, ID: "5"}},
+			"SELECT ar.id, ar.time, ms.machine FROM analysis_result AS ar JOIN contract_machine_sensors cms on cms.id = ar.contract_machine_sensor JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE cms.contract = $1 AND (ar.time, ar.id) < ($2, $3) ORDER BY ar.time DESC, ar.id DESC LIMIT $4 OFFSET $5",
+			[]driver.Value{"contract", second, "5", 2, 0},
+			sqlmock.NewRows([]string{"id", "time", "machine"}).AddRow(4, first, "mach"),
+			"[{\"resultID\":4,\"machine\":\"mach\",\"date\":\"2020-09-23T10:24:55Z\"}]",
+			"",
+		},
+		{
+			"page with offset",
+			pagination.Page{Limit: 10, Offset: 20, Order: pagination.Ascending},
+			"SELECT ar.id, ar.time, ms.machine FROM analysis_result AS ar JOIN contract_machine_sensors cms on cms.id = ar.contract_machine_sensor JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE cms.contract = $1 ORDER BY ar.time ASC, ar.id ASC LIMIT $2 OFFSET $3",
+			[]driver.Value{"contract", 11, 20},
+			sqlmock.NewRows([]string{"id", "time", "machine"}),
+			"null",
+			"",
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("cannot create mocked database: %s", err)
+			}
+
+			defer db.Close()
+
+			mock.ExpectQuery(v.query).WithArgs(v.args...).WillReturnRows(v.rows)
 
-			data, err := NewResultList(db).Get(v.contractID, v.params)
+			data, next, err := NewResultList(db).Get("contract", ResultFilter{}, v.page)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if string(data) != v.ret {
+				t.Errorf("returned data != expected data\n\t%s != %s", string(data), v.ret)
+			}
 
-			if len(data) != len(v.ret) && len(v.ret) != 0 {
-				if !reflect.DeepEqual(data, v.ret) {
-					t.Errorf("returned data != expected data\n\t%s != %s", string(data), string(v.ret))
-				}
+			if next != v.next {
+				t.Errorf("returned cursor != expected cursor\n\t%s != %s", next, v.next)
 			}
 
-			if !reflect.DeepEqual(err, v.err) {
-				t.Errorf("expected error != returned error\n\t%s != %s", v.err, err)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
 			}
 		})
 	}
 }
+
+func TestResultFilter_Matches(t *testing.T) {
+	var analysis Analysis
+	analysis.Body.Model = Model{URL: "url", Tag: "tag"}
+	analysis.Body.Type = "text"
+	analysis.Body.Timestamp = "2020-09-23T10:24:55Z"
+	date := time.Date(2020, 9, 23, 10, 24, 55, 0, time.UTC)
+
+	testTable := []struct {
+		description string
+		filter      ResultFilter
+		matches     bool
+	}{
+		{"no filter", ResultFilter{}, true},
+		{"machine", ResultFilter{Machines: []string{"a", "m"}}, true},
+		{"other machine", ResultFilter{Machines: []string{"a"}}, false},
+		{"other sensor", ResultFilter{Sensors: []string{"a"}}, false},
+		{"model url", ResultFilter{Models: []string{"url"}}, true},
+		{"model url and tag", ResultFilter{Models: []string{"url:tag"}}, true},
+		{"other model tag", ResultFilter{Models: []string{"url:other"}}, false},
+		{"type", ResultFilter{Types: []string{"text"}}, true},
+		{"other type", ResultFilter{Types: []string{"time_series"}}, false},
+		{"time range", ResultFilter{Start: date, End: date}, true},
+		{"after end", ResultFilter{End: date.Add(-time.Second)}, false},
+		{"before start", ResultFilter{Start: date.Add(time.Second)}, false},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			if matches := v.filter.Matches("m", "s", analysis); matches != v.matches {
+				t.Errorf("expected match %t, got %t", v.matches, matches)
+			}
+		})
+	}
+}
+
+func TestResultListHandler_Since(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mocked database: %s", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT ar.id, ar.time, ms.machine, s.transmitted_id, ar.result FROM analysis_result AS ar JOIN contract_machine_sensors cms on cms.id = ar.contract_machine_sensor JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE cms.contract = $1 AND ar.id > $2 AND s.transmitted_id = ANY($3) ORDER BY ar.id LIMIT $4").
+		WithArgs("contract", 4, "{\"s\"}", pagination.MaxLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "time", "machine", "sensor", "result"}).
+			AddRow(5, "data", "m", "s", `{"body":{"type":"text"}}`))
+
+	results, err := NewResultList(db).Since("contract", ResultFilter{Sensors: []string{"s"}}, 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var analysis Analysis
+	analysis.Body.Type = "text"
+	expected := []Result{{ID: 5, Machine: "m", Sensor: "s", Date: "data", Analysis: analysis}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("returned results != expected results\n\t%v != %v", results, expected)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
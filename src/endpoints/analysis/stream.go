@@ -0,0 +1,84 @@
+package analysis
+
+import (
+	"sync"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/analysis/models"
+)
+
+// subscriptionBuffer is the count of results, which can be queued for a subscriber. If a subscriber
+// is slower, its subscription will be closed and the client has to resume with the last event id.
+const subscriptionBuffer = 64
+
+// Broker distributes the newly inserted results to the subscribers of a contract
+type Broker interface {
+	// Publish sends the result to all subscribers of the contract, which filter matches the result
+	Publish(contract string, result models.Result)
+
+	// Subscribe returns a channel, which receives the new results of the contract matching the filter,
+	// and a function to cancel the subscription. The channel will be closed, if the subscription ends.
+	Subscribe(contract string, filter models.ResultFilter) (<-chan models.Result, func())
+}
+
+type subscription struct {
+	filter  models.ResultFilter
+	results chan models.Result
+}
+
+type broker struct {
+	mutex         *sync.Mutex
+	subscriptions map[string]map[*subscription]struct{}
+}
+
+// NewBroker creates a broker, which distributes the results in memory
+func NewBroker() Broker {
+	return broker{mutex: &sync.Mutex{}, subscriptions: make(map[string]map[*subscription]struct{})}
+}
+
+func (b broker) Publish(contract string, result models.Result) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for sub := range b.subscriptions[contract] {
+		if !sub.filter.Matches(result.Machine, result.Sensor, result.Analysis) {
+			continue
+		}
+
+		select {
+		case sub.results <- result:
+		default:
+			// the subscriber is too slow; it can resume with the last received event id
+			b.remove(contract, sub)
+		}
+	}
+}
+
+func (b broker) Subscribe(contract string, filter models.ResultFilter) (<-chan models.Result, func()) {
+	sub := &subscription{filter: filter, results: make(chan models.Result, subscriptionBuffer)}
+
+	b.mutex.Lock()
+	if _, ok := b.subscriptions[contract]; !ok {
+		b.subscriptions[contract] = make(map[*subscription]struct{})
+	}
+	b.subscriptions[contract][sub] = struct{}{}
+	b.mutex.Unlock()
+
+	return sub.results, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		b.remove(contract, sub)
+	}
+}
+
+// remove closes the subscription; the mutex has to be locked
+func (b broker) remove(contract string, sub *subscription) {
+	if _, ok := b.subscriptions[contract][sub]; !ok {
+		return
+	}
+
+	delete(b.subscriptions[contract], sub)
+	if len(b.subscriptions[contract]) == 0 {
+		delete(b.subscriptions, contract)
+	}
+	close(sub.results)
+}
@@ -0,0 +1,431 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+var nameAPIKeyInHeader = "api-key"
+
+// ErrAPIKeyNotFound is returned, if an api key does not exist or is revoked
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// ErrUnknownOrganisation is returned, if an api key should be bound to an organisation, which does not exist
+var ErrUnknownOrganisation = errors.New("unknown organisation")
+
+// ErrUnknownContract is returned, if an api key should be limited to a contract, which does not exist
+var ErrUnknownContract = errors.New("unknown contract")
+
+// APIKey is a long-lived key of a machine, which authenticates as an organisation. The key can be limited
+// to read access and to some contracts and machines; empty lists do not limit the key.
+type APIKey struct {
+	ID           string     `json:"id"`
+	Description  string     `json:"description"`
+	Organisation string     `json:"organisation"`
+	Write        bool       `json:"write"`
+	Contracts    []string   `json:"contracts"`
+	Machines     []string   `json:"machines"`
+	Created      time.Time  `json:"created"`
+	Revoked      *time.Time `json:"revoked,omitempty"`
+}
+
+// allows returns if the scopes of the key allow the access to the contract of the machine
+func (k APIKey) allows(contract, machine string, write bool) bool {
+	if write && !k.Write {
+		return false
+	}
+	return contains(k.Contracts, contract) && contains(k.Machines, machine)
+}
+
+// contains returns if the value is part of the values; every value is part of an empty list
+func contains(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// newAPIKey generates the secret of an api key and returns the key, which is handed out once, and the hash of
+// the secret, which is stored
+func newAPIKey(id string) (string, string, error) {
+	secret, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	return id + "." + secret, hashSecret(secret), nil
+}
+
+// parseAPIKey splits the key into its id and its secret
+func parseAPIKey(key string) (string, string, bool) {
+	split := strings.SplitN(key, ".", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return "", "", false
+	}
+	return split[0], split[1], true
+}
+
+// hashSecret hashes the secret of an api key; the secret is random, so that a salt is not required
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyStore stores the api keys, only the hash of the secret is stored
+type APIKeyStore interface {
+	// Create stores a new api key
+	Create(key APIKey, hash string) error
+
+	// Get returns the api key and the hash of its secret; revoked keys are not returned
+	Get(id string) (APIKey, string, error)
+
+	// List returns all api keys including the revoked ones
+	List() ([]APIKey, error)
+
+	// Rotate replaces the hash of the secret of the api key
+	Rotate(id, hash string) error
+
+	// Revoke revokes the api key
+	Revoke(id string) error
+
+	// ContractMachine returns the machine of the contract, if the organisation has the access to the contract
+	ContractMachine(organisation, contract string, write bool) (string, bool, error)
+}
+
+// NewAPIKeyStore creates a store of the api keys, which uses the database
+func NewAPIKeyStore(db *sql.DB) APIKeyStore {
+	return apiKeyStore{db: db}
+}
+
+type apiKeyStore struct {
+	db *sql.DB
+}
+
+func (a apiKeyStore) Create(key APIKey, hash string) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := a.create(tx, key, hash); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			klog.Errorf("cannot rollback transaction: %s", rollbackErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (a apiKeyStore) create(tx *sql.Tx, key APIKey, hash string) error {
+	var organisation int64
+	err := tx.QueryRow("SELECT id FROM organisations WHERE name = $1", key.Organisation).Scan(&organisation)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUnknownOrganisation
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("INSERT INTO api_keys (id, hash, organisation, write, description, created) VALUES ($1, $2, $3, $4, $5, $6)",
+		key.ID, hash, organisation, key.Write, key.Description, key.Created); err != nil {
+		return err
+	}
+
+	for _, contract := range key.Contracts {
+		var id string
+		err := tx.QueryRow("SELECT id FROM contracts WHERE id = $1", contract).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w %s", ErrUnknownContract, contract)
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("INSERT INTO api_key_contracts (api_key, contract) VALUES ($1, $2)", key.ID, contract); err != nil {
+			return err
+		}
+	}
+
+	for _, machine := range key.Machines {
+		if _, err := tx.Exec("INSERT INTO api_key_machines (api_key, machine) VALUES ($1, $2)", key.ID, machine); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scopes returns the values of the column of the scope table of the api key
+func (a apiKeyStore) scopes(table, column, id string) ([]string, error) {
+	query, err := a.db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE api_key = $1 ORDER BY %s", column, table, column), id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	values := []string{}
+	for query.Next() {
+		var value string
+		if err := query.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, query.Err()
+}
+
+// withScopes adds the contracts and the machines of the api key
+func (a apiKeyStore) withScopes(key APIKey) (APIKey, error) {
+	var err error
+	if key.Contracts, err = a.scopes("api_key_contracts", "contract", key.ID); err != nil {
+		return key, err
+	}
+	key.Machines, err = a.scopes("api_key_machines", "machine", key.ID)
+	return key, err
+}
+
+func (a apiKeyStore) Get(id string) (APIKey, string, error) {
+	var key APIKey
+	var hash string
+	err := a.db.QueryRow("SELECT k.id, k.hash, o.name, k.write, k.description, k.created FROM api_keys AS k JOIN organisations o on k.organisation = o.id WHERE k.id = $1 AND k.revoked IS NULL", id).
+		Scan(&key.ID, &hash, &key.Organisation, &key.Write, &key.Description, &key.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, "", ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	key, err = a.withScopes(key)
+	return key, hash, err
+}
+
+func (a apiKeyStore) List() ([]APIKey, error) {
+	query, err := a.db.Query("SELECT k.id, o.name, k.write, k.description, k.created, k.revoked FROM api_keys AS k JOIN organisations o on k.organisation = o.id ORDER BY k.created, k.id")
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	keys := []APIKey{}
+	for query.Next() {
+		var key APIKey
+		var revoked sql.NullTime
+		if err := query.Scan(&key.ID, &key.Organisation, &key.Write, &key.Description, &key.Created, &revoked); err != nil {
+			return nil, err
+		}
+		if revoked.Valid {
+			key.Revoked = &revoked.Time
+		}
+		keys = append(keys, key)
+	}
+	if err := query.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		if keys[i], err = a.withScopes(keys[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// update executes the update of a single api key, which is not revoked
+func (a apiKeyStore) update(query string, args ...interface{}) error {
+	res, err := a.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (a apiKeyStore) Rotate(id, hash string) error {
+	return a.update("UPDATE api_keys SET hash = $2 WHERE id = $1 AND revoked IS NULL", id, hash)
+}
+
+func (a apiKeyStore) Revoke(id string) error {
+	return a.update("UPDATE api_keys SET revoked = NOW() WHERE id = $1 AND revoked IS NULL", id)
+}
+
+func (a apiKeyStore) ContractMachine(organisation, contract string, write bool) (string, bool, error) {
+	var table string
+	if write {
+		table = "write_permissions p"
+	} else {
+		table = "read_permissions p"
+	}
+
+	var machine string
+	err := a.db.QueryRow(fmt.Sprintf("SELECT c.contract->'body'->>'machine' FROM contracts AS c JOIN %s on p.contract = c.id JOIN organisations o on p.organisation = o.id WHERE c.id = $1 AND o.name = $2", table), contract, organisation).
+		Scan(&machine)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return machine, true, nil
+}
+
+// NewAPIKeyHelper creates a helper, which authenticates requests with an api key; requests without an
+// api key are authenticated by the next helper
+func NewAPIKeyHelper(store APIKeyStore, next Helper) Helper {
+	return apiKeyHelper{Helper: next, store: store}
+}
+
+type apiKeyHelper struct {
+	Helper
+	store APIKeyStore
+}
+
+// authenticate returns the api key, if the secret matches the stored hash
+func (a apiKeyHelper) authenticate(key string) (APIKey, bool, error) {
+	id, secret, ok := parseAPIKey(key)
+	if !ok {
+		return APIKey{}, false, nil
+	}
+
+	apiKey, hash, err := a.store.Get(id)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return APIKey{}, false, nil
+	}
+	if err != nil {
+		return APIKey{}, false, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(hash)) != 1 {
+		return APIKey{}, false, nil
+	}
+	return apiKey, true, nil
+}
+
+func (a apiKeyHelper) IsAuthenticated(request *http.Request, contract string, write bool) (bool, int, error) {
+	key := request.Header.Get(nameAPIKeyInHeader)
+	if key == "" {
+		return a.Helper.IsAuthenticated(request, contract, write)
+	}
+
+	apiKey, ok, err := a.authenticate(key)
+	if err != nil {
+		return false, http.StatusInternalServerError, err
+	}
+	if !ok {
+		klog.Infof("api key is not valid")
+		return false, http.StatusUnauthorized, nil
+	}
+
+	machine, permitted, err := a.store.ContractMachine(apiKey.Organisation, contract, write)
+	if err != nil {
+		return false, http.StatusInternalServerError, err
+	}
+
+	if !permitted || !apiKey.allows(contract, machine, write) {
+		klog.Infof("api key %s has no access to contract %s", apiKey.ID, contract)
+		return false, http.StatusUnauthorized, nil
+	}
+
+	return true, 0, nil
+}
+
+// TokenValid does not accept api keys, because they are no session tokens
+func (a apiKeyHelper) TokenValid(r *http.Request) (bool, error) {
+	if r.Header.Get(nameAPIKeyInHeader) != "" {
+		return false, nil
+	}
+	return a.Helper.TokenValid(r)
+}
+
+// ContractWriteAccess does not accept api keys, contracts can only be created and removed by users
+func (a apiKeyHelper) ContractWriteAccess(r *http.Request) (bool, int, error) {
+	if r.Header.Get(nameAPIKeyInHeader) != "" {
+		return false, http.StatusUnauthorized, nil
+	}
+	return a.Helper.ContractWriteAccess(r)
+}
+
+// ContractDeleteAccess does not accept api keys, contracts can only be created and removed by users
+func (a apiKeyHelper) ContractDeleteAccess(r *http.Request) (bool, int, error) {
+	if r.Header.Get(nameAPIKeyInHeader) != "" {
+		return false, http.StatusUnauthorized, nil
+	}
+	return a.Helper.ContractDeleteAccess(r)
+}
+
+// AdminAccess does not accept api keys, the api keys can only be managed by users
+func (a apiKeyHelper) AdminAccess(r *http.Request) (bool, int, error) {
+	if r.Header.Get(nameAPIKeyInHeader) != "" {
+		return false, http.StatusUnauthorized, nil
+	}
+	return a.Helper.AdminAccess(r)
+}
+
+// Organisations returns the organisation of the api key
+func (a apiKeyHelper) Organisations(r *http.Request) ([]string, int, error) {
+	key := r.Header.Get(nameAPIKeyInHeader)
+	if key == "" {
+		return a.Helper.Organisations(r)
+	}
+
+	apiKey, ok, err := a.authenticate(key)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !ok {
+		return nil, http.StatusUnauthorized, nil
+	}
+	return []string{apiKey.Organisation}, 0, nil
+}
+
+// ReadScope returns the organisation of the api key limited to the contracts and the machines of the key
+func (a apiKeyHelper) ReadScope(r *http.Request) (Scope, int, error) {
+	key := r.Header.Get(nameAPIKeyInHeader)
+	if key == "" {
+		return a.Helper.ReadScope(r)
+	}
+
+	apiKey, ok, err := a.authenticate(key)
+	if err != nil {
+		return Scope{}, http.StatusInternalServerError, err
+	}
+	if !ok {
+		return Scope{}, http.StatusUnauthorized, nil
+	}
+	return Scope{Organisations: []string{apiKey.Organisation}, Contracts: apiKey.Contracts, Machines: apiKey.Machines}, 0, nil
+}
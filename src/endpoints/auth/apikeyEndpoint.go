@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// APIKeys is the endpoint, on which the administrators issue, list, rotate and revoke the api keys
+type APIKeys interface {
+	ServeHTTP(http.ResponseWriter, *http.Request)
+}
+
+// NewAPIKeyEndpoint creates the endpoint of the api keys; only administrators have access to it
+func NewAPIKeyEndpoint(store APIKeyStore, helper Helper) APIKeys {
+	return apiKeyEndpoint{store: store, helper: helper, generator: NewTokenGeneratorUuid(), now: time.Now}
+}
+
+type apiKeyEndpoint struct {
+	store     APIKeyStore
+	helper    Helper
+	generator TokenGenerate
+	now       func() time.Time
+}
+
+// issuedAPIKey is the response of an issued or rotated api key; the key is only returned once
+type issuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+func (a apiKeyEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	admin, statusCode, err := a.helper.AdminAccess(r)
+	if err != nil {
+		klog.Errorf("cannot check admin access: %s", err)
+		w.WriteHeader(statusCode)
+		return
+	}
+	if !admin {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	split := strings.Split(strings.TrimRight(r.URL.Path, "/"), "/")
+	switch {
+	case len(split) == 2 && r.Method == http.MethodGet:
+		a.handleList(w)
+	case len(split) == 2 && r.Method == http.MethodPost:
+		a.handleIssue(w, r)
+	case len(split) == 4 && split[3] == "rotate" && r.Method == http.MethodPost:
+		a.handleRotate(w, split[2])
+	case len(split) == 3 && r.Method == http.MethodDelete:
+		a.handleRevoke(w, split[2])
+	case len(split) < 2 || len(split) > 4:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a apiKeyEndpoint) handleList(w http.ResponseWriter) {
+	keys, err := a.store.List()
+	if err != nil {
+		klog.Errorf("cannot list api keys: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (a apiKeyEndpoint) handleIssue(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		klog.Errorf("could not read data from request: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var key APIKey
+	if err := json.Unmarshal(body, &key); err != nil || key.Organisation == "" {
+		klog.Infof("api key cannot be parsed: %v, received data: %s", err, string(body))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	key.ID = a.generator.Generate()
+	key.Created = a.now().UTC()
+	key.Revoked = nil
+	if key.Contracts == nil {
+		key.Contracts = []string{}
+	}
+	if key.Machines == nil {
+		key.Machines = []string{}
+	}
+
+	secret, hash, err := newAPIKey(key.ID)
+	if err != nil {
+		klog.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = a.store.Create(key, hash)
+	if errors.Is(err, ErrUnknownOrganisation) {
+		klog.Infof("cannot issue api key for unknown organisation %s", key.Organisation)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, ErrUnknownContract) {
+		klog.Infof("cannot issue api key: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		klog.Errorf("cannot store api key: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	klog.Infof("issued api key %s for organisation %s", key.ID, key.Organisation)
+	writeJSON(w, http.StatusCreated, issuedAPIKey{APIKey: key, Key: secret})
+}
+
+func (a apiKeyEndpoint) handleRotate(w http.ResponseWriter, id string) {
+	key, _, err := a.store.Get(id)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		klog.Errorf("cannot get api key %s: %s", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	secret, hash, err := newAPIKey(id)
+	if err != nil {
+		klog.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = a.store.Rotate(id, hash)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		klog.Errorf("cannot rotate api key %s: %s", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	klog.Infof("rotated api key %s", id)
+	writeJSON(w, http.StatusOK, issuedAPIKey{APIKey: key, Key: secret})
+}
+
+func (a apiKeyEndpoint) handleRevoke(w http.ResponseWriter, id string) {
+	err := a.store.Revoke(id)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		klog.Errorf("cannot revoke api key %s: %s", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	klog.Infof("revoked api key %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,367 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	dbMock "github.com/DATA-DOG/go-sqlmock"
+)
+
+// testAPIKeyStore stores the api keys in memory; every organisation has the access to the contract c1 of machine m1
+type testAPIKeyStore struct {
+	keys   map[string]APIKey
+	hashes map[string]string
+}
+
+func newTestAPIKeyStore() *testAPIKeyStore {
+	return &testAPIKeyStore{keys: make(map[string]APIKey), hashes: make(map[string]string)}
+}
+
+func (s *testAPIKeyStore) Create(key APIKey, hash string) error {
+	if key.Organisation == "unknown" {
+		return ErrUnknownOrganisation
+	}
+	for _, contract := range key.Contracts {
+		if contract == "unknown" {
+			return ErrUnknownContract
+		}
+	}
+	s.keys[key.ID] = key
+	s.hashes[key.ID] = hash
+	return nil
+}
+
+func (s *testAPIKeyStore) Get(id string) (APIKey, string, error) {
+	key, ok := s.keys[id]
+	if !ok || key.Revoked != nil {
+		return APIKey{}, "", ErrAPIKeyNotFound
+	}
+	return key, s.hashes[id], nil
+}
+
+func (s *testAPIKeyStore) List() ([]APIKey, error) {
+	keys := []APIKey{}
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *testAPIKeyStore) Rotate(id, hash string) error {
+	if _, _, err := s.Get(id); err != nil {
+		return err
+	}
+	s.hashes[id] = hash
+	return nil
+}
+
+func (s *testAPIKeyStore) Revoke(id string) error {
+	key, _, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	key.Revoked = &now
+	s.keys[id] = key
+	return nil
+}
+
+func (s *testAPIKeyStore) ContractMachine(organisation, contract string, write bool) (string, bool, error) {
+	return "m1", contract == "c1", nil
+}
+
+// testSessionHelper accepts every session and grants the admin access, if admin is set
+type testSessionHelper struct {
+	Helper
+	admin bool
+}
+
+func (testSessionHelper) IsAuthenticated(*http.Request, string, bool) (bool, int, error) {
+	return true, 0, nil
+}
+
+func (h testSessionHelper) AdminAccess(*http.Request) (bool, int, error) {
+	return h.admin, 0, nil
+}
+
+func (testSessionHelper) ContractWriteAccess(*http.Request) (bool, int, error) {
+	return false, 0, nil
+}
+
+func (testSessionHelper) ContractDeleteAccess(*http.Request) (bool, int, error) {
+	return false, 0, nil
+}
+
+func TestAPIKeyHelper_IsAuthenticated(t *testing.T) {
+	store := newTestAPIKeyStore()
+	keys := make(map[string]string)
+	for _, key := range []APIKey{
+		{ID: "read", Organisation: "org"},
+		{ID: "write", Organisation: "org", Write: true},
+		{ID: "contract", Organisation: "org", Write: true, Contracts: []string{"c2"}},
+		{ID: "machine", Organisation: "org", Write: true, Machines: []string{"m2"}},
+		{ID: "revoked", Organisation: "org", Write: true},
+	} {
+		secret, hash, err := newAPIKey(key.ID)
+		if err != nil {
+			t.Fatalf("cannot create api key: %s", err)
+		}
+		keys[key.ID] = secret
+		if err := store.Create(key, hash); err != nil {
+			t.Fatalf("cannot store api key: %s", err)
+		}
+	}
+	if err := store.Revoke("revoked"); err != nil {
+		t.Fatalf("cannot revoke api key: %s", err)
+	}
+
+	testTable := []struct {
+		description   string
+		key           string
+		contract      string
+		write         bool
+		authenticated bool
+	}{
+		{"session", "", "c1", true, true},
+		{"read", keys["read"], "c1", false, true},
+		{"read key cannot write", keys["read"], "c1", true, false},
+		{"write", keys["write"], "c1", true, true},
+		{"organisation has no access", keys["write"], "c3", false, false},
+		{"other contract", keys["contract"], "c1", false, false},
+		{"other machine", keys["machine"], "c1", false, false},
+		{"revoked", keys["revoked"], "c1", false, false},
+		{"wrong secret", "write.secret", "c1", false, false},
+		{"malformed", "write", "c1", false, false},
+	}
+
+	helper := NewAPIKeyHelper(store, testSessionHelper{})
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/machine-data", nil)
+			if v.key != "" {
+				req.Header.Set(nameAPIKeyInHeader, v.key)
+			}
+
+			authenticated, _, err := helper.IsAuthenticated(req, v.contract, v.write)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if authenticated != v.authenticated {
+				t.Errorf("expected authentication %t, got %t", v.authenticated, authenticated)
+			}
+		})
+	}
+}
+
+func TestAPIKeyHelper_ReadScope(t *testing.T) {
+	store := newTestAPIKeyStore()
+	key := APIKey{ID: "key", Organisation: "org", Contracts: []string{"c1"}, Machines: []string{"m1"}}
+	secret, hash, err := newAPIKey(key.ID)
+	if err != nil {
+		t.Fatalf("cannot create api key: %s", err)
+	}
+	if err := store.Create(key, hash); err != nil {
+		t.Fatalf("cannot store api key: %s", err)
+	}
+
+	testTable := []struct {
+		description string
+		key         string
+		scope       Scope
+		statusCode  int
+	}{
+		{"api key", secret, Scope{Organisations: []string{"org"}, Contracts: []string{"c1"}, Machines: []string{"m1"}}, 0},
+		{"wrong secret", "key.secret", Scope{}, http.StatusUnauthorized},
+	}
+
+	helper := NewAPIKeyHelper(store, testSessionHelper{})
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/contract", nil)
+			req.Header.Set(nameAPIKeyInHeader, v.key)
+
+			scope, statusCode, err := helper.ReadScope(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if statusCode != v.statusCode || !reflect.DeepEqual(scope, v.scope) {
+				t.Errorf("expected (%v, %d), got (%v, %d)", v.scope, v.statusCode, scope, statusCode)
+			}
+		})
+	}
+}
+
+func TestAPIKeyEndpoint(t *testing.T) {
+	store := newTestAPIKeyStore()
+	endpoint := NewAPIKeyEndpoint(store, NewAPIKeyHelper(store, testSessionHelper{admin: true}))
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		endpoint.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return w
+	}
+
+	w := serve(http.MethodPost, "/api-keys", `{"organisation": "org", "write": true, "machines": ["m1"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	var issued issuedAPIKey
+	if err := json.Unmarshal(w.Body.Bytes(), &issued); err != nil {
+		t.Fatalf("cannot parse issued api key: %s", err)
+	}
+
+	if id, _, ok := parseAPIKey(issued.Key); !ok || id != issued.ID {
+		t.Errorf("unexpected key %s of api key %s", issued.Key, issued.ID)
+	}
+
+	stored := store.keys[issued.ID]
+	if !stored.Write || !reflect.DeepEqual(stored.Machines, []string{"m1"}) || stored.Organisation != "org" {
+		t.Errorf("unexpected stored api key: %v", stored)
+	}
+
+	if store.hashes[issued.ID] == issued.Key || store.hashes[issued.ID] == "" {
+		t.Errorf("the secret of the api key is not hashed")
+	}
+
+	if w := serve(http.MethodPost, "/api-keys", `{"organisation": "unknown"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d for unknown organisation, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	if w := serve(http.MethodPost, "/api-keys", `{"organisation": "org", "contracts": ["unknown"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d for unknown contract, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	hash := store.hashes[issued.ID]
+	if w := serve(http.MethodPost, "/api-keys/"+issued.ID+"/rotate", ""); w.Code != http.StatusOK {
+		t.Errorf("expected status code %d on rotation, got %d", http.StatusOK, w.Code)
+	}
+	if store.hashes[issued.ID] == hash {
+		t.Errorf("the secret of the api key is not rotated")
+	}
+
+	if w := serve(http.MethodDelete, "/api-keys/"+issued.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected status code %d on revocation, got %d", http.StatusNoContent, w.Code)
+	}
+	if w := serve(http.MethodDelete, "/api-keys/"+issued.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d for revoked api key, got %d", http.StatusNotFound, w.Code)
+	}
+
+	w = serve(http.MethodGet, "/api-keys", "")
+	var keys []APIKey
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil {
+		t.Fatalf("cannot parse api keys: %s", err)
+	}
+	if len(keys) != 1 || keys[0].Revoked == nil {
+		t.Errorf("unexpected api keys: %v", keys)
+	}
+}
+
+func TestAPIKeyEndpoint_NoAdmin(t *testing.T) {
+	store := newTestAPIKeyStore()
+	endpoint := NewAPIKeyEndpoint(store, NewAPIKeyHelper(store, testSessionHelper{admin: true}))
+
+	// api keys cannot manage api keys, even if the session helper would grant the access
+	req := httptest.NewRequest(http.MethodGet, "/api-keys", nil)
+	req.Header.Set(nameAPIKeyInHeader, "id.secret")
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestAPIKeyStore_ContractMachine(t *testing.T) {
+	testTable := []struct {
+		description string
+		write       bool
+		table       string
+		rows        *dbMock.Rows
+		machine     string
+		permitted   bool
+	}{
+		{"read", false, "read_permissions", dbMock.NewRows([]string{"machine"}).AddRow("m1"), "m1", true},
+		{"write", true, "write_permissions", dbMock.NewRows([]string{"machine"}).AddRow("m1"), "m1", true},
+		{"no permission", false, "read_permissions", dbMock.NewRows([]string{"machine"}), "", false},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("cannot create mock: %s", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("SELECT c.contract->'body'->>'machine' FROM contracts AS c JOIN "+v.table+" p on p.contract = c.id JOIN organisations o on p.organisation = o.id WHERE c.id = $1 AND o.name = $2").
+				WithArgs("c1", "org").
+				WillReturnRows(v.rows)
+
+			machine, permitted, err := NewAPIKeyStore(db).ContractMachine("org", "c1", v.write)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if machine != v.machine || permitted != v.permitted {
+				t.Errorf("expected (%s, %t), got (%s, %t)", v.machine, v.permitted, machine, permitted)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("not all expectations were met: %s", err)
+			}
+		})
+	}
+}
+
+func TestAPIKeyStore_Revoke(t *testing.T) {
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mock: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE api_keys SET revoked = NOW() WHERE id = $1 AND revoked IS NULL").
+		WithArgs("id").
+		WillReturnResult(dbMock.NewResult(0, 0))
+
+	if err := NewAPIKeyStore(db).Revoke("id"); err != ErrAPIKeyNotFound {
+		t.Errorf("expected %s, got %v", ErrAPIKeyNotFound, err)
+	}
+}
+
+func TestAPIKeyStore_CreateUnknownContract(t *testing.T) {
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mock: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM organisations WHERE name = $1").
+		WithArgs("org").
+		WillReturnRows(dbMock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO api_keys (id, hash, organisation, write, description, created) VALUES ($1, $2, $3, $4, $5, $6)").
+		WillReturnResult(dbMock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id FROM contracts WHERE id = $1").
+		WithArgs("c2").
+		WillReturnRows(dbMock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	err = NewAPIKeyStore(db).Create(APIKey{ID: "id", Organisation: "org", Contracts: []string{"c2"}}, "hash")
+	if !errors.Is(err, ErrUnknownContract) {
+		t.Errorf("expected %s, got %v", ErrUnknownContract, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("not all expectations were met: %s", err)
+	}
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidCiphertext is returned, if a stored value cannot be decrypted with the key
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+// TokenCipher encrypts the refresh tokens of the user management, which are stored with the sessions
+type TokenCipher interface {
+	// Encrypt returns the encrypted token; an empty token is not stored
+	Encrypt(token string) ([]byte, error)
+
+	// Decrypt returns the token of the encrypted value
+	Decrypt(value []byte) (string, error)
+}
+
+// NewTokenCipher creates a cipher, which uses AES-GCM with the base64 encoded key of 16, 24 or 32 bytes. Without
+// a key the refresh tokens are discarded, so that the sessions cannot be refreshed.
+func NewTokenCipher(key string) (TokenCipher, error) {
+	if key == "" {
+		return discardCipher{}, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode key: %s", err)
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcmCipher{aead: aead}, nil
+}
+
+type gcmCipher struct {
+	aead cipher.AEAD
+}
+
+// Encrypt prepends the random nonce to the ciphertext
+func (g gcmCipher) Encrypt(token string) ([]byte, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	nonce := make([]byte, g.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("cannot generate nonce: %s", err)
+	}
+
+	return g.aead.Seal(nonce, nonce, []byte(token), nil), nil
+}
+
+func (g gcmCipher) Decrypt(value []byte) (string, error) {
+	if len(value) == 0 {
+		return "", nil
+	}
+	if len(value) < g.aead.NonceSize() {
+		return "", ErrInvalidCiphertext
+	}
+
+	nonce, ciphertext := value[:g.aead.NonceSize()], value[g.aead.NonceSize():]
+	token, err := g.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	return string(token), nil
+}
+
+type discardCipher struct{}
+
+func (discardCipher) Encrypt(string) ([]byte, error) {
+	return nil, nil
+}
+
+func (discardCipher) Decrypt([]byte) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"testing"
+)
+
+// testSessionKey is a key of 32 bytes
+const testSessionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+// encryptedArg matches the encrypted token of a query
+type encryptedArg struct {
+	cipher TokenCipher
+	token  string
+}
+
+func (e encryptedArg) Match(v driver.Value) bool {
+	value, ok := v.([]byte)
+	if !ok {
+		return false
+	}
+
+	token, err := e.cipher.Decrypt(value)
+	return err == nil && token == e.token
+}
+
+func TestTokenCipher(t *testing.T) {
+	tokenCipher, err := NewTokenCipher(testSessionKey)
+	if err != nil {
+		t.Fatalf("cannot create cipher: %s", err)
+	}
+
+	encrypted, err := tokenCipher.Encrypt("refresh")
+	if err != nil {
+		t.Fatalf("cannot encrypt: %s", err)
+	}
+
+	if bytes.Contains(encrypted, []byte("refresh")) {
+		t.Errorf("token is not encrypted")
+	}
+
+	token, err := tokenCipher.Decrypt(encrypted)
+	if err != nil || token != "refresh" {
+		t.Errorf("expected refresh, got %s (%v)", token, err)
+	}
+
+	encrypted[len(encrypted)-1] ^= 1
+	if _, err := tokenCipher.Decrypt(encrypted); err != ErrInvalidCiphertext {
+		t.Errorf("expected %s, got %v", ErrInvalidCiphertext, err)
+	}
+
+	if _, err := NewTokenCipher("c2hvcnQ="); err == nil {
+		t.Errorf("expected error for short key")
+	}
+}
+
+func TestTokenCipher_WithoutKey(t *testing.T) {
+	tokenCipher, err := NewTokenCipher("")
+	if err != nil {
+		t.Fatalf("cannot create cipher: %s", err)
+	}
+
+	// without a key the refresh tokens are not stored
+	if encrypted, err := tokenCipher.Encrypt("refresh"); err != nil || encrypted != nil {
+		t.Errorf("expected discarded token, got %v (%v)", encrypted, err)
+	}
+}
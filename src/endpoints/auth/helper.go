@@ -4,9 +4,9 @@ import (
 	"database/sql"
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"k8s.io/klog"
 )
 
@@ -18,8 +18,9 @@ type Helper interface {
 	IsAuthenticated(*http.Request, string, bool) (bool, int, error)
 
 	// CreateSession will create a session on a specific token. This token will be used
-	// to identify if the user, has the required permission or not
-	CreateSession(string, []string, []string, time.Time) error
+	// to identify if the user, has the required permission or not. The subject of the user and
+	// the refresh token of the user management are stored to manage and refresh the session.
+	CreateSession(token string, permissions Permissions, valid time.Time, subject, refreshToken string) error
 
 	// DeleteSession will delete a user session, which is identified by a the session token
 	DeleteSession(string) error
@@ -30,26 +31,37 @@ type Helper interface {
 	// TokenValid checks if a token is valid and can be used or not
 	TokenValid(r *http.Request) (bool, error)
 
-	// ContractWriteAccess checks if the user has write permissions to create and delete contracts
+	// ContractWriteAccess checks if the user has write permissions to create and update contracts
 	ContractWriteAccess(r *http.Request) (bool, int, error)
+
+	// ContractDeleteAccess checks if the user has the permission to remove contracts
+	ContractDeleteAccess(r *http.Request) (bool, int, error)
+
+	// AdminAccess checks if the user is an administrator, which can manage the api keys and the sessions
+	AdminAccess(r *http.Request) (bool, int, error)
+
+	// Organisations returns the organisations, on behalf of which the request is made
+	Organisations(r *http.Request) ([]string, int, error)
+
+	// ReadScope returns the organisations, on behalf of which the contracts are listed, and the limits of the request
+	ReadScope(r *http.Request) (Scope, int, error)
+}
+
+// Scope limits the contracts, which can be listed through a request; empty lists of contracts and machines
+// do not limit the contracts
+type Scope struct {
+	Organisations []string
+	Contracts     []string
+	Machines      []string
 }
 
 type helperOidc struct {
-	db            *sql.DB
-	contractWrite string
+	db     *sql.DB
+	cipher TokenCipher
 }
 
 var nameTokenInHeader string = "token"
 
-func (a helperOidc) testContractWrite(tokens []string) bool {
-	for _, token := range tokens {
-		if token == a.contractWrite {
-			return true
-		}
-	}
-	return false
-}
-
 func (a helperOidc) TokenValid(r *http.Request) (bool, error) {
 
 	token := r.Header.Get(nameTokenInHeader)
@@ -98,14 +110,11 @@ func (a helperOidc) CleanUp() {
 	}
 }
 
-func (a helperOidc) CreateSession(token string, organisations, contractCreation []string, valid time.Time) error {
-	organisationString := fmt.Sprintf("'%s'", strings.Join(organisations, "','"))
-	klog.V(2).Infof("organisationString is equal to: %s", organisationString)
+func (a helperOidc) CreateSession(token string, permissions Permissions, valid time.Time, subject, refreshToken string) error {
+	klog.V(2).Infof("organisations of the added token: %v", permissions.Organisations)
+	klog.V(2).Infof("the user of the added token has the permissions: %+v", permissions)
 
-	canCreateContract := a.testContractWrite(contractCreation)
-	klog.V(2).Infof("the user of the added token has contract write rights: %t", canCreateContract)
-
-	query, err := a.db.Query(fmt.Sprintf("SELECT id FROM organisations WHERE name in (%s)", organisationString))
+	query, err := a.db.Query("SELECT id FROM organisations WHERE name = ANY($1)", pq.Array(permissions.Organisations))
 	if err != nil {
 		return err
 	}
@@ -127,13 +136,18 @@ func (a helperOidc) CreateSession(token string, organisations, contractCreation
 
 	klog.Infof("orgs: %v", orgs)
 
-	if !canCreateContract {
-		if len(orgs) == 0 {
-			return fmt.Errorf("no matching organisations found")
-		}
+	// users, which can create contracts or manage the connector, do not need to belong to an organisation
+	if !permissions.ContractCreate && !permissions.Admin && len(orgs) == 0 {
+		return fmt.Errorf("no matching organisations found")
+	}
+
+	encrypted, err := a.cipher.Encrypt(refreshToken)
+	if err != nil {
+		return err
 	}
 
-	if _, err := a.db.Exec("INSERT INTO token (token, valid, write_contract) VALUES ($1, $2, $3)", token, valid, canCreateContract); err != nil {
+	if _, err := a.db.Exec("INSERT INTO token (token, valid, write_contract, delete_contract, write_result, admin, subject, encrypted_refresh_token) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		token, valid, permissions.ContractCreate, permissions.ContractDelete, permissions.ResultWrite, permissions.Admin, subject, encrypted); err != nil {
 		return err
 	}
 
@@ -154,7 +168,7 @@ func (a helperOidc) IsAuthenticated(request *http.Request, contract string, writ
 		return false, http.StatusUnauthorized, nil
 	}
 
-	query, err := a.db.Query("SELECT valid FROM token WHERE token = $1", token)
+	query, err := a.db.Query("SELECT valid, write_result FROM token WHERE token = $1", token)
 	if err != nil {
 		return false, http.StatusInternalServerError, err
 	}
@@ -170,7 +184,8 @@ func (a helperOidc) IsAuthenticated(request *http.Request, contract string, writ
 	}
 
 	var valid time.Time
-	if err := query.Scan(&valid); err != nil {
+	var writeResult bool
+	if err := query.Scan(&valid, &writeResult); err != nil {
 		klog.Infof("cannot scan valid time")
 		return false, http.StatusInternalServerError, err
 	}
@@ -180,6 +195,11 @@ func (a helperOidc) IsAuthenticated(request *http.Request, contract string, writ
 		return false, http.StatusUnauthorized, nil
 	}
 
+	if write && !writeResult {
+		klog.Infof("the user of the token has not the capability to write data")
+		return false, http.StatusUnauthorized, nil
+	}
+
 	var table string
 	if write {
 		table = "write_permissions rp"
@@ -210,9 +230,64 @@ func (a helperOidc) DeleteSession(token string) error {
 }
 
 func (a helperOidc) ContractWriteAccess(r *http.Request) (bool, int, error) {
+	return a.access(r, "write_contract")
+}
+
+func (a helperOidc) ContractDeleteAccess(r *http.Request) (bool, int, error) {
+	return a.access(r, "delete_contract")
+}
+
+func (a helperOidc) AdminAccess(r *http.Request) (bool, int, error) {
+	return a.access(r, "admin")
+}
+
+func (a helperOidc) Organisations(r *http.Request) ([]string, int, error) {
+	token := r.Header.Get(nameTokenInHeader)
+	if token == "" {
+		return nil, http.StatusUnauthorized, nil
+	}
+
+	query, err := a.db.Query("SELECT o.name FROM token AS t JOIN token_permission tp on t.token = tp.token JOIN organisations o on tp.organisation = o.id WHERE t.token = $1 AND t.valid >= NOW() ORDER BY o.name", token)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	var organisations []string
+	for query.Next() {
+		var organisation string
+		if err := query.Scan(&organisation); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		organisations = append(organisations, organisation)
+	}
+
+	return organisations, 0, nil
+}
+
+func (a helperOidc) ReadScope(r *http.Request) (Scope, int, error) {
+	valid, err := a.TokenValid(r)
+	if err != nil {
+		return Scope{}, http.StatusInternalServerError, err
+	}
+	if !valid {
+		return Scope{}, http.StatusUnauthorized, nil
+	}
+
+	organisations, statusCode, err := a.Organisations(r)
+	return Scope{Organisations: organisations}, statusCode, err
+}
+
+// access returns the value of the access column of the token of the request
+func (a helperOidc) access(r *http.Request, column string) (bool, int, error) {
 	token := r.Header.Get(nameTokenInHeader)
 
-	query, err := a.db.Query("SELECT write_contract FROM token WHERE token = $1", token)
+	query, err := a.db.Query(fmt.Sprintf("SELECT %s FROM token WHERE token = $1", column), token)
 	if err != nil {
 		return false, 500, err
 	}
@@ -227,15 +302,16 @@ func (a helperOidc) ContractWriteAccess(r *http.Request) (bool, int, error) {
 		return false, http.StatusUnauthorized, nil
 	}
 
-	var writeAccess bool
-	if err := query.Scan(&writeAccess); err != nil {
+	var access bool
+	if err := query.Scan(&access); err != nil {
 		return false, http.StatusInternalServerError, err
 	}
 
-	return writeAccess, 0, nil
+	return access, 0, nil
 }
 
-// NewAuthHelper creates a new authentication auth helper
-func NewAuthHelper(db *sql.DB, contractWrite string) Helper {
-	return helperOidc{db: db, contractWrite: contractWrite}
+// NewAuthHelper creates a new authentication auth helper; the permissions of a session are mapped from the
+// claims of the user management, when the session is created, and the refresh token is encrypted with cipher
+func NewAuthHelper(db *sql.DB, cipher TokenCipher) Helper {
+	return helperOidc{db: db, cipher: cipher}
 }
@@ -4,12 +4,13 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
-	"strings"
 	"testing"
 	"time"
 
 	dbMock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"k8s.io/klog"
 )
 
@@ -33,7 +34,7 @@ func TestHelperOidc_IsAuthenticated(t *testing.T) {
 			false,
 			true,
 			"contract",
-			dbMock.NewRows([]string{"valid"}).AddRow(time.Now().Add(time.Hour)),
+			dbMock.NewRows([]string{"valid", "write_result"}).AddRow(time.Now().Add(time.Hour), true),
 			dbMock.NewRows([]string{"organisations"}).AddRow("org"),
 		},
 		{
@@ -44,9 +45,31 @@ func TestHelperOidc_IsAuthenticated(t *testing.T) {
 			true,
 			true,
 			"contract",
-			dbMock.NewRows([]string{"valid"}).AddRow(time.Now().Add(time.Hour)),
+			dbMock.NewRows([]string{"valid", "write_result"}).AddRow(time.Now().Add(time.Hour), true),
 			dbMock.NewRows([]string{"organisations"}).AddRow("org"),
 		},
+		{
+			"write without write permission",
+			"token",
+			nil,
+			http.StatusUnauthorized,
+			true,
+			false,
+			"contract",
+			dbMock.NewRows([]string{"valid", "write_result"}).AddRow(time.Now().Add(time.Hour), true),
+			dbMock.NewRows([]string{"organisations"}),
+		},
+		{
+			"write without capability",
+			"token",
+			nil,
+			http.StatusUnauthorized,
+			true,
+			false,
+			"contract",
+			dbMock.NewRows([]string{"valid", "write_result"}).AddRow(time.Now().Add(time.Hour), false),
+			nil,
+		},
 	}
 
 	for _, v := range testTable {
@@ -63,7 +86,7 @@ func TestHelperOidc_IsAuthenticated(t *testing.T) {
 				t.Fatalf("cannot create dbmock: %s", err)
 			}
 
-			mock.ExpectQuery("SELECT valid FROM token WHERE token = $1").
+			mock.ExpectQuery("SELECT valid, write_result FROM token WHERE token = $1").
 				WithArgs(v.token).
 				WillReturnRows(v.validRows)
 
@@ -74,15 +97,21 @@ func TestHelperOidc_IsAuthenticated(t *testing.T) {
 				table = "read_permissions rp"
 			}
 
-			mock.ExpectQuery(fmt.Sprintf("SELECT tp.organisation FROM token_permission as tp JOIN %s on tp.organisation = rp.organisation WHERE token = $1 AND contract = $2", table)).
-				WithArgs(v.token, v.contract).
-				WillReturnRows(v.orgRows)
+			if v.orgRows != nil {
+				mock.ExpectQuery(fmt.Sprintf("SELECT tp.organisation FROM token_permission as tp JOIN %s on tp.organisation = rp.organisation WHERE token = $1 AND contract = $2", table)).
+					WithArgs(v.token, v.contract).
+					WillReturnRows(v.orgRows)
+			}
 
 			defer db.Close()
 
-			helper := NewAuthHelper(db, "")
+			helper := NewAuthHelper(db, discardCipher{})
 			isAuth, statusCode, err := helper.IsAuthenticated(req, v.contract, v.writeAccess)
 
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("not all expectations were met: %s", err)
+			}
+
 			if statusCode != v.statusCode {
 				t.Errorf("expected status code != returned status code\n\t%d != %d", v.statusCode, statusCode)
 			}
@@ -144,6 +173,7 @@ func TestHelperOidc_CreateSession(t *testing.T) {
 			id   int64
 		}
 		orgRows     *dbMock.Rows
+		permissions Permissions
 		token       string
 		valid       time.Time
 		tokenResult driver.Result
@@ -161,11 +191,32 @@ func TestHelperOidc_CreateSession(t *testing.T) {
 				},
 			},
 			dbMock.NewRows([]string{"id"}).AddRow(4),
+			Permissions{ResultWrite: true},
 			"token",
 			time.Now(),
 			dbMock.NewResult(0, 1),
 			nil,
 		},
+		{
+			"administrator without organisation",
+			nil,
+			dbMock.NewRows([]string{"id"}),
+			Permissions{Admin: true},
+			"token",
+			time.Now(),
+			dbMock.NewResult(0, 1),
+			nil,
+		},
+		{
+			"no matching organisation",
+			nil,
+			dbMock.NewRows([]string{"id"}),
+			Permissions{ResultWrite: true},
+			"token",
+			time.Now(),
+			nil,
+			fmt.Errorf("no matching organisations found"),
+		},
 	}
 
 	for _, v := range testTable {
@@ -175,17 +226,25 @@ func TestHelperOidc_CreateSession(t *testing.T) {
 				t.Fatalf("cannot create mock: %s", err)
 			}
 
+			tokenCipher, err := NewTokenCipher(testSessionKey)
+			if err != nil {
+				t.Fatalf("cannot create cipher: %s", err)
+			}
+
 			var namesOrgs []string
 			for _, org := range v.orgs {
 				namesOrgs = append(namesOrgs, org.name)
 			}
 
-			mock.ExpectQuery(fmt.Sprintf("SELECT id FROM organisations WHERE name in ('%s')", strings.Join(namesOrgs, "','"))).
+			mock.ExpectQuery("SELECT id FROM organisations WHERE name = ANY($1)").
+				WithArgs(pq.Array(namesOrgs)).
 				WillReturnRows(v.orgRows)
 
-			mock.ExpectExec("INSERT INTO token (token, valid, write_contract) VALUES ($1, $2, $3)").
-				WithArgs(v.token, v.valid, false).
-				WillReturnResult(v.tokenResult)
+			if v.tokenResult != nil {
+				mock.ExpectExec("INSERT INTO token (token, valid, write_contract, delete_contract, write_result, admin, subject, encrypted_refresh_token) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)").
+					WithArgs(v.token, v.valid, v.permissions.ContractCreate, v.permissions.ContractDelete, v.permissions.ResultWrite, v.permissions.Admin, "subject", encryptedArg{cipher: tokenCipher, token: "refresh"}).
+					WillReturnResult(v.tokenResult)
+			}
 
 			for _, org := range v.orgs {
 				mock.ExpectExec("INSERT INTO token_permission (token, organisation) VALUES ($1, $2)").
@@ -193,8 +252,10 @@ func TestHelperOidc_CreateSession(t *testing.T) {
 					WillReturnResult(dbMock.NewResult(0, 1))
 			}
 
-			helper := NewAuthHelper(db, "")
-			err = helper.CreateSession(v.token, namesOrgs, []string{}, v.valid)
+			helper := NewAuthHelper(db, tokenCipher)
+			permissions := v.permissions
+			permissions.Organisations = namesOrgs
+			err = helper.CreateSession(v.token, permissions, v.valid, "subject", "refresh")
 
 			if !reflect.DeepEqual(err, v.err) {
 				t.Errorf("expected error != returned error\n\t%s != %s", v.err, err)
@@ -235,7 +296,7 @@ func TestHelperOidc_DeleteSession(t *testing.T) {
 				WithArgs(v.token).
 				WillReturnResult(v.result)
 
-			helper := NewAuthHelper(db, "")
+			helper := NewAuthHelper(db, discardCipher{})
 			err = helper.DeleteSession(v.token)
 
 			if err := mock.ExpectationsWereMet(); err != nil {
@@ -315,3 +376,51 @@ func TestHelperOidc_TokenValid(t *testing.T) {
 		})
 	}
 }
+
+func TestHelperOidc_Organisations(t *testing.T) {
+	testTable := []struct {
+		description   string
+		token         string
+		statusCode    int
+		organisations []string
+		rows          *dbMock.Rows
+	}{
+		{"empty token", "", http.StatusUnauthorized, nil, nil},
+		{"organisations", "token", 0, []string{"org1", "org2"}, dbMock.NewRows([]string{"name"}).AddRow("org1").AddRow("org2")},
+		{"no organisations", "token", 0, nil, dbMock.NewRows([]string{"name"})},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/contract/c1", nil)
+			req.Header.Set("token", v.token)
+
+			db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("cannot create mocked databse: %s", err)
+			}
+			defer db.Close()
+
+			if v.rows != nil {
+				mock.ExpectQuery("SELECT o.name FROM token AS t JOIN token_permission tp on t.token = tp.token JOIN organisations o on tp.organisation = o.id WHERE t.token = $1 AND t.valid >= NOW() ORDER BY o.name").
+					WithArgs(v.token).
+					WillReturnRows(v.rows)
+			}
+
+			organisations, statusCode, err := helperOidc{db: db}.Organisations(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if statusCode != v.statusCode {
+				t.Errorf("expected status code %d, got %d", v.statusCode, statusCode)
+			}
+			if !reflect.DeepEqual(organisations, v.organisations) {
+				t.Errorf("expected organisations %v, got %v", v.organisations, organisations)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("not all expectations were met: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+	"k8s.io/klog"
+)
+
+// ErrSessionsNotSupported is returned, if a session should be created, but only bearer tokens are accepted
+var ErrSessionsNotSupported = errors.New("sessions are not supported")
+
+// ClaimsVerifier verifies a bearer token and returns its claims
+type ClaimsVerifier interface {
+	Verify(token string) (Claims, error)
+}
+
+// NewClaimsVerifier creates a verifier of the tokens of the issuer, which are issued for the audience. The keys of
+// the issuer are cached and refreshed, if a token is signed with an unknown key.
+func NewClaimsVerifier(issuer, audience string) (ClaimsVerifier, error) {
+	provider, err := oidc.NewProvider(context.Background(), issuer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create auth provider: %s", err)
+	}
+
+	return oidcClaimsVerifier{verifier: provider.Verifier(&oidc.Config{ClientID: audience})}, nil
+}
+
+type oidcClaimsVerifier struct {
+	verifier *oidc.IDTokenVerifier
+}
+
+func (o oidcClaimsVerifier) Verify(token string) (Claims, error) {
+	idToken, err := o.verifier.Verify(context.Background(), token)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("cannot get claims: %s", err)
+	}
+	return claims, nil
+}
+
+// NewJWTHelper creates a helper, which authenticates requests with a bearer token in the Authorization header.
+// The claims of the token are mapped to the permissions like in a session. Requests without a bearer token are
+// authenticated by the next helper; if next is nil, only bearer tokens are accepted.
+func NewJWTHelper(db *sql.DB, verifier ClaimsVerifier, mapping ClaimMapping, next Helper) Helper {
+	return jwtHelper{db: db, verifier: verifier, mapping: mapping, next: next}
+}
+
+type jwtHelper struct {
+	db       *sql.DB
+	verifier ClaimsVerifier
+	mapping  ClaimMapping
+	next     Helper
+}
+
+// bearerToken returns the bearer token of the Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+// permissions verifies the bearer token of the request and maps its claims; ok is false, if the request contains
+// no valid bearer token
+func (j jwtHelper) permissions(r *http.Request, token string) (Permissions, bool) {
+	claims, err := j.verifier.Verify(token)
+	if err != nil {
+		klog.Infof("bearer token of request %s is not valid: %s", r.URL.Path, err)
+		return Permissions{}, false
+	}
+	return j.mapping.Permissions(claims), true
+}
+
+func (j jwtHelper) IsAuthenticated(request *http.Request, contract string, write bool) (bool, int, error) {
+	token, ok := bearerToken(request)
+	if !ok {
+		if j.next == nil {
+			return false, http.StatusUnauthorized, nil
+		}
+		return j.next.IsAuthenticated(request, contract, write)
+	}
+
+	permissions, ok := j.permissions(request, token)
+	if !ok || (write && !permissions.ResultWrite) {
+		return false, http.StatusUnauthorized, nil
+	}
+
+	var table string
+	if write {
+		table = "write_permissions rp"
+	} else {
+		table = "read_permissions rp"
+	}
+
+	var organisation int64
+	err := j.db.QueryRow(fmt.Sprintf("SELECT rp.organisation FROM %s JOIN organisations o on rp.organisation = o.id WHERE rp.contract = $1 AND o.name = ANY($2)", table), contract, pq.Array(permissions.Organisations)).
+		Scan(&organisation)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, http.StatusUnauthorized, nil
+	}
+	if err != nil {
+		return false, http.StatusInternalServerError, err
+	}
+
+	return true, 0, nil
+}
+
+func (j jwtHelper) TokenValid(r *http.Request) (bool, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		if j.next == nil {
+			return false, nil
+		}
+		return j.next.TokenValid(r)
+	}
+
+	_, ok = j.permissions(r, token)
+	return ok, nil
+}
+
+// access checks the permissions of the bearer token with granted
+func (j jwtHelper) access(r *http.Request, granted func(Permissions) bool, next func(*http.Request) (bool, int, error)) (bool, int, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		if j.next == nil {
+			return false, http.StatusUnauthorized, nil
+		}
+		return next(r)
+	}
+
+	permissions, ok := j.permissions(r, token)
+	if !ok {
+		return false, http.StatusUnauthorized, nil
+	}
+	return granted(permissions), 0, nil
+}
+
+func (j jwtHelper) ContractWriteAccess(r *http.Request) (bool, int, error) {
+	var next func(*http.Request) (bool, int, error)
+	if j.next != nil {
+		next = j.next.ContractWriteAccess
+	}
+	return j.access(r, func(p Permissions) bool { return p.ContractCreate }, next)
+}
+
+func (j jwtHelper) ContractDeleteAccess(r *http.Request) (bool, int, error) {
+	var next func(*http.Request) (bool, int, error)
+	if j.next != nil {
+		next = j.next.ContractDeleteAccess
+	}
+	return j.access(r, func(p Permissions) bool { return p.ContractDelete }, next)
+}
+
+func (j jwtHelper) AdminAccess(r *http.Request) (bool, int, error) {
+	var next func(*http.Request) (bool, int, error)
+	if j.next != nil {
+		next = j.next.AdminAccess
+	}
+	return j.access(r, func(p Permissions) bool { return p.Admin }, next)
+}
+
+func (j jwtHelper) Organisations(r *http.Request) ([]string, int, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		if j.next == nil {
+			return nil, http.StatusUnauthorized, nil
+		}
+		return j.next.Organisations(r)
+	}
+
+	permissions, ok := j.permissions(r, token)
+	if !ok {
+		return nil, http.StatusUnauthorized, nil
+	}
+	return permissions.Organisations, 0, nil
+}
+
+// ReadScope returns the organisations of the claims of the bearer token
+func (j jwtHelper) ReadScope(r *http.Request) (Scope, int, error) {
+	if _, ok := bearerToken(r); !ok && j.next != nil {
+		return j.next.ReadScope(r)
+	}
+
+	organisations, statusCode, err := j.Organisations(r)
+	return Scope{Organisations: organisations}, statusCode, err
+}
+
+func (j jwtHelper) CreateSession(token string, permissions Permissions, valid time.Time, subject, refreshToken string) error {
+	if j.next == nil {
+		return ErrSessionsNotSupported
+	}
+	return j.next.CreateSession(token, permissions, valid, subject, refreshToken)
+}
+
+func (j jwtHelper) DeleteSession(token string) error {
+	if j.next == nil {
+		return ErrSessionsNotSupported
+	}
+	return j.next.DeleteSession(token)
+}
+
+func (j jwtHelper) CleanUp() {
+	if j.next != nil {
+		j.next.CleanUp()
+	}
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	dbMock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// testClaimsVerifier accepts the token "valid" with the claims
+type testClaimsVerifier struct {
+	claims Claims
+}
+
+func (v testClaimsVerifier) Verify(token string) (Claims, error) {
+	if token != "valid" {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return v.claims, nil
+}
+
+func TestBearerToken(t *testing.T) {
+	testTable := []struct {
+		header string
+		token  string
+		ok     bool
+	}{
+		{"Bearer abc", "abc", true},
+		{"bearer abc", "abc", true},
+		{"Basic abc", "", false},
+		{"", "", false},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.header, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/contract/", nil)
+			req.Header.Set("Authorization", v.header)
+
+			token, ok := bearerToken(req)
+			if token != v.token || ok != v.ok {
+				t.Errorf("expected (%s, %t), got (%s, %t)", v.token, v.ok, token, ok)
+			}
+		})
+	}
+}
+
+func TestJWTHelper_IsAuthenticated(t *testing.T) {
+	query := "SELECT rp.organisation FROM write_permissions rp JOIN organisations o on rp.organisation = o.id WHERE rp.contract = $1 AND o.name = ANY($2)"
+
+	testTable := []struct {
+		description   string
+		header        string
+		resultWrite   bool
+		rows          *dbMock.Rows
+		authenticated bool
+		statusCode    int
+	}{
+		{"permitted", "Bearer valid", true, dbMock.NewRows([]string{"organisation"}).AddRow(1), true, 0},
+		{"no permission", "Bearer valid", true, dbMock.NewRows([]string{"organisation"}), false, http.StatusUnauthorized},
+		{"no write capability", "Bearer valid", false, nil, false, http.StatusUnauthorized},
+		{"invalid token", "Bearer invalid", true, nil, false, http.StatusUnauthorized},
+		{"no token", "", true, nil, false, http.StatusUnauthorized},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("cannot create mock: %s", err)
+			}
+			defer db.Close()
+
+			if v.rows != nil {
+				mock.ExpectQuery(query).WithArgs("contract", pq.Array([]string{"org"})).WillReturnRows(v.rows)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/machine-data", nil)
+			if v.header != "" {
+				req.Header.Set("Authorization", v.header)
+			}
+
+			mapping := DefaultClaimMapping()
+			if !v.resultWrite {
+				mapping.DefaultCapabilities = nil
+			}
+
+			helper := NewJWTHelper(db, testClaimsVerifier{claims: Claims{"groups": []interface{}{"org"}}}, mapping, nil)
+			authenticated, statusCode, err := helper.IsAuthenticated(req, "contract", true)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if authenticated != v.authenticated || statusCode != v.statusCode {
+				t.Errorf("expected (%t, %d), got (%t, %d)", v.authenticated, v.statusCode, authenticated, statusCode)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("not all expectations were met: %s", err)
+			}
+		})
+	}
+}
+
+func TestJWTHelper_Access(t *testing.T) {
+	testTable := []struct {
+		description    string
+		roles          []interface{}
+		next           Helper
+		header         string
+		contractWrite  bool
+		contractDelete bool
+		admin          bool
+	}{
+		{"contract write role", []interface{}{"contract_create"}, nil, "Bearer valid", true, true, false},
+		{"admin role", []interface{}{"admin"}, nil, "Bearer valid", false, false, true},
+		{"no roles", nil, nil, "Bearer valid", false, false, false},
+		{"session without bearer token", nil, testSessionHelper{admin: true}, "", false, false, true},
+		{"bearer token preferred", nil, testSessionHelper{admin: true}, "Bearer valid", false, false, false},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/contract/", nil)
+			if v.header != "" {
+				req.Header.Set("Authorization", v.header)
+			}
+
+			helper := NewJWTHelper(nil, testClaimsVerifier{claims: Claims{"roles": v.roles}}, DefaultClaimMapping(), v.next)
+
+			if contractWrite, _, _ := helper.ContractWriteAccess(req); contractWrite != v.contractWrite {
+				t.Errorf("expected contract write access %t, got %t", v.contractWrite, contractWrite)
+			}
+
+			if contractDelete, _, _ := helper.ContractDeleteAccess(req); contractDelete != v.contractDelete {
+				t.Errorf("expected contract delete access %t, got %t", v.contractDelete, contractDelete)
+			}
+
+			if admin, _, _ := helper.AdminAccess(req); admin != v.admin {
+				t.Errorf("expected admin access %t, got %t", v.admin, admin)
+			}
+		})
+	}
+}
+
+func TestJWTHelper_ReadScope(t *testing.T) {
+	testTable := []struct {
+		description string
+		next        Helper
+		header      string
+		scope       Scope
+		statusCode  int
+	}{
+		{"bearer token", nil, "Bearer valid", Scope{Organisations: []string{"org"}}, 0},
+		{"bearer token preferred", testScopeHelper{}, "Bearer valid", Scope{Organisations: []string{"org"}}, 0},
+		{"invalid token", nil, "Bearer invalid", Scope{}, http.StatusUnauthorized},
+		{"no token", nil, "", Scope{}, http.StatusUnauthorized},
+		{"session without bearer token", testScopeHelper{}, "", Scope{Organisations: []string{"session"}}, 0},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/contract", nil)
+			if v.header != "" {
+				req.Header.Set("Authorization", v.header)
+			}
+
+			helper := NewJWTHelper(nil, testClaimsVerifier{claims: Claims{"groups": []interface{}{"org"}}}, DefaultClaimMapping(), v.next)
+			scope, statusCode, err := helper.ReadScope(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if statusCode != v.statusCode || !reflect.DeepEqual(scope, v.scope) {
+				t.Errorf("expected (%v, %d), got (%v, %d)", v.scope, v.statusCode, scope, statusCode)
+			}
+		})
+	}
+}
+
+// testScopeHelper returns the organisation of a session
+type testScopeHelper struct {
+	Helper
+}
+
+func (testScopeHelper) ReadScope(*http.Request) (Scope, int, error) {
+	return Scope{Organisations: []string{"session"}}, 0, nil
+}
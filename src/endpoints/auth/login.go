@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// loginStateValidity is the time, in which a login has to be finished
+const loginStateValidity = 10 * time.Minute
+
+// loginCodeValidity is the time, in which the code of a login has to be exchanged for the session token
+const loginCodeValidity = time.Minute
+
+// ErrLoginStateNotFound is returned, if the state of a login does not exist, is expired or is already used
+var ErrLoginStateNotFound = errors.New("login state not found")
+
+// ErrLoginCodeNotFound is returned, if the code of a login does not exist, is expired or is already exchanged
+var ErrLoginCodeNotFound = errors.New("login code not found")
+
+// LoginState is the server side state of a single login
+type LoginState struct {
+	State string
+	// Nonce has to be contained in the id token
+	Nonce string
+	// Verifier is the PKCE code verifier of the code exchange
+	Verifier string
+	// Redirect is the uri, to which the token is delivered; if it is empty, the token is returned in the response
+	Redirect string
+	Valid    time.Time
+}
+
+// LoginCode is a one-time code, which is delivered to the redirect uri of a login instead of the session token,
+// so that the session token does not appear in the browser history or in the logs of the redirect uri
+type LoginCode struct {
+	Code string
+	// Token is the session token, for which the code is exchanged
+	Token string
+	// Session is the validity of the session
+	Session time.Time
+	Valid   time.Time
+}
+
+// randomString returns a base64url encoded random value
+func randomString() (string, error) {
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		return "", fmt.Errorf("cannot generate random value: %s", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(value), nil
+}
+
+// newLoginState creates the state, the nonce and the PKCE code verifier of a new login
+func newLoginState(redirect string, now time.Time) (LoginState, error) {
+	values := make([]string, 3)
+	for i := range values {
+		value, err := randomString()
+		if err != nil {
+			return LoginState{}, err
+		}
+		values[i] = value
+	}
+
+	return LoginState{
+		State:    values[0],
+		Nonce:    values[1],
+		Verifier: values[2],
+		Redirect: redirect,
+		Valid:    now.Add(loginStateValidity),
+	}, nil
+}
+
+// codeChallenge returns the S256 PKCE code challenge of the verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// allowedRedirect checks, if the redirect uri without its query is part of the allowed uris
+func allowedRedirect(redirect string, allowed []string) bool {
+	u, err := url.Parse(redirect)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+
+	u.RawQuery = ""
+	for _, a := range allowed {
+		if u.String() == a {
+			return true
+		}
+	}
+	return false
+}
+
+// LoginStateStore stores the states of the logins, which are not finished yet
+type LoginStateStore interface {
+	// Create stores the state of a new login
+	Create(LoginState) error
+
+	// Consume returns and deletes the state, so that a state can only be used once
+	Consume(state string) (LoginState, error)
+
+	// CreateCode stores the code of a finished login
+	CreateCode(LoginCode) error
+
+	// ConsumeCode returns and deletes the code, so that a code can only be exchanged once
+	ConsumeCode(code string) (LoginCode, error)
+
+	// CleanUp will run every hour and will remove all expired states
+	CleanUp()
+}
+
+// NewLoginStateStore creates a store of the login states, which uses the database
+func NewLoginStateStore(db *sql.DB) LoginStateStore {
+	return loginStateStore{db: db}
+}
+
+type loginStateStore struct {
+	db *sql.DB
+}
+
+func (l loginStateStore) Create(state LoginState) error {
+	_, err := l.db.Exec("INSERT INTO login_state (state, nonce, verifier, redirect, valid) VALUES ($1, $2, $3, $4, $5)",
+		state.State, state.Nonce, state.Verifier, state.Redirect, state.Valid)
+	return err
+}
+
+func (l loginStateStore) Consume(state string) (LoginState, error) {
+	loginState := LoginState{State: state}
+	err := l.db.QueryRow("DELETE FROM login_state WHERE state = $1 AND valid >= NOW() RETURNING nonce, verifier, redirect, valid", state).
+		Scan(&loginState.Nonce, &loginState.Verifier, &loginState.Redirect, &loginState.Valid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginState{}, ErrLoginStateNotFound
+	}
+	if err != nil {
+		return LoginState{}, err
+	}
+	return loginState, nil
+}
+
+// CreateCode stores only the hash of the code, like the secret of an api key
+func (l loginStateStore) CreateCode(code LoginCode) error {
+	_, err := l.db.Exec("INSERT INTO login_code (code, token, session, valid) VALUES ($1, $2, $3, $4)",
+		hashSecret(code.Code), code.Token, code.Session, code.Valid)
+	return err
+}
+
+func (l loginStateStore) ConsumeCode(code string) (LoginCode, error) {
+	loginCode := LoginCode{Code: code}
+	err := l.db.QueryRow("DELETE FROM login_code WHERE code = $1 AND valid >= NOW() RETURNING token, session, valid", hashSecret(code)).
+		Scan(&loginCode.Token, &loginCode.Session, &loginCode.Valid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginCode{}, ErrLoginCodeNotFound
+	}
+	if err != nil {
+		return LoginCode{}, err
+	}
+	return loginCode, nil
+}
+
+func (l loginStateStore) cleanUp() error {
+	for _, table := range []string{"login_state", "login_code"} {
+		res, err := l.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE valid < NOW()", table))
+		if err != nil {
+			return fmt.Errorf("cannot delete expired rows of %s: %s", table, err)
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("cannot get the number of rows: %s", err)
+		}
+		klog.Infof("removing %d expired rows of %s", rows, table)
+	}
+	return nil
+}
+
+func (l loginStateStore) CleanUp() {
+	for {
+		if err := l.cleanUp(); err != nil {
+			klog.Error(err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	dbMock "github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/oauth2"
+)
+
+// testLoginStateStore stores the login states in memory
+type testLoginStateStore struct {
+	states map[string]LoginState
+	codes  map[string]LoginCode
+}
+
+func (s *testLoginStateStore) Create(state LoginState) error {
+	s.states[state.State] = state
+	return nil
+}
+
+func (s *testLoginStateStore) Consume(state string) (LoginState, error) {
+	loginState, ok := s.states[state]
+	if !ok {
+		return LoginState{}, ErrLoginStateNotFound
+	}
+	delete(s.states, state)
+	return loginState, nil
+}
+
+func (s *testLoginStateStore) CreateCode(code LoginCode) error {
+	s.codes[code.Code] = code
+	return nil
+}
+
+func (s *testLoginStateStore) ConsumeCode(code string) (LoginCode, error) {
+	loginCode, ok := s.codes[code]
+	if !ok {
+		return LoginCode{}, ErrLoginCodeNotFound
+	}
+	delete(s.codes, code)
+	return loginCode, nil
+}
+
+func (s *testLoginStateStore) CleanUp() {}
+
+func TestCodeChallenge(t *testing.T) {
+	// example of RFC 7636 appendix B
+	if challenge := codeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); challenge != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("unexpected code challenge %s", challenge)
+	}
+}
+
+func TestAllowedRedirect(t *testing.T) {
+	allowed := []string{"http://127.0.0.1:8000/callback", "https://ui.example.com/login"}
+
+	testTable := []struct {
+		redirect string
+		allowed  bool
+	}{
+		{"http://127.0.0.1:8000/callback", true},
+		{"https://ui.example.com/login?next=/contracts", true},
+		{"https://ui.example.com/login/other", false},
+		{"https://ui.example.com.evil.com/login", false},
+		{"https://ui.example.com/login#token", false},
+		{"/login", false},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.redirect, func(t *testing.T) {
+			if allowed := allowedRedirect(v.redirect, allowed); allowed != v.allowed {
+				t.Errorf("expected %t, got %t", v.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestRedirectWithCode(t *testing.T) {
+	redirect := redirectWithCode("https://ui.example.com/login?next=x", "code")
+	if redirect != "https://ui.example.com/login?code=code&next=x" {
+		t.Errorf("unexpected redirect %s", redirect)
+	}
+}
+
+func TestOidcAuth_handleToken(t *testing.T) {
+	valid := time.Date(2020, 9, 23, 10, 0, 0, 0, time.UTC)
+	states := &testLoginStateStore{
+		states: make(map[string]LoginState),
+		codes:  map[string]LoginCode{"code": {Code: "code", Token: "token", Session: valid}},
+	}
+	o := oidcAuth{states: states, regexBase: pathRegexp("auth", ""), regexCallback: pathRegexp("auth", "/callback"), regexToken: pathRegexp("auth", "/token")}
+
+	exchange := func(code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(url.Values{"code": {code}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		o.ServeHTTP(w, req)
+		return w
+	}
+
+	w := exchange("code")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var token sessionToken
+	if err := json.Unmarshal(w.Body.Bytes(), &token); err != nil {
+		t.Fatalf("cannot parse token: %s", err)
+	}
+	if token.Token != "token" || !token.Valid.Equal(valid) {
+		t.Errorf("unexpected token %v", token)
+	}
+
+	// a code can only be exchanged once
+	if w := exchange("code"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestOidcAuth_handleBase(t *testing.T) {
+	testTable := []struct {
+		description string
+		redirect    string
+		statusCode  int
+	}{
+		{"without redirect", "", http.StatusTemporaryRedirect},
+		{"allowed redirect", "http://127.0.0.1:8000/callback", http.StatusTemporaryRedirect},
+		{"redirect not allowed", "http://evil.com/callback", http.StatusBadRequest},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			states := &testLoginStateStore{states: make(map[string]LoginState)}
+			o := oidcAuth{
+				config:    oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{AuthURL: "https://idp.example.com/auth"}},
+				states:    states,
+				redirects: []string{"http://127.0.0.1:8000/callback"},
+				now:       time.Now,
+			}
+
+			target := "/auth"
+			if v.redirect != "" {
+				target += "?redirect_uri=" + url.QueryEscape(v.redirect)
+			}
+
+			w := httptest.NewRecorder()
+			o.handleBase(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+			if w.Code != v.statusCode {
+				t.Fatalf("expected status code %d, got %d", v.statusCode, w.Code)
+			}
+
+			if v.statusCode != http.StatusTemporaryRedirect {
+				if len(states.states) != 0 {
+					t.Errorf("login state stored for a rejected login")
+				}
+				return
+			}
+
+			location, err := url.Parse(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("cannot parse location: %s", err)
+			}
+
+			query := location.Query()
+			state, ok := states.states[query.Get("state")]
+			if !ok {
+				t.Fatalf("login state %s is not stored", query.Get("state"))
+			}
+
+			if query.Get("nonce") != state.Nonce || query.Get("code_challenge") != codeChallenge(state.Verifier) ||
+				query.Get("code_challenge_method") != "S256" || state.Redirect != v.redirect {
+				t.Errorf("unexpected authorization request %s for state %v", location, state)
+			}
+		})
+	}
+}
+
+func TestOidcAuth_handleCallbackUnknownState(t *testing.T) {
+	o := oidcAuth{states: &testLoginStateStore{states: make(map[string]LoginState)}}
+
+	w := httptest.NewRecorder()
+	o.handleCallback(w, httptest.NewRequest(http.MethodGet, "/auth/callback?state=unknown&code=code", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestLoginStateStore_Consume(t *testing.T) {
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mock: %s", err)
+	}
+	defer db.Close()
+
+	valid := time.Date(2020, 9, 23, 10, 0, 0, 0, time.UTC)
+	query := "DELETE FROM login_state WHERE state = $1 AND valid >= NOW() RETURNING nonce, verifier, redirect, valid"
+	mock.ExpectQuery(query).WithArgs("state").
+		WillReturnRows(dbMock.NewRows([]string{"nonce", "verifier", "redirect", "valid"}).AddRow("nonce", "verifier", "", valid))
+	mock.ExpectQuery(query).WithArgs("state").
+		WillReturnRows(dbMock.NewRows([]string{"nonce", "verifier", "redirect", "valid"}))
+
+	store := NewLoginStateStore(db)
+	state, err := store.Consume("state")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if state != (LoginState{State: "state", Nonce: "nonce", Verifier: "verifier", Valid: valid}) {
+		t.Errorf("unexpected login state %v", state)
+	}
+
+	// a state can only be used once
+	if _, err := store.Consume("state"); err != ErrLoginStateNotFound {
+		t.Errorf("expected %s, got %v", ErrLoginStateNotFound, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("not all expectations were met: %s", err)
+	}
+}
+
+func TestLoginStateStore_ConsumeCode(t *testing.T) {
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mock: %s", err)
+	}
+	defer db.Close()
+
+	session := time.Date(2020, 9, 23, 10, 0, 0, 0, time.UTC)
+	valid := time.Date(2020, 9, 23, 9, 1, 0, 0, time.UTC)
+
+	// only the hash of the code is stored
+	mock.ExpectQuery("DELETE FROM login_code WHERE code = $1 AND valid >= NOW() RETURNING token, session, valid").
+		WithArgs(hashSecret("code")).
+		WillReturnRows(dbMock.NewRows([]string{"token", "session", "valid"}).AddRow("token", session, valid))
+
+	code, err := NewLoginStateStore(db).ConsumeCode("code")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if code != (LoginCode{Code: "code", Token: "token", Session: session, Valid: valid}) {
+		t.Errorf("unexpected login code %v", code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("not all expectations were met: %s", err)
+	}
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Capability is a right, which is granted to the users with a role
+type Capability string
+
+const (
+	// CapabilityContractCreate allows to create and update contracts
+	CapabilityContractCreate Capability = "contractCreate"
+	// CapabilityContractDelete allows to remove contracts
+	CapabilityContractDelete Capability = "contractDelete"
+	// CapabilityResultWrite allows to write to the contracts, on which the organisations of the user have
+	// write permissions
+	CapabilityResultWrite Capability = "resultWrite"
+	// CapabilityAdmin allows to manage the api keys and the sessions of the users
+	CapabilityAdmin Capability = "admin"
+)
+
+// ParseCapability returns the capability of the name
+func ParseCapability(name string) (Capability, error) {
+	switch capability := Capability(name); capability {
+	case CapabilityContractCreate, CapabilityContractDelete, CapabilityResultWrite, CapabilityAdmin:
+		return capability, nil
+	default:
+		return "", fmt.Errorf("unknown capability %s", name)
+	}
+}
+
+// Permissions are the organisations and the capabilities of a user
+type Permissions struct {
+	Organisations  []string
+	ContractCreate bool
+	ContractDelete bool
+	ResultWrite    bool
+	Admin          bool
+}
+
+// grant adds the capability to the permissions
+func (p *Permissions) grant(capability Capability) {
+	switch capability {
+	case CapabilityContractCreate:
+		p.ContractCreate = true
+	case CapabilityContractDelete:
+		p.ContractDelete = true
+	case CapabilityResultWrite:
+		p.ResultWrite = true
+	case CapabilityAdmin:
+		p.Admin = true
+	}
+}
+
+// Claims are the claims of a token of the user management
+type Claims map[string]interface{}
+
+// strings returns the values of the claim; the name can contain dots to select a nested claim like
+// realm_access.roles. A single string is returned as a list with one value.
+func (c Claims) strings(name string) []string {
+	var value interface{} = map[string]interface{}(c)
+	for _, key := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// ClaimMapping maps the claims of a token of the user management to the permissions of the user
+type ClaimMapping struct {
+	// GroupsClaim is the claim, which contains the groups of the user
+	GroupsClaim string
+	// RolesClaim is the claim, which contains the roles of the user
+	RolesClaim string
+	// GroupPrefix is removed from the groups; if it is set, groups without the prefix are ignored
+	GroupPrefix string
+	// GroupPattern extracts the organisation of a group, the first submatch or else the whole match is used;
+	// if it is set, groups which do not match are ignored
+	GroupPattern *regexp.Regexp
+	// Capabilities are granted to the users with the role
+	Capabilities map[string][]Capability
+	// DefaultCapabilities are granted to every user
+	DefaultCapabilities []Capability
+}
+
+// DefaultClaimMapping returns the mapping, in which the groups are the organisations, the role contract_create
+// allows to create and remove contracts, the role admin is the administrator and every user can upload results
+func DefaultClaimMapping() ClaimMapping {
+	return ClaimMapping{
+		GroupsClaim: "groups",
+		RolesClaim:  "roles",
+		Capabilities: map[string][]Capability{
+			"contract_create": {CapabilityContractCreate, CapabilityContractDelete},
+			"admin":           {CapabilityAdmin},
+		},
+		DefaultCapabilities: []Capability{CapabilityResultWrite},
+	}
+}
+
+// organisation returns the organisation of the group
+func (m ClaimMapping) organisation(group string) (string, bool) {
+	if m.GroupPrefix != "" {
+		if !strings.HasPrefix(group, m.GroupPrefix) {
+			return "", false
+		}
+		group = strings.TrimPrefix(group, m.GroupPrefix)
+	}
+
+	if m.GroupPattern != nil {
+		match := m.GroupPattern.FindStringSubmatch(group)
+		switch {
+		case match == nil:
+			return "", false
+		case len(match) > 1:
+			group = match[1]
+		default:
+			group = match[0]
+		}
+	}
+
+	return group, group != ""
+}
+
+// Permissions returns the permissions of the user with the claims
+func (m ClaimMapping) Permissions(claims Claims) Permissions {
+	permissions := Permissions{Organisations: []string{}}
+	for _, group := range claims.strings(m.GroupsClaim) {
+		if organisation, ok := m.organisation(group); ok {
+			permissions.Organisations = append(permissions.Organisations, organisation)
+		}
+	}
+
+	for _, capability := range m.DefaultCapabilities {
+		permissions.grant(capability)
+	}
+	for _, role := range claims.strings(m.RolesClaim) {
+		for _, capability := range m.Capabilities[role] {
+			permissions.grant(capability)
+		}
+	}
+
+	return permissions
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestClaims_strings(t *testing.T) {
+	claims := Claims{
+		"groups":       []interface{}{"a", "b", 1},
+		"role":         "admin",
+		"realm_access": map[string]interface{}{"roles": []interface{}{"contract_create"}},
+	}
+
+	testTable := []struct {
+		name   string
+		values []string
+	}{
+		{"groups", []string{"a", "b"}},
+		{"role", []string{"admin"}},
+		{"realm_access.roles", []string{"contract_create"}},
+		{"realm_access.groups", nil},
+		{"role.name", nil},
+		{"unknown", nil},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.name, func(t *testing.T) {
+			if values := claims.strings(v.name); !reflect.DeepEqual(values, v.values) {
+				t.Errorf("expected %v, got %v", v.values, values)
+			}
+		})
+	}
+}
+
+func TestClaimMapping_Permissions(t *testing.T) {
+	keycloak := ClaimMapping{
+		GroupsClaim:  "groups",
+		RolesClaim:   "realm_access.roles",
+		GroupPrefix:  "/orgs/",
+		GroupPattern: regexp.MustCompile(`^([^/]+)`),
+		Capabilities: map[string][]Capability{
+			"connector-contracts": {CapabilityContractCreate},
+			"connector-cleanup":   {CapabilityContractDelete},
+			"connector-edge":      {CapabilityResultWrite},
+		},
+	}
+
+	testTable := []struct {
+		description string
+		mapping     ClaimMapping
+		claims      Claims
+		permissions Permissions
+	}{
+		{
+			"default mapping",
+			DefaultClaimMapping(),
+			Claims{"groups": []interface{}{"acme"}, "roles": []interface{}{"contract_create"}},
+			Permissions{Organisations: []string{"acme"}, ContractCreate: true, ContractDelete: true, ResultWrite: true},
+		},
+		{
+			"default mapping admin",
+			DefaultClaimMapping(),
+			Claims{"roles": []interface{}{"admin"}},
+			Permissions{Organisations: []string{}, ResultWrite: true, Admin: true},
+		},
+		{
+			"group paths and nested roles",
+			keycloak,
+			Claims{
+				"groups":       []interface{}{"/orgs/acme", "/orgs/acme/site-1", "/staff", "/orgs/"},
+				"realm_access": map[string]interface{}{"roles": []interface{}{"connector-edge", "connector-cleanup"}},
+			},
+			Permissions{Organisations: []string{"acme", "acme"}, ContractDelete: true, ResultWrite: true},
+		},
+		{
+			"no claims",
+			keycloak,
+			Claims{},
+			Permissions{Organisations: []string{}},
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			if permissions := v.mapping.Permissions(v.claims); !reflect.DeepEqual(permissions, v.permissions) {
+				t.Errorf("expected %+v, got %+v", v.permissions, permissions)
+			}
+		})
+	}
+}
+
+func TestParseCapability(t *testing.T) {
+	for _, name := range []string{"contractCreate", "contractDelete", "resultWrite", "admin"} {
+		if capability, err := ParseCapability(name); err != nil || string(capability) != name {
+			t.Errorf("cannot parse capability %s: %v", name, err)
+		}
+	}
+
+	if _, err := ParseCapability("contract_create"); err == nil {
+		t.Errorf("unknown capability is parsed")
+	}
+}
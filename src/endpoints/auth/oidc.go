@@ -1,15 +1,17 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/coreos/go-oidc"
-	"github.com/google/uuid"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"k8s.io/klog"
@@ -23,14 +25,25 @@ type oidcAuth struct {
 	oidcConfig    *oidc.Config
 	verifier      *oidc.IDTokenVerifier
 	config        oauth2.Config
-	state         string
+	states        LoginStateStore
+	sessions      SessionStore
+	mapping       ClaimMapping
+	redirects     []string
 	regexBase     *regexp.Regexp
 	regexCallback *regexp.Regexp
+	regexToken    *regexp.Regexp
+	regexMe       *regexp.Regexp
+	regexRefresh  *regexp.Regexp
+	regexSessions *regexp.Regexp
 	helper        Helper
 	generator     TokenGenerate
+	now           func() time.Time
 }
 
-func NewOidcAuth(userMgmt, basePath, clientSecret, clientId, serverAddress string, helper Helper) (Auth, error) {
+// NewOidcAuth creates the login endpoint; the states of the logins are stored in states and the token can
+// only be delivered to the redirect uris, which are part of redirects. The sessions are used to describe,
+// refresh and revoke the sessions. The claims of the id token are mapped to the permissions of the session with mapping.
+func NewOidcAuth(userMgmt, basePath, clientSecret, clientId, serverAddress string, helper Helper, states LoginStateStore, sessions SessionStore, mapping ClaimMapping, redirects []string) (Auth, error) {
 	ctx := context.Background()
 	issuer := userMgmt
 	klog.Infof("issuer url: %s", issuer)
@@ -54,20 +67,23 @@ func NewOidcAuth(userMgmt, basePath, clientSecret, clientId, serverAddress strin
 		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
 	}
 
-	state := uuid.New().String()
-
-	regexBase := regexp.MustCompile(fmt.Sprintf("/%s[/]?$", basePath))
-	regexCallback := regexp.MustCompile(fmt.Sprintf("/%s/callback[/]?$", basePath))
-
 	oidcDat := oidcAuth{
 		oidcConfig:    oidcConfig,
 		verifier:      verifier,
 		config:        config,
-		state:         state,
-		regexBase:     regexBase,
-		regexCallback: regexCallback,
+		states:        states,
+		sessions:      sessions,
+		mapping:       mapping,
+		redirects:     redirects,
+		regexBase:     pathRegexp(basePath, ""),
+		regexCallback: pathRegexp(basePath, "/callback"),
+		regexToken:    pathRegexp(basePath, "/token"),
+		regexMe:       pathRegexp(basePath, "/me"),
+		regexRefresh:  pathRegexp(basePath, "/refresh"),
+		regexSessions: pathRegexp(basePath, "/sessions"),
 		generator:     NewTokenGeneratorUuid(),
 		helper:        helper,
+		now:           time.Now,
 	}
 
 	klog.Infof("using basePath: %s and %s/callback as registered endpoints", basePath, basePath)
@@ -75,6 +91,11 @@ func NewOidcAuth(userMgmt, basePath, clientSecret, clientId, serverAddress strin
 	return oidcDat, nil
 }
 
+// pathRegexp matches the path below the base path of the endpoint
+func pathRegexp(basePath, path string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf("/%s%s[/]?$", basePath, path))
+}
+
 func (o oidcAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	klog.Infof("request url: %s", r.URL.Path)
 	token := r.Header.Get("token")
@@ -94,6 +115,11 @@ func (o oidcAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if o.regexToken.MatchString(url) {
+		o.handleToken(w, r)
+		return
+	}
+
 	w.WriteHeader(http.StatusNotFound)
 }
 
@@ -106,7 +132,32 @@ func (o oidcAuth) handleBase(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		fallthrough
 	case http.MethodPost:
-		http.Redirect(w, r, o.config.AuthCodeURL(o.state), http.StatusTemporaryRedirect)
+		redirect := r.URL.Query().Get("redirect_uri")
+		if redirect != "" && !allowedRedirect(redirect, o.redirects) {
+			klog.Infof("redirect uri %s is not allowed", redirect)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		state, err := newLoginState(redirect, o.now())
+		if err != nil {
+			klog.Errorf("cannot create login state: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := o.states.Create(state); err != nil {
+			klog.Errorf("cannot store login state: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		authURL := o.config.AuthCodeURL(state.State,
+			oidc.Nonce(state.Nonce),
+			oauth2.SetAuthURLParam("code_challenge", codeChallenge(state.Verifier)),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
+		http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
 		return
 	}
 }
@@ -118,13 +169,20 @@ func (o oidcAuth) handleCallback(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	case http.MethodGet:
-		if r.URL.Query().Get("state") != o.state {
+		state, err := o.states.Consume(r.URL.Query().Get("state"))
+		if errors.Is(err, ErrLoginStateNotFound) {
 			klog.Errorf("state did not match")
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		if err != nil {
+			klog.Errorf("cannot get login state: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 
-		oauth2Token, err := o.config.Exchange(context.Background(), r.URL.Query().Get("code"))
+		oauth2Token, err := o.config.Exchange(context.Background(), r.URL.Query().Get("code"),
+			oauth2.SetAuthURLParam("code_verifier", state.Verifier))
 		if err != nil {
 			klog.Errorf("Failed to exchange token: %s", err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -145,68 +203,266 @@ func (o oidcAuth) handleCallback(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(state.Nonce)) != 1 {
+			klog.Errorf("nonce of the id token did not match")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
 		// the access token, can be used as authorisation token
 		// in this application we didn't use it, because it should
 		// be easy possible to add other authentication mechanism
 		//oauth2Token.AccessToken = "*REDACTED*"
 
-		var claims struct {
-			Groups []string `json:"groups"`
-			Roles  []string `json:"roles"`
-		}
-
+		var claims Claims
 		if err := idToken.Claims(&claims); err != nil {
 			klog.Errorf("cannot get id claims: %s", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		klog.Infof("groups: %v", strings.Join(claims.Groups, ", "))
-		klog.Infof("groups: %v", strings.Join(claims.Roles, ", "))
 
-		token := struct {
-			Token string    `json:"token"`
-			Valid time.Time `json:"valid"`
-		}{
+		permissions := o.mapping.Permissions(claims)
+		klog.Infof("organisations: %v", strings.Join(permissions.Organisations, ", "))
+
+		token := sessionToken{
 			o.generator.Generate(),
-			oauth2Token.Expiry,
+			idToken.Expiry,
 		}
 
-		klog.V(2).Infof("claim goups is: %s", claims.Groups)
-		if err := o.helper.CreateSession(token.Token, claims.Groups, claims.Roles, idToken.Expiry); err != nil {
+		if err := o.helper.CreateSession(token.Token, permissions, token.Valid, idToken.Subject, oauth2Token.RefreshToken); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			klog.Errorf("cannot create session: %s", err)
 			return
 		}
 
-		data, err := json.Marshal(token)
+		if state.Redirect != "" {
+			code, err := randomString()
+			if err != nil {
+				klog.Errorf("cannot create login code: %s", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			loginCode := LoginCode{Code: code, Token: token.Token, Session: token.Valid, Valid: o.now().Add(loginCodeValidity)}
+			if err := o.states.CreateCode(loginCode); err != nil {
+				klog.Errorf("cannot store login code: %s", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			http.Redirect(w, r, redirectWithCode(state.Redirect, code), http.StatusSeeOther)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, token)
+	}
+}
+
+// handleToken exchanges the one-time code of a login for the session token
+func (o oidcAuth) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	code := r.FormValue("code")
+	if code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	loginCode, err := o.states.ConsumeCode(code)
+	if errors.Is(err, ErrLoginCodeNotFound) {
+		klog.Infof("login code is unknown, expired or already exchanged")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		klog.Errorf("cannot get login code: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessionToken{Token: loginCode.Token, Valid: loginCode.Session})
+}
+
+// sessionToken is the response of a login or a refresh
+type sessionToken struct {
+	Token string    `json:"token"`
+	Valid time.Time `json:"valid"`
+}
+
+// writeJSON sends the data as json
+func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		klog.Errorf("cannot marshal response: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(bytes); err != nil {
+		klog.Errorf("cannot send response: %s", err)
+	}
+}
+
+// redirectWithCode adds the one-time code of the login to the query of the redirect uri
+func redirectWithCode(redirect, code string) string {
+	u, err := url.Parse(redirect)
+	if err != nil {
+		return redirect
+	}
+
+	query := u.Query()
+	query.Set("code", code)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (o oidcAuth) handleWithToken(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case o.regexMe.MatchString(path) && r.Method == http.MethodGet:
+		o.handleMe(w, r)
+	case o.regexRefresh.MatchString(path) && r.Method == http.MethodPost:
+		o.handleRefresh(w, r)
+	case o.regexSessions.MatchString(path) && (r.Method == http.MethodGet || r.Method == http.MethodDelete):
+		o.handleSessions(w, r)
+	case o.regexBase.MatchString(path) && r.Method == http.MethodDelete:
+		klog.Infof("receive DELETE request with token")
+		if err := o.helper.DeleteSession(r.Header.Get("token")); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case o.regexBase.MatchString(path), o.regexMe.MatchString(path), o.regexRefresh.MatchString(path), o.regexSessions.MatchString(path):
+		klog.Infof("receive request %s with token", r.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// handleMe returns the organisations, the rights and the validity of the session
+func (o oidcAuth) handleMe(w http.ResponseWriter, r *http.Request) {
+	session, err := o.sessions.Get(r.Header.Get("token"))
+	if errors.Is(err, ErrSessionNotFound) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		klog.Errorf("cannot get session: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, session)
+}
+
+// handleRefresh extends the validity of the session with the refresh token of the user management
+func (o oidcAuth) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("token")
+	refreshToken, err := o.sessions.RefreshToken(token)
+	if errors.Is(err, ErrSessionNotFound) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		klog.Errorf("cannot get refresh token: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if refreshToken == "" {
+		klog.Infof("session has no refresh token")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	oauth2Token, err := o.config.TokenSource(context.Background(), &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		klog.Infof("cannot refresh token: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// the permissions are mapped again from the refreshed id token, so that changed groups and roles of the user
+	// take effect; without an id token the session keeps its permissions
+	valid := oauth2Token.Expiry
+	var permissions *Permissions
+	if rawIDToken, ok := oauth2Token.Extra("id_token").(string); ok {
+		idToken, err := o.verifier.Verify(context.Background(), rawIDToken)
 		if err != nil {
+			klog.Errorf("Failed to verify ID Token: %s", err)
 			w.WriteHeader(http.StatusInternalServerError)
-			klog.Errorf("cannot marshal token: %s", err)
 			return
 		}
 
-		w.Header().Add("Content-Type", "application/json")
-		if _, err := w.Write(data); err != nil {
+		var claims Claims
+		if err := idToken.Claims(&claims); err != nil {
+			klog.Errorf("cannot get id claims: %s", err)
 			w.WriteHeader(http.StatusInternalServerError)
-			klog.Errorf("cannot send token: %s", err)
 			return
 		}
+
+		mapped := o.mapping.Permissions(claims)
+		permissions = &mapped
+		valid = idToken.Expiry
 	}
+
+	valid, err = o.sessions.Refresh(token, valid, oauth2Token.RefreshToken, permissions)
+	if errors.Is(err, ErrSessionNotFound) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		klog.Errorf("cannot refresh session: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessionToken{Token: token, Valid: valid})
 }
 
-func (o oidcAuth) handleWithToken(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodDelete:
-		klog.Infof("receive DELETE request with token")
-		if err := o.helper.DeleteSession(r.Header.Get("token")); err != nil {
+// handleSessions lists or revokes all sessions of a user; only administrators have access to it
+func (o oidcAuth) handleSessions(w http.ResponseWriter, r *http.Request) {
+	admin, statusCode, err := o.helper.AdminAccess(r)
+	if err != nil {
+		klog.Errorf("cannot check admin access: %s", err)
+		w.WriteHeader(statusCode)
+		return
+	}
+	if !admin {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	subject := r.URL.Query().Get("subject")
+	if subject == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		count, err := o.sessions.DeleteSubject(subject)
+		if err != nil {
+			klog.Errorf("cannot revoke sessions of %s: %s", subject, err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+
+		klog.Infof("revoked %d sessions of %s", count, subject)
 		w.WriteHeader(http.StatusNoContent)
 		return
-	default:
-		klog.Infof("receive request %s with token", r.Method)
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	sessions, err := o.sessions.List(subject)
+	if err != nil {
+		klog.Errorf("cannot list sessions of %s: %s", subject, err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	writeJSON(w, http.StatusOK, sessions)
 }
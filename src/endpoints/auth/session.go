@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"k8s.io/klog"
+)
+
+// ErrSessionNotFound is returned, if a session does not exist or is expired
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session describes the rights of a session token
+type Session struct {
+	Subject        string    `json:"subject"`
+	Organisations  []string  `json:"organisations"`
+	WriteContract  bool      `json:"writeContract"`
+	DeleteContract bool      `json:"deleteContract"`
+	WriteResult    bool      `json:"writeResult"`
+	Admin          bool      `json:"admin"`
+	ReadContracts  []string  `json:"readContracts"`
+	WriteContracts []string  `json:"writeContracts"`
+	Valid          time.Time `json:"valid"`
+}
+
+// SessionStore provides the sessions, which are created by the logins
+type SessionStore interface {
+	// Get returns the session of the token
+	Get(token string) (Session, error)
+
+	// RefreshToken returns the refresh token of the user management, which is stored with the session
+	RefreshToken(token string) (string, error)
+
+	// Refresh extends the validity of the session and replaces its refresh token. The validity is limited by the
+	// maximal lifetime of the session; the limited validity is returned. If permissions is not nil, the
+	// organisations and capabilities of the session are replaced by them.
+	Refresh(token string, valid time.Time, refreshToken string, permissions *Permissions) (time.Time, error)
+
+	// List returns the valid sessions of the user
+	List(subject string) ([]Session, error)
+
+	// DeleteSubject deletes all sessions of the user and returns their count
+	DeleteSubject(subject string) (int64, error)
+}
+
+// NewSessionStore creates a store of the sessions, which uses the database. The refresh tokens are encrypted with
+// cipher and a session cannot be refreshed beyond maxLifetime after its login.
+func NewSessionStore(db *sql.DB, cipher TokenCipher, maxLifetime time.Duration) SessionStore {
+	return sessionStore{db: db, cipher: cipher, maxLifetime: maxLifetime}
+}
+
+type sessionStore struct {
+	db          *sql.DB
+	cipher      TokenCipher
+	maxLifetime time.Duration
+}
+
+// strings returns the first column of the rows of the query
+func (s sessionStore) strings(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+func (s sessionStore) Get(token string) (Session, error) {
+	var session Session
+	err := s.db.QueryRow("SELECT COALESCE(subject, ''), write_contract, delete_contract, write_result, admin, valid FROM token WHERE token = $1 AND valid >= NOW()", token).
+		Scan(&session.Subject, &session.WriteContract, &session.DeleteContract, &session.WriteResult, &session.Admin, &session.Valid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, err
+	}
+
+	if session.Organisations, err = s.strings("SELECT o.name FROM token_permission AS tp JOIN organisations o on tp.organisation = o.id WHERE tp.token = $1 ORDER BY o.name", token); err != nil {
+		return Session{}, err
+	}
+
+	if session.ReadContracts, err = s.strings("SELECT DISTINCT rp.contract FROM token_permission AS tp JOIN read_permissions rp on tp.organisation = rp.organisation WHERE tp.token = $1 ORDER BY rp.contract", token); err != nil {
+		return Session{}, err
+	}
+
+	if session.WriteContracts, err = s.strings("SELECT DISTINCT wp.contract FROM token_permission AS tp JOIN write_permissions wp on tp.organisation = wp.organisation WHERE tp.token = $1 ORDER BY wp.contract", token); err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+func (s sessionStore) RefreshToken(token string) (string, error) {
+	var encrypted []byte
+	err := s.db.QueryRow("SELECT encrypted_refresh_token FROM token WHERE token = $1 AND valid >= NOW()", token).Scan(&encrypted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrSessionNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	refreshToken, err := s.cipher.Decrypt(encrypted)
+	if errors.Is(err, ErrInvalidCiphertext) {
+		// the key has been replaced, the session can only be renewed by a new login
+		klog.Infof("refresh token of the session cannot be decrypted")
+		return "", nil
+	}
+	return refreshToken, err
+}
+
+func (s sessionStore) Refresh(token string, valid time.Time, refreshToken string, permissions *Permissions) (time.Time, error) {
+	encrypted, err := s.cipher.Encrypt(refreshToken)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	limited, err := s.refresh(tx, token, valid, encrypted, permissions)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			klog.Errorf("cannot rollback transaction: %s", rbErr)
+		}
+		return time.Time{}, err
+	}
+
+	return limited, tx.Commit()
+}
+
+func (s sessionStore) refresh(tx *sql.Tx, token string, valid time.Time, encrypted []byte, permissions *Permissions) (time.Time, error) {
+	var limited time.Time
+	err := tx.QueryRow("UPDATE token SET valid = LEAST($2, created + make_interval(secs => $3)), encrypted_refresh_token = $4 WHERE token = $1 AND created + make_interval(secs => $3) > NOW() RETURNING valid",
+		token, valid, s.maxLifetime.Seconds(), encrypted).
+		Scan(&limited)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if permissions == nil {
+		return limited, nil
+	}
+
+	if _, err := tx.Exec("UPDATE token SET write_contract = $2, delete_contract = $3, write_result = $4, admin = $5 WHERE token = $1",
+		token, permissions.ContractCreate, permissions.ContractDelete, permissions.ResultWrite, permissions.Admin); err != nil {
+		return time.Time{}, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM token_permission WHERE token = $1", token); err != nil {
+		return time.Time{}, err
+	}
+
+	if _, err := tx.Exec("INSERT INTO token_permission (token, organisation) SELECT $1, id FROM organisations WHERE name = ANY($2)", token, pq.Array(permissions.Organisations)); err != nil {
+		return time.Time{}, err
+	}
+
+	return limited, nil
+}
+
+func (s sessionStore) List(subject string) ([]Session, error) {
+	tokens, err := s.strings("SELECT token FROM token WHERE subject = $1 AND valid >= NOW() ORDER BY valid", subject)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	for _, token := range tokens {
+		session, err := s.Get(token)
+		if errors.Is(err, ErrSessionNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+func (s sessionStore) DeleteSubject(subject string) (int64, error) {
+	res, err := s.db.Exec("DELETE FROM token WHERE subject = $1", subject)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
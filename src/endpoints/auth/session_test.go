@@ -0,0 +1,446 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	dbMock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+)
+
+// testSessionStore stores the sessions and their refresh tokens in memory
+type testSessionStore struct {
+	sessions      map[string]Session
+	refreshTokens map[string]string
+}
+
+// testKeySet accepts every signature of an id token
+type testKeySet struct{}
+
+func (testKeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed jwt")
+	}
+	return base64.RawURLEncoding.DecodeString(parts[1])
+}
+
+// testIDToken returns an unsigned id token with the claims
+func testIDToken(t *testing.T, claims map[string]interface{}) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("cannot marshal claims: %s", err)
+	}
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString([]byte("signature"))
+}
+
+func (s *testSessionStore) Get(token string) (Session, error) {
+	session, ok := s.sessions[token]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *testSessionStore) RefreshToken(token string) (string, error) {
+	if _, ok := s.sessions[token]; !ok {
+		return "", ErrSessionNotFound
+	}
+	return s.refreshTokens[token], nil
+}
+
+func (s *testSessionStore) Refresh(token string, valid time.Time, refreshToken string, permissions *Permissions) (time.Time, error) {
+	session, ok := s.sessions[token]
+	if !ok {
+		return time.Time{}, ErrSessionNotFound
+	}
+	session.Valid = valid
+	if permissions != nil {
+		session.Organisations = permissions.Organisations
+		session.WriteContract = permissions.ContractCreate
+		session.DeleteContract = permissions.ContractDelete
+		session.WriteResult = permissions.ResultWrite
+		session.Admin = permissions.Admin
+	}
+	s.sessions[token] = session
+	s.refreshTokens[token] = refreshToken
+	return valid, nil
+}
+
+func (s *testSessionStore) List(subject string) ([]Session, error) {
+	sessions := []Session{}
+	for _, session := range s.sessions {
+		if session.Subject == subject {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *testSessionStore) DeleteSubject(subject string) (int64, error) {
+	var count int64
+	for token, session := range s.sessions {
+		if session.Subject == subject {
+			delete(s.sessions, token)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func newTestOidcAuth(store *testSessionStore, admin bool) oidcAuth {
+	return oidcAuth{
+		sessions:      store,
+		helper:        testSessionHelper{admin: admin},
+		regexBase:     pathRegexp("auth", ""),
+		regexMe:       pathRegexp("auth", "/me"),
+		regexRefresh:  pathRegexp("auth", "/refresh"),
+		regexSessions: pathRegexp("auth", "/sessions"),
+	}
+}
+
+func TestOidcAuth_handleMe(t *testing.T) {
+	valid := time.Date(2020, 9, 23, 10, 0, 0, 0, time.UTC)
+	session := Session{Subject: "user", Organisations: []string{"org"}, WriteContract: true, ReadContracts: []string{"c1"}, WriteContracts: []string{}, Valid: valid}
+	store := &testSessionStore{sessions: map[string]Session{"token": session}}
+	o := newTestOidcAuth(store, false)
+
+	testTable := []struct {
+		description string
+		token       string
+		statusCode  int
+	}{
+		{"valid session", "token", http.StatusOK},
+		{"unknown session", "unknown", http.StatusUnauthorized},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+			r.Header.Set("token", v.token)
+
+			w := httptest.NewRecorder()
+			o.ServeHTTP(w, r)
+
+			if w.Code != v.statusCode {
+				t.Fatalf("expected status code %d, got %d", v.statusCode, w.Code)
+			}
+			if v.statusCode != http.StatusOK {
+				return
+			}
+
+			var got Session
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("cannot unmarshal session: %s", err)
+			}
+			if !reflect.DeepEqual(got, session) {
+				t.Errorf("expected %v, got %v", session, got)
+			}
+		})
+	}
+}
+
+func TestOidcAuth_handleRefresh(t *testing.T) {
+	var idToken string
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Form.Get("refresh_token") {
+		case "refresh":
+			fmt.Fprint(w, `{"access_token":"access","token_type":"bearer","refresh_token":"refresh2","expires_in":3600}`)
+		case "claims":
+			fmt.Fprintf(w, `{"access_token":"access","token_type":"bearer","refresh_token":"refresh2","expires_in":3600,"id_token":%q}`, idToken)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer idp.Close()
+
+	valid := time.Now().Add(time.Minute)
+	idToken = testIDToken(t, map[string]interface{}{
+		"iss":    idp.URL,
+		"aud":    "client",
+		"sub":    "user",
+		"exp":    valid.Add(time.Hour).Unix(),
+		"groups": []string{"other"},
+		"roles":  []string{"admin"},
+	})
+
+	store := &testSessionStore{
+		sessions: map[string]Session{
+			"token":     {Valid: valid},
+			"claims":    {Valid: valid, Organisations: []string{"org"}, WriteContract: true},
+			"noRefresh": {Valid: valid},
+			"revoked":   {Valid: valid},
+		},
+		refreshTokens: map[string]string{"token": "refresh", "claims": "claims", "revoked": "revoked"},
+	}
+	o := newTestOidcAuth(store, false)
+	o.config = oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{TokenURL: idp.URL}}
+	o.verifier = oidc.NewVerifier(idp.URL, testKeySet{}, &oidc.Config{ClientID: "client"})
+	o.mapping = DefaultClaimMapping()
+
+	testTable := []struct {
+		description string
+		token       string
+		statusCode  int
+	}{
+		{"refresh", "token", http.StatusOK},
+		{"without refresh token", "noRefresh", http.StatusConflict},
+		{"refresh token rejected", "revoked", http.StatusUnauthorized},
+		{"unknown session", "unknown", http.StatusUnauthorized},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+			r.Header.Set("token", v.token)
+
+			w := httptest.NewRecorder()
+			o.ServeHTTP(w, r)
+
+			if w.Code != v.statusCode {
+				t.Fatalf("expected status code %d, got %d", v.statusCode, w.Code)
+			}
+			if v.statusCode != http.StatusOK {
+				return
+			}
+
+			if !store.sessions[v.token].Valid.After(valid) || store.refreshTokens[v.token] != "refresh2" {
+				t.Errorf("session is not refreshed: %v, refresh token %s", store.sessions[v.token], store.refreshTokens[v.token])
+			}
+		})
+	}
+
+	// the permissions of the session are mapped from the claims of the refreshed id token
+	t.Run("refresh with id token", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+		r.Header.Set("token", "claims")
+
+		w := httptest.NewRecorder()
+		o.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+
+		session := store.sessions["claims"]
+		if !reflect.DeepEqual(session.Organisations, []string{"other"}) || session.WriteContract || !session.Admin || !session.WriteResult {
+			t.Errorf("permissions of the session are not mapped again: %+v", session)
+		}
+	})
+}
+
+func TestOidcAuth_handleSessions(t *testing.T) {
+	testTable := []struct {
+		description string
+		method      string
+		target      string
+		admin       bool
+		statusCode  int
+		remaining   int
+	}{
+		{"list sessions", http.MethodGet, "/auth/sessions?subject=user", true, http.StatusOK, 3},
+		{"revoke sessions", http.MethodDelete, "/auth/sessions?subject=user", true, http.StatusNoContent, 1},
+		{"without subject", http.MethodDelete, "/auth/sessions", true, http.StatusBadRequest, 3},
+		{"no admin", http.MethodDelete, "/auth/sessions?subject=user", false, http.StatusUnauthorized, 3},
+		{"method not allowed", http.MethodPut, "/auth/sessions?subject=user", true, http.StatusMethodNotAllowed, 3},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			store := &testSessionStore{sessions: map[string]Session{
+				"t1": {Subject: "user"},
+				"t2": {Subject: "user"},
+				"t3": {Subject: "other"},
+			}}
+			o := newTestOidcAuth(store, v.admin)
+
+			r := httptest.NewRequest(v.method, v.target, nil)
+			r.Header.Set("token", "admin")
+
+			w := httptest.NewRecorder()
+			o.ServeHTTP(w, r)
+
+			if w.Code != v.statusCode {
+				t.Fatalf("expected status code %d, got %d", v.statusCode, w.Code)
+			}
+			if len(store.sessions) != v.remaining {
+				t.Errorf("expected %d remaining sessions, got %d", v.remaining, len(store.sessions))
+			}
+
+			if v.statusCode == http.StatusOK {
+				var sessions []Session
+				if err := json.Unmarshal(w.Body.Bytes(), &sessions); err != nil {
+					t.Fatalf("cannot unmarshal sessions: %s", err)
+				}
+				if len(sessions) != 2 {
+					t.Errorf("expected 2 sessions, got %d", len(sessions))
+				}
+			}
+		})
+	}
+}
+
+func TestSessionStore_Get(t *testing.T) {
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mock: %s", err)
+	}
+	defer db.Close()
+
+	valid := time.Date(2020, 9, 23, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT COALESCE(subject, ''), write_contract, delete_contract, write_result, admin, valid FROM token WHERE token = $1 AND valid >= NOW()").WithArgs("token").
+		WillReturnRows(dbMock.NewRows([]string{"subject", "write_contract", "delete_contract", "write_result", "admin", "valid"}).AddRow("user", true, false, true, false, valid))
+	mock.ExpectQuery("SELECT o.name FROM token_permission AS tp JOIN organisations o on tp.organisation = o.id WHERE tp.token = $1 ORDER BY o.name").WithArgs("token").
+		WillReturnRows(dbMock.NewRows([]string{"name"}).AddRow("org"))
+	mock.ExpectQuery("SELECT DISTINCT rp.contract FROM token_permission AS tp JOIN read_permissions rp on tp.organisation = rp.organisation WHERE tp.token = $1 ORDER BY rp.contract").WithArgs("token").
+		WillReturnRows(dbMock.NewRows([]string{"contract"}).AddRow("c1").AddRow("c2"))
+	mock.ExpectQuery("SELECT DISTINCT wp.contract FROM token_permission AS tp JOIN write_permissions wp on tp.organisation = wp.organisation WHERE tp.token = $1 ORDER BY wp.contract").WithArgs("token").
+		WillReturnRows(dbMock.NewRows([]string{"contract"}).AddRow("c1"))
+
+	session, err := NewSessionStore(db, discardCipher{}, time.Hour).Get("token")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := Session{Subject: "user", Organisations: []string{"org"}, WriteContract: true, WriteResult: true, ReadContracts: []string{"c1", "c2"}, WriteContracts: []string{"c1"}, Valid: valid}
+	if !reflect.DeepEqual(session, expected) {
+		t.Errorf("expected %v, got %v", expected, session)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("not all expectations were met: %s", err)
+	}
+}
+
+func TestSessionStore_DeleteSubject(t *testing.T) {
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mock: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("DELETE FROM token WHERE subject = $1").WithArgs("user").WillReturnResult(dbMock.NewResult(0, 2))
+
+	count, err := NewSessionStore(db, discardCipher{}, time.Hour).DeleteSubject("user")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 deleted sessions, got %d", count)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("not all expectations were met: %s", err)
+	}
+}
+
+func TestSessionStore_Refresh(t *testing.T) {
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mock: %s", err)
+	}
+	defer db.Close()
+
+	tokenCipher, err := NewTokenCipher(testSessionKey)
+	if err != nil {
+		t.Fatalf("cannot create cipher: %s", err)
+	}
+
+	valid := time.Date(2020, 9, 23, 10, 0, 0, 0, time.UTC)
+	limited := time.Date(2020, 9, 23, 9, 0, 0, 0, time.UTC)
+	query := "UPDATE token SET valid = LEAST($2, created + make_interval(secs => $3)), encrypted_refresh_token = $4 WHERE token = $1 AND created + make_interval(secs => $3) > NOW() RETURNING valid"
+
+	// the validity is limited by the lifetime of the session and the refresh token is encrypted
+	mock.ExpectBegin()
+	mock.ExpectQuery(query).WithArgs("token", valid, float64(86400), encryptedArg{cipher: tokenCipher, token: "refresh"}).
+		WillReturnRows(dbMock.NewRows([]string{"valid"}).AddRow(limited))
+	mock.ExpectCommit()
+	// the permissions are replaced
+	mock.ExpectBegin()
+	mock.ExpectQuery(query).WithArgs("token", valid, float64(86400), encryptedArg{cipher: tokenCipher, token: "refresh"}).
+		WillReturnRows(dbMock.NewRows([]string{"valid"}).AddRow(limited))
+	mock.ExpectExec("UPDATE token SET write_contract = $2, delete_contract = $3, write_result = $4, admin = $5 WHERE token = $1").
+		WithArgs("token", false, false, true, true).WillReturnResult(dbMock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM token_permission WHERE token = $1").WithArgs("token").WillReturnResult(dbMock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO token_permission (token, organisation) SELECT $1, id FROM organisations WHERE name = ANY($2)").
+		WithArgs("token", "{\"org\"}").WillReturnResult(dbMock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// the lifetime of the session is exceeded
+	mock.ExpectBegin()
+	mock.ExpectQuery(query).WithArgs("expired", valid, float64(86400), encryptedArg{cipher: tokenCipher, token: "refresh"}).
+		WillReturnRows(dbMock.NewRows([]string{"valid"}))
+	mock.ExpectRollback()
+
+	store := NewSessionStore(db, tokenCipher, 24*time.Hour)
+	got, err := store.Refresh("token", valid, "refresh", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !got.Equal(limited) {
+		t.Errorf("expected validity %s, got %s", limited, got)
+	}
+
+	permissions := Permissions{Organisations: []string{"org"}, ResultWrite: true, Admin: true}
+	if _, err := store.Refresh("token", valid, "refresh", &permissions); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := store.Refresh("expired", valid, "refresh", nil); err != ErrSessionNotFound {
+		t.Errorf("expected %s, got %v", ErrSessionNotFound, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("not all expectations were met: %s", err)
+	}
+}
+
+func TestSessionStore_RefreshToken(t *testing.T) {
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mock: %s", err)
+	}
+	defer db.Close()
+
+	tokenCipher, err := NewTokenCipher(testSessionKey)
+	if err != nil {
+		t.Fatalf("cannot create cipher: %s", err)
+	}
+
+	encrypted, err := tokenCipher.Encrypt("refresh")
+	if err != nil {
+		t.Fatalf("cannot encrypt: %s", err)
+	}
+
+	query := "SELECT encrypted_refresh_token FROM token WHERE token = $1 AND valid >= NOW()"
+	mock.ExpectQuery(query).WithArgs("token").WillReturnRows(dbMock.NewRows([]string{"encrypted_refresh_token"}).AddRow(encrypted))
+	// a refresh token, which cannot be decrypted, is handled like a missing refresh token
+	mock.ExpectQuery(query).WithArgs("other").WillReturnRows(dbMock.NewRows([]string{"encrypted_refresh_token"}).AddRow([]byte("plaintext refresh token")))
+
+	store := NewSessionStore(db, tokenCipher, time.Hour)
+	if token, err := store.RefreshToken("token"); err != nil || token != "refresh" {
+		t.Errorf("expected refresh, got %s (%v)", token, err)
+	}
+
+	if token, err := store.RefreshToken("other"); err != nil || token != "" {
+		t.Errorf("expected no refresh token, got %s (%v)", token, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("not all expectations were met: %s", err)
+	}
+}
@@ -1,6 +1,8 @@
 package contract
 
 import (
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -8,6 +10,8 @@ import (
 	"k8s.io/klog"
 
 	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/auth"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
 )
 
 type Contract interface {
@@ -33,24 +37,38 @@ func (c contract) handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	// query all contracts
 	case 2:
-		cAuth, err := c.auth.TokenValid(r)
+		scope, statusCode, err := c.auth.ReadScope(r)
 		if err != nil {
-			klog.Errorf("cannot check token validation: %split", err)
+			klog.Errorf("cannot get the read scope: %s", err)
+			w.WriteHeader(statusCode)
+			return
 		}
 
-		if !cAuth {
-			w.WriteHeader(http.StatusBadRequest)
-			klog.Errorf("check validation returned false")
+		if statusCode != 0 {
+			w.WriteHeader(statusCode)
 			return
 		}
 
-		contracts, err := c.contract.GetAllContracts(r.Header.Get("token"))
+		contracts, next, err := c.contract.GetAllContracts(models.ReadScope{
+			Organisations: scope.Organisations,
+			Contracts:     scope.Contracts,
+			Machines:      scope.Machines,
+		}, r.URL.Query())
+		if errors.Is(err, models.ErrInvalidFilter) || errors.Is(err, pagination.ErrInvalidPage) {
+			klog.Infof("invalid contract filter: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			klog.Errorf("could not query all contracts: %split\n", err)
 			w.WriteHeader(500)
 			return
 		}
 
+		if next != "" {
+			w.Header().Set(pagination.NextCursorHeader, next)
+		}
+
 		if len(contracts) == 0 {
 			return
 		}
@@ -76,7 +94,17 @@ func (c contract) handleGet(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		data, err := c.contract.GetContract(contractId)
+		var data []byte
+		if version := r.URL.Query().Get("version"); version != "" {
+			data, err = c.contract.GetContractVersion(contractId, version)
+		} else {
+			data, err = c.contract.GetContract(contractId)
+		}
+		if errors.Is(err, models.ErrContractNotFound) {
+			klog.Infof("could not find contract %s: %s", contractId, err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			klog.Errorf("could not receive contract: %split\n", err)
 			w.WriteHeader(500)
@@ -87,11 +115,48 @@ func (c contract) handleGet(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(500)
 			return
 		}
+	// query the history or the removals of a specific contract
+	case 4:
+		contractId := split[2]
+		if split[3] != "history" && split[3] != "removal" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		valid, statusCode, err := c.auth.IsAuthenticated(r, contractId, false)
+		if err != nil {
+			w.WriteHeader(statusCode)
+			klog.Errorf("cannot check authentication: %s", err)
+			return
+		}
+
+		if !valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			klog.Errorf("authentication is not valid")
+			return
+		}
+
+		var data []byte
+		if split[3] == "history" {
+			data, err = c.contract.GetContractHistory(contractId)
+		} else {
+			data, err = c.contract.GetContractRemovals(contractId)
+		}
+		if err != nil {
+			klog.Errorf("could not receive contract %s: %s\n", split[3], err)
+			w.WriteHeader(500)
+			return
+		}
+		if _, err := w.Write(data); err != nil {
+			klog.Errorf("could not return result: %v\n", err)
+			w.WriteHeader(500)
+			return
+		}
 	}
 }
 
 func (c contract) handleDelete(w http.ResponseWriter, r *http.Request) {
-	hasRight, responseCode, err := c.auth.ContractWriteAccess(r)
+	hasRight, responseCode, err := c.auth.ContractDeleteAccess(r)
 	if err != nil {
 		w.WriteHeader(responseCode)
 		return
@@ -101,21 +166,40 @@ func (c contract) handleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	split := strings.Split(r.URL.Path, "/")
+	organisations, responseCode, err := c.auth.Organisations(r)
+	if err != nil {
+		klog.Errorf("cannot get the organisations of the request: %s", err)
+		w.WriteHeader(responseCode)
+		return
+	}
+	if responseCode != 0 {
+		w.WriteHeader(responseCode)
+		return
+	}
+
+	split := strings.Split(strings.TrimRight(r.URL.Path, "/"), "/")
 	// test if the correct count of parameters has been transmitted
 	if len(split) != 3 {
 		klog.Infof("wrong count of parameters")
 		w.WriteHeader(400)
 		return
 	}
-	// delete contract
-	if err := c.contract.DeleteContract(split[2]); err != nil {
-		klog.Errorf("could not update contract: %s", err)
+
+	// read the contract removal from body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		klog.Errorf("could not read data from request: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	// delete contract
+	state, err := c.contract.DeleteContract(split[2], organisations, body)
+	if err != nil {
+		klog.Errorf("could not delete contract: %s", err)
+	}
+
+	w.WriteHeader(state)
 }
 
 func (c contract) handlePost(w http.ResponseWriter, r *http.Request) {
@@ -143,8 +227,84 @@ func (c contract) handlePost(w http.ResponseWriter, r *http.Request) {
 		klog.Errorf("could not insert data into db: %s\n", err)
 	}
 
-	w.WriteHeader(state)
+	writeState(w, state, err)
+
+}
+
+func (c contract) handlePut(w http.ResponseWriter, r *http.Request) {
+	hasRight, responseCode, err := c.auth.ContractWriteAccess(r)
+	if err != nil {
+		w.WriteHeader(responseCode)
+		return
+	}
+	if !hasRight {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	split := strings.Split(strings.TrimRight(r.URL.Path, "/"), "/")
+	// test if the correct count of parameters has been transmitted
+	if len(split) != 3 {
+		klog.Infof("wrong count of parameters")
+		w.WriteHeader(400)
+		return
+	}
+
+	// only the organisations, which are permitted to write to the contract, can store a new version of it
+	writeAccess, responseCode, err := c.auth.IsAuthenticated(r, split[2], true)
+	if err != nil {
+		klog.Errorf("cannot check authentication: %s", err)
+		w.WriteHeader(responseCode)
+		return
+	}
+	if !writeAccess {
+		klog.Infof("the user is not permitted to write to contract %s", split[2])
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// read data from body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		klog.Errorf("could not read data from request: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	state, err := c.contract.UpdateContract(split[2], body)
+	if err != nil {
+		klog.Errorf("could not update contract: %s\n", err)
+	}
 
+	writeState(w, state, err)
+}
+
+// errorResponse describes, why a request failed
+type errorResponse struct {
+	Error   string                  `json:"error"`
+	Details models.ValidationErrors `json:"details,omitempty"`
+}
+
+// writeState writes the status code; if the contract is not valid, the validation errors are returned
+func writeState(w http.ResponseWriter, state int, err error) {
+	var validation models.ValidationErrors
+	if !errors.As(err, &validation) {
+		w.WriteHeader(state)
+		return
+	}
+
+	data, err := json.Marshal(errorResponse{Error: "the contract is not valid", Details: validation})
+	if err != nil {
+		klog.Errorf("cannot marshal validation errors: %s", err)
+		w.WriteHeader(state)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(state)
+	if _, err := w.Write(data); err != nil {
+		klog.Errorf("cannot send validation errors: %s", err)
+	}
 }
 
 func (c contract) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -158,6 +318,9 @@ func (c contract) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// handle post request
 	case "POST":
 		c.handlePost(w, r)
+	// handle put request, which creates a new version of a contract
+	case "PUT":
+		c.handlePut(w, r)
 	// handle all other http method requests
 	default:
 		w.WriteHeader(405)
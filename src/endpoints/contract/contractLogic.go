@@ -2,31 +2,53 @@ package contract
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"k8s.io/klog"
 
 	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/signature"
 )
 
+// ErrBlameNotPermitted is returned, if the organisation, which is blamed for a contract removal, does not act
+// through the request or is neither partner of the contract nor permitted to write to the contract
+var ErrBlameNotPermitted = errors.New("the blamed organisation is not permitted to remove the contract")
+
 type Logic interface {
-	// GetAllContracts
-	GetAllContracts(string) ([]byte, error)
+	// GetAllContracts returns a page of the readable contracts and the cursor of the next page
+	GetAllContracts(models.ReadScope, map[string][]string) ([]byte, string, error)
 
 	// GetContract
 	GetContract(string) ([]byte, error)
 
-	// DeleteContract
-	DeleteContract(string) error
+	// DeleteContract removes the contract on behalf of one of the organisations of the request
+	DeleteContract(string, []string, []byte) (int, error)
+
+	// GetContractRemovals
+	GetContractRemovals(string) ([]byte, error)
 
 	// InsertContract
 	InsertContract([]byte) (int, error)
+
+	// UpdateContract
+	UpdateContract(string, []byte) (int, error)
+
+	// GetContractVersion
+	GetContractVersion(string, string) ([]byte, error)
+
+	// GetContractHistory
+	GetContractHistory(string) ([]byte, error)
 }
 
 type logic struct {
 	resultList models.ResultList
 	handler    models.ContractHandler
 	system     string
+	verifier   signature.Verifier
+	events     EventPublisher
 }
 
 func (c logic) GetContract(contract string) ([]byte, error) {
@@ -39,38 +61,209 @@ func (c logic) GetContract(contract string) ([]byte, error) {
 	return json.Marshal(con)
 }
 
-func (c logic) DeleteContract(contract string) error {
-	return c.handler.DeleteContract(contract)
+func (c logic) DeleteContract(contract string, organisations []string, bytes []byte) (int, error) {
+	var removal models.ContractRemoval
+	if err := json.Unmarshal(bytes, &removal); err != nil {
+		klog.Infof("contract removal cannot be parsed: %s, received data: %s", err, string(bytes))
+		return http.StatusBadRequest, err
+	}
+
+	if !removal.Valid(contract) {
+		klog.Infof("contract removal is not valid")
+		return http.StatusBadRequest, nil
+	}
+
+	con, err := c.handler.GetContract(contract)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if con.Body.Contract.ID == "" {
+		return http.StatusNotFound, models.ErrContractNotFound
+	}
+
+	if !containsString(organisations, removal.Blame) {
+		klog.Infof("organisation %s of the removal does not act through the request", removal.Blame)
+		return http.StatusForbidden, ErrBlameNotPermitted
+	}
+	if !containsString(con.Body.Contract.Partners, removal.Blame) && !containsString(con.Body.Contract.Permissions.Write, removal.Blame) {
+		klog.Infof("organisation %s is neither partner of contract %s nor permitted to write to it", removal.Blame, contract)
+		return http.StatusForbidden, ErrBlameNotPermitted
+	}
+
+	// every removal has to be signed by the blamed organisation, independent of the signature validation
+	// of the messages of the contract
+	err = c.verifier.Verify([]string{removal.Blame}, removal.SignedBody(), signature.Signature{Value: removal.Signature})
+	if errors.Is(err, signature.ErrInvalidSignature) {
+		return http.StatusForbidden, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	err = c.handler.DeleteContract(removal, hook(c.events, EventDeactivated))
+	if errors.Is(err, models.ErrContractNotFound) {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusNoContent, nil
+}
+
+func (c logic) GetContractRemovals(contract string) ([]byte, error) {
+	removals, err := c.handler.GetContractRemovals(contract)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(removals)
 }
 
 func (c logic) InsertContract(bytes []byte) (int, error) {
 	var contract models.Contract
 	if err := json.Unmarshal(bytes, &contract); err != nil {
 		klog.Infof("contract cannot be parsed: %s, received data: %s", err, string(bytes))
-		return http.StatusBadRequest, err
+		return http.StatusBadRequest, models.ValidationErrors{{Path: "", Message: err.Error()}}
+	}
+	// the signature covers the uploaded body and not the parsed one
+	rawBody, err := signature.TransmittedBody(bytes)
+	if err != nil {
+		return http.StatusBadRequest, models.ValidationErrors{{Path: "/body", Message: err.Error()}}
 	}
+	contract.RawBody = rawBody
 
-	if !contract.Valid(c.system) {
-		klog.Infof("contract is not valid")
-		return http.StatusBadRequest, nil
+	if errs := contract.Validate(c.system); len(errs) > 0 {
+		klog.Infof("contract is not valid: %s", errs)
+		return http.StatusBadRequest, errs
 	}
 
-	if err := c.handler.InsertContract(contract); err != nil {
+	if state, err := c.verifyContract(contract); err != nil {
+		return state, err
+	}
+
+	if err := c.handler.InsertContract(contract, hook(c.events, EventCreated)); err != nil {
 		return http.StatusInternalServerError, err
 	}
 
 	return http.StatusCreated, nil
 }
 
-func (c logic) GetAllContracts(token string) ([]byte, error) {
-	ids, err := c.resultList.GetAllContracts(token)
+func (c logic) UpdateContract(id string, bytes []byte) (int, error) {
+	var contract models.Contract
+	if err := json.Unmarshal(bytes, &contract); err != nil {
+		klog.Infof("contract cannot be parsed: %s, received data: %s", err, string(bytes))
+		return http.StatusBadRequest, models.ValidationErrors{{Path: "", Message: err.Error()}}
+	}
+	// the signature covers the uploaded body and not the parsed one
+	rawBody, err := signature.TransmittedBody(bytes)
+	if err != nil {
+		return http.StatusBadRequest, models.ValidationErrors{{Path: "/body", Message: err.Error()}}
+	}
+	contract.RawBody = rawBody
+
+	if contract.Body.Contract.ID != id {
+		klog.Infof("contract id %s does not match the id %s in the url", contract.Body.Contract.ID, id)
+		return http.StatusBadRequest, models.ValidationErrors{{
+			Path:    "/body/contract/id",
+			Message: fmt.Sprintf("contract id %s does not match the id %s in the path", contract.Body.Contract.ID, id),
+		}}
+	}
+
+	if errs := contract.Validate(c.system); len(errs) > 0 {
+		klog.Infof("contract is not valid: %s", errs)
+		return http.StatusBadRequest, errs
+	}
+
+	if state, err := c.verifyContract(contract); err != nil {
+		return state, err
+	}
+
+	err = c.handler.UpdateContract(contract, hook(c.events, EventUpdated))
+	switch {
+	case errors.Is(err, models.ErrContractNotFound):
+		return http.StatusNotFound, err
+	case errors.Is(err, models.ErrContractInactive), errors.Is(err, models.ErrVersionExists):
+		return http.StatusConflict, err
+	case err != nil:
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+func (c logic) GetContractVersion(contract, version string) ([]byte, error) {
+	con, err := c.handler.GetContractVersion(contract, version)
 	if err != nil {
 		return nil, err
 	}
 
-	return json.Marshal(ids)
+	return json.Marshal(con)
+}
+
+func (c logic) GetContractHistory(contract string) ([]byte, error) {
+	history, err := c.handler.GetContractHistory(contract)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(history)
+}
+
+// verifyContract verifies the signature of the contract body, if the contract requires
+// the validation of signatures. The contract has to be signed by one of the partners.
+func (c logic) verifyContract(contract models.Contract) (int, error) {
+	if !contract.Body.CheckSignature {
+		return 0, nil
+	}
+
+	sig := signature.Signature{
+		Value:        contract.Signature.Signature,
+		Algorithm:    contract.Signature.Meta.Algorithm,
+		SerialNumber: contract.Signature.Meta.SerialNumber,
+	}
+
+	err := c.verifier.Verify(contract.Body.Contract.Partners, contract.SignedBody(), sig)
+	if errors.Is(err, signature.ErrInvalidSignature) {
+		return http.StatusForbidden, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
+
+func (c logic) GetAllContracts(scope models.ReadScope, queryParams map[string][]string) ([]byte, string, error) {
+	page, queryParams, err := pagination.Parse(queryParams)
+	if err != nil {
+		return nil, "", err
+	}
+
+	filter, err := models.ParseContractFilter(queryParams)
+	if err != nil {
+		return nil, "", err
+	}
+
+	contracts, next, err := c.resultList.GetAllContracts(scope, filter, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := json.Marshal(contracts)
+	return data, next, err
+}
+
+// containsString returns if the value is part of the values
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
-func NewContractLogic(list models.ResultList, handler models.ContractHandler, system string) Logic {
-	return logic{resultList: list, handler: handler, system: system}
+func NewContractLogic(list models.ResultList, handler models.ContractHandler, system string, verifier signature.Verifier, events EventPublisher) Logic {
+	return logic{resultList: list, handler: handler, system: system, verifier: verifier, events: events}
 }
@@ -0,0 +1,125 @@
+package contract
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/auth"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+)
+
+// testAuthHelper grants the creation of contracts and the write permission on the contracts in write
+type testAuthHelper struct {
+	write map[string]bool
+}
+
+func (h testAuthHelper) IsAuthenticated(r *http.Request, contract string, write bool) (bool, int, error) {
+	return !write || h.write[contract], 0, nil
+}
+
+func (testAuthHelper) CreateSession(string, auth.Permissions, time.Time, string, string) error {
+	panic("implement me")
+}
+
+func (testAuthHelper) DeleteSession(string) error {
+	panic("implement me")
+}
+
+func (testAuthHelper) CleanUp() {
+	panic("implement me")
+}
+
+func (testAuthHelper) TokenValid(r *http.Request) (bool, error) {
+	return true, nil
+}
+
+func (testAuthHelper) ContractWriteAccess(r *http.Request) (bool, int, error) {
+	return true, 0, nil
+}
+
+func (testAuthHelper) ContractDeleteAccess(r *http.Request) (bool, int, error) {
+	return true, 0, nil
+}
+
+func (testAuthHelper) AdminAccess(r *http.Request) (bool, int, error) {
+	return false, 0, nil
+}
+
+func (testAuthHelper) Organisations(r *http.Request) ([]string, int, error) {
+	return []string{"org"}, 0, nil
+}
+
+func (testAuthHelper) ReadScope(r *http.Request) (auth.Scope, int, error) {
+	return auth.Scope{Organisations: []string{"org"}}, 0, nil
+}
+
+// testLogic records the updated contracts
+type testLogic struct {
+	updated []string
+}
+
+func (l *testLogic) GetAllContracts(models.ReadScope, map[string][]string) ([]byte, string, error) {
+	panic("implement me")
+}
+
+func (l *testLogic) GetContract(string) ([]byte, error) {
+	panic("implement me")
+}
+
+func (l *testLogic) DeleteContract(string, []string, []byte) (int, error) {
+	panic("implement me")
+}
+
+func (l *testLogic) GetContractRemovals(string) ([]byte, error) {
+	panic("implement me")
+}
+
+func (l *testLogic) InsertContract([]byte) (int, error) {
+	panic("implement me")
+}
+
+func (l *testLogic) UpdateContract(id string, _ []byte) (int, error) {
+	l.updated = append(l.updated, id)
+	return http.StatusOK, nil
+}
+
+func (l *testLogic) GetContractVersion(string, string) ([]byte, error) {
+	panic("implement me")
+}
+
+func (l *testLogic) GetContractHistory(string) ([]byte, error) {
+	panic("implement me")
+}
+
+func TestContract_HandlePut(t *testing.T) {
+	testTable := []struct {
+		description string
+		contract    string
+		statusCode  int
+		updated     bool
+	}{
+		{"creator with write permission", "c1", http.StatusOK, true},
+		{"creator without write permission", "c2", http.StatusForbidden, false},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			logic := &testLogic{}
+			endpoint := NewContractEndpoint(logic, testAuthHelper{write: map[string]bool{"c1": true}})
+
+			w := httptest.NewRecorder()
+			endpoint.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/contract/"+v.contract, strings.NewReader("{}")))
+
+			if w.Code != v.statusCode {
+				t.Errorf("expected status code %d, got %d", v.statusCode, w.Code)
+			}
+
+			if updated := len(logic.updated) > 0; updated != v.updated {
+				t.Errorf("expected updated %t, got %t", v.updated, updated)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package contract
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/outbox"
+)
+
+// EventType is the type of a change in the lifecycle of a contract
+type EventType string
+
+const (
+	// EventCreated is published, after a contract is inserted
+	EventCreated EventType = "created"
+	// EventUpdated is published, after a new version of a contract is stored
+	EventUpdated EventType = "updated"
+	// EventDeactivated is published, after a contract is removed
+	EventDeactivated EventType = "deactivated"
+	// EventExpired is published, after the validity window of a contract has ended
+	EventExpired EventType = "expired"
+)
+
+// Event informs a kosmos local system about a change of a contract
+type Event struct {
+	Event     EventType        `json:"event"`
+	Contract  string           `json:"contract"`
+	Version   string           `json:"version"`
+	System    string           `json:"system"`
+	Machine   string           `json:"machine"`
+	Sensors   []string         `json:"sensors"`
+	Pipelines []EventPipelines `json:"pipelines"`
+	Timestamp string           `json:"timestamp"`
+}
+
+// EventPipelines are the pipelines of the system, which analyse the sensors
+type EventPipelines struct {
+	Trigger  models.Trigger    `json:"ml-trigger"`
+	Pipeline []models.Pipeline `json:"pipeline"`
+	Sensors  []string          `json:"sensors"`
+}
+
+// EventPublisher publishes the lifecycle events of the contracts
+type EventPublisher interface {
+	// Publish queues an event for every kosmos local system of the contract. The events are queued
+	// inside the transaction, which changes the contract, so that they are stored together with the change.
+	Publish(tx outbox.Executor, event EventType, contract models.Contract) error
+}
+
+// NewEventPublisher creates a publisher, which queues the events on the topic; the topic template can
+// contain the placeholders {system}, {contract} and {event}
+func NewEventPublisher(topic mqtt.Topic) EventPublisher {
+	return eventPublisher{messages: outbox.In, topic: topic, now: time.Now}
+}
+
+type eventPublisher struct {
+	messages func(tx outbox.Executor) outbox.Outbox
+	topic    mqtt.Topic
+	now      func() time.Time
+}
+
+// hook returns the hook, which queues the event of a changed contract inside the transaction of the change
+func hook(events EventPublisher, event EventType) models.ChangeHook {
+	return func(tx *sql.Tx, contract models.Contract) error {
+		return events.Publish(tx, event, contract)
+	}
+}
+
+// newEvent creates the event of the contract for the system, which contains only the pipelines of the system
+func newEvent(event EventType, contract models.Contract, system string, now time.Time) Event {
+	body := contract.Body
+	e := Event{
+		Event:     event,
+		Contract:  body.Contract.ID,
+		Version:   body.Contract.Version,
+		System:    system,
+		Machine:   body.Machine,
+		Sensors:   []string{},
+		Pipelines: []EventPipelines{},
+		Timestamp: now.UTC().Format(time.RFC3339),
+	}
+
+	for _, sensor := range body.Sensors {
+		e.Sensors = append(e.Sensors, sensor.Name)
+	}
+
+	for _, analysisSystem := range body.Analysis.Systems {
+		if analysisSystem.Name != system || !body.Analysis.Enable || !analysisSystem.Enable {
+			continue
+		}
+
+		for _, pipelines := range analysisSystem.Pipelines {
+			e.Pipelines = append(e.Pipelines, EventPipelines{
+				Trigger:  pipelines.Trigger,
+				Pipeline: pipelines.Pipeline,
+				Sensors:  pipelines.Sensors,
+			})
+		}
+	}
+
+	return e
+}
+
+func (p eventPublisher) Publish(tx outbox.Executor, event EventType, contract models.Contract) error {
+	messages := p.messages(tx)
+	now := p.now()
+	for _, system := range contract.Body.KosmosLocalSystems {
+		data, err := json.Marshal(newEvent(event, contract, system, now))
+		if err != nil {
+			return err
+		}
+
+		msg, err := p.topic.Msg(data, map[string]string{
+			"system":   system,
+			"contract": contract.Body.Contract.ID,
+			"event":    string(event),
+		})
+		if err != nil {
+			return fmt.Errorf("cannot create %s event of contract %s for system %s: %w", event, contract.Body.Contract.ID, system, err)
+		}
+
+		if err := messages.Enqueue(msg); err != nil {
+			return fmt.Errorf("cannot queue %s event of contract %s for system %s: %s", event, contract.Body.Contract.ID, system, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/outbox"
+)
+
+// testOutbox records the queued messages and fails, if err is set
+type testOutbox struct {
+	messages []mqtt.Msg
+	err      error
+}
+
+func (o *testOutbox) Enqueue(msg mqtt.Msg) error {
+	if o.err != nil {
+		return o.err
+	}
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+var eventContract = `{
+  "body": {
+    "contract": {"id": "c1", "version": "2"},
+    "machine": "m1",
+    "kosmosLocalSystems": ["edge-a", "edge-b"],
+    "sensors": [{"name": "s1"}, {"name": "s2"}],
+    "analysis": {
+      "enable": true,
+      "systems": [
+        {"enable": true, "system": "edge-a", "pipelines": [{"ml-trigger": {"type": "event"}, "pipeline": [], "sensors": ["s1"]}]},
+        {"enable": true, "system": "cloud", "pipelines": [{"ml-trigger": {"type": "time"}, "pipeline": [], "sensors": ["s2"]}]}
+      ]
+    }
+  }
+}`
+
+func TestEventPublisher_Publish(t *testing.T) {
+	var contract models.Contract
+	if err := json.Unmarshal([]byte(eventContract), &contract); err != nil {
+		t.Fatalf("cannot parse contract: %s", err)
+	}
+
+	now := time.Date(2020, 9, 23, 10, 0, 0, 0, time.UTC)
+
+	testTable := []struct {
+		description string
+		err         error
+		topics      []string
+	}{
+		{"event per local system", nil, []string{"kosmos/contract/edge-a/c1/created", "kosmos/contract/edge-b/c1/created"}},
+		{"outbox not available", fmt.Errorf("error"), nil},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			messages := &testOutbox{err: v.err}
+			publisher := eventPublisher{
+				messages: func(outbox.Executor) outbox.Outbox { return messages },
+				topic:    mqtt.Topic{Template: mqtt.DefaultContractEventTopic, QoS: 1},
+				now:      func() time.Time { return now },
+			}
+
+			err := publisher.Publish(nil, EventCreated, contract)
+			if (err != nil) != (v.err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var topics []string
+			for _, msg := range messages.messages {
+				topics = append(topics, msg.Topic)
+			}
+
+			if !reflect.DeepEqual(topics, v.topics) {
+				t.Fatalf("expected topics %v, got %v", v.topics, topics)
+			}
+
+			if len(messages.messages) == 0 {
+				return
+			}
+
+			var event Event
+			if err := json.Unmarshal(messages.messages[0].Msg, &event); err != nil {
+				t.Fatalf("cannot parse event: %s", err)
+			}
+
+			if event.Event != EventCreated || event.Contract != "c1" || event.Version != "2" || event.System != "edge-a" ||
+				event.Machine != "m1" || event.Timestamp != "2020-09-23T10:00:00Z" {
+				t.Errorf("unexpected event: %v", event)
+			}
+
+			if !reflect.DeepEqual(event.Sensors, []string{"s1", "s2"}) {
+				t.Errorf("unexpected sensors: %v", event.Sensors)
+			}
+
+			// only the pipelines of the system are part of the event
+			if len(event.Pipelines) != 1 || !reflect.DeepEqual(event.Pipelines[0].Sensors, []string{"s1"}) {
+				t.Errorf("unexpected pipelines: %v", event.Pipelines)
+			}
+		})
+	}
+}
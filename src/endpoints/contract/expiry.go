@@ -0,0 +1,51 @@
+package contract
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+)
+
+// ExpiryWatcher marks contracts with an ended validity window as expired
+type ExpiryWatcher interface {
+	// Run checks periodically for expired contracts and publishes an expired event for each of them
+	Run()
+}
+
+// NewExpiryWatcher creates a new expiry watcher, which checks for expired contracts in the given interval
+func NewExpiryWatcher(states models.StateHandler, events EventPublisher, interval time.Duration) ExpiryWatcher {
+	return expiryWatcher{states: states, events: events, interval: interval}
+}
+
+type expiryWatcher struct {
+	states   models.StateHandler
+	events   EventPublisher
+	interval time.Duration
+}
+
+// expire marks the expired contracts; the events are queued in the same transaction, so that
+// either the contracts are expired and their events are queued or nothing is changed
+func (e expiryWatcher) expire() error {
+	contracts, err := e.states.Expire(hook(e.events, EventExpired))
+	if err != nil {
+		return fmt.Errorf("cannot expire contracts: %s", err)
+	}
+
+	for _, id := range contracts {
+		klog.Infof("contract %s is expired", id)
+	}
+
+	return nil
+}
+
+func (e expiryWatcher) Run() {
+	for {
+		if err := e.expire(); err != nil {
+			klog.Error(err)
+		}
+		time.Sleep(e.interval)
+	}
+}
@@ -1,10 +1,6 @@
 package models
 
-import (
-	"time"
-
-	"k8s.io/klog"
-)
+import "encoding/json"
 
 type StorageDuration struct {
 	SystemName string `json:"systemName"`
@@ -44,8 +40,28 @@ type Contract struct {
 		MachineConnection interface{} `json:"machineConnection"`
 		Blockchain        interface{} `json:"blockchain"`
 	} `json:"body"`
-	// TODO update signature to new object
-	Signature interface{} `json:"signature"`
+	Signature Signature `json:"signature"`
+	// RawBody is the body as it is uploaded
+	RawBody json.RawMessage `json:"-"`
+}
+
+// SignedBody returns the body, against which the signature of the contract is verified. The uploaded body is used,
+// if it is known, so that the signature covers the bytes signed by the partner and not the parsed subset of them.
+func (c Contract) SignedBody() interface{} {
+	if c.RawBody != nil {
+		return c.RawBody
+	}
+	return c.Body
+}
+
+// Signature is the signature of the contract body
+type Signature struct {
+	Signature string `json:"signature"`
+	Meta      struct {
+		Date         string `json:"date"`
+		Algorithm    string `json:"algorithm"`
+		SerialNumber string `json:"serialNumber"`
+	} `json:"meta"`
 }
 
 type Analysis struct {
@@ -73,57 +89,6 @@ type Pipeline struct {
 	To        *Model    `json:"to"`
 }
 
-// TODO validate the message against the signature
-// TODO ignore other remote systems
-func (c Contract) Valid(localSystem string) bool {
-	body := c.Body
-	systems := make(map[string]bool)
-
-	for _, system := range body.KosmosLocalSystems {
-		systems[system] = true
-	}
-
-	systems[localSystem] = true
-
-	for _, analyse := range body.Analysis.Systems {
-		if _, ok := systems[analyse.Name]; !ok {
-			klog.Infof("analyse.Name %s doesn't exists", analyse.Name)
-			return false
-		}
-	}
-
-	for _, container := range body.TechnicalContainers {
-		if _, ok := systems[container.System]; !ok {
-			return false
-		}
-	}
-
-	start, err := time.Parse(time.RFC3339, body.Contract.Valid.Start)
-	if err != nil {
-		klog.Errorf("cannot parse start validation: %s", err)
-		return false
-	}
-
-	end, err := time.Parse(time.RFC3339, body.Contract.Valid.End)
-	if err != nil {
-		klog.Errorf("cannot parse end validation: %s", err)
-		return false
-	}
-
-	if start.After(end) {
-		return false
-	}
-
-	_, err = time.Parse(time.RFC3339, body.Contract.CreationTime)
-	if err != nil {
-		klog.Errorf("cannot parse contract creation time: %s", err)
-		return false
-	}
-
-	return true
-
-}
-
 type Model struct {
 	Tag string `json:"tag"`
 	Url string `json:"url"`
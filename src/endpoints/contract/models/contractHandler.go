@@ -3,21 +3,37 @@ package models
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
 	"k8s.io/klog"
 )
 
+// ChangeHook is executed inside the transaction, which changes the contract; if it fails, the change is rolled back
+type ChangeHook func(tx *sql.Tx, contract Contract) error
+
 type ContractHandler interface {
 	// InsertContract write the contract to a persistent storage
-	InsertContract(contract Contract) error
+	InsertContract(contract Contract, hook ChangeHook) error
+
+	// DeleteContract deactivates the contract of the removal and stores the removal in the removal history
+	DeleteContract(removal ContractRemoval, hook ChangeHook) error
 
-	// DeleteContract delete a contract identified by the the id from the persistent storage
-	DeleteContract(contract string) error
+	// GetContractRemovals get the removal history of a contract
+	GetContractRemovals(contract string) ([]ContractRemovalRecord, error)
 
 	// GetContract get a specific contract from the persistent storage based on the id
 	GetContract(contract string) (Contract, error)
+
+	// UpdateContract stores a new version of an existing contract and deactivates the previous version
+	UpdateContract(contract Contract, hook ChangeHook) error
+
+	// GetContractVersion get a specific version of a contract from the persistent storage
+	GetContractVersion(contract, version string) (Contract, error)
+
+	// GetContractHistory get all versions of a contract, starting with the newest one
+	GetContractHistory(contract string) ([]ContractVersion, error)
 }
 
 type contractHandler struct {
@@ -25,30 +41,56 @@ type contractHandler struct {
 	system string
 }
 
+// dbExecutor is implemented by *sql.DB and *sql.Tx, so that the insert helpers
+// can be used inside and outside of a transaction
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 type sensorId struct {
 	Sensor                int64
 	MachineSensor         int64
 	ContractMachineSensor int64
 }
 
-func (c contractHandler) InsertContract(contract Contract) error {
+// InsertContract inserts the contract and all depending data in a single transaction.
+// If one of the insertions fails, the transaction will be rolled back.
+func (c contractHandler) InsertContract(contract Contract, hook ChangeHook) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := runHook(tx, contract, hook, c.insertContract(tx, contract)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			klog.Errorf("cannot rollback transaction: %s", rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (c contractHandler) insertContract(tx dbExecutor, contract Contract) error {
 	contractJson, err := json.Marshal(contract)
 	if err != nil {
 		klog.Errorf("marshal error: %v", err)
 		return err
 	}
 
-	query, err := c.db.Query("SELECT * FROM contracts WHERE id = $1", contract.Body.Contract.ID)
+	exists, err := c.contractExists(tx, contract.Body.Contract.ID)
 	if err != nil {
 		klog.Errorf("cannot query contracts")
 		return err
 	}
 
-	if query.Next() {
+	if exists {
 		return fmt.Errorf("the contract with id: %s already exists", contract.Body.Contract.ID)
 	}
 
-	_, err = c.db.Exec("INSERT INTO contracts (id, start_time, end_time, creation, validate_signature, contract) VALUES  ($1, $2, $3, $4, $5, $6)",
+	_, err = tx.Exec("INSERT INTO contracts (id, start_time, end_time, creation, validate_signature, contract) VALUES  ($1, $2, $3, $4, $5, $6)",
 		contract.Body.Contract.ID,
 		contract.Body.Contract.Valid.Start,
 		contract.Body.Contract.Valid.End,
@@ -60,22 +102,28 @@ func (c contractHandler) InsertContract(contract Contract) error {
 		return err
 	}
 
+	return c.insertContractDetails(tx, contract)
+}
+
+// insertContractDetails inserts all data, which are derived from the contract body,
+// like the permissions, sensors and pipelines
+func (c contractHandler) insertContractDetails(tx dbExecutor, contract Contract) error {
 	klog.Infof("insert partners")
-	if err := c.insertPartners(contract.Body.Contract.ID, contract.Body.Contract.Partners); err != nil {
+	if err := c.insertPartners(tx, contract.Body.Contract.ID, contract.Body.Contract.Partners); err != nil {
 		return err
 	}
 
 	klog.Infof("insert permission read")
-	if err := c.insertPermission(false, contract.Body.Contract.ID, contract.Body.Contract.Permissions.Read); err != nil {
+	if err := c.insertPermission(tx, false, contract.Body.Contract.ID, contract.Body.Contract.Permissions.Read); err != nil {
 		return err
 	}
 	klog.Infof("insert permission write")
-	if err := c.insertPermission(true, contract.Body.Contract.ID, contract.Body.Contract.Permissions.Write); err != nil {
+	if err := c.insertPermission(tx, true, contract.Body.Contract.ID, contract.Body.Contract.Permissions.Write); err != nil {
 		return err
 	}
 
 	klog.Infof("insert machine")
-	if err := c.insertMachine(contract.Body.Machine); err != nil {
+	if err := c.insertMachine(tx, contract.Body.Machine); err != nil {
 		return err
 	}
 
@@ -85,7 +133,7 @@ func (c contractHandler) InsertContract(contract Contract) error {
 	for _, system := range contract.Body.KosmosLocalSystems {
 		err := func() error {
 
-			systemIdQuery, err := c.db.Query("SELECT id FROM systems WHERE name = $1", system)
+			systemIdQuery, err := tx.Query("SELECT id FROM systems WHERE name = $1", system)
 			if err != nil {
 				return err
 			}
@@ -106,7 +154,7 @@ func (c contractHandler) InsertContract(contract Contract) error {
 				return nil
 			}
 
-			systemId, err := c.db.Query("INSERT INTO systems (name) VALUES ($1) RETURNING id", system)
+			systemId, err := tx.Query("INSERT INTO systems (name) VALUES ($1) RETURNING id", system)
 			if err != nil {
 				return err
 			}
@@ -136,7 +184,7 @@ func (c contractHandler) InsertContract(contract Contract) error {
 
 	klog.Infof("insert technical container")
 	for _, tc := range contract.Body.TechnicalContainers {
-		if err := c.insertTechnicalContainer(systemMap[tc.System], tc.Containers, contract.Body.Contract.ID); err != nil {
+		if err := c.insertTechnicalContainer(tx, systemMap[tc.System], tc.Containers, contract.Body.Contract.ID); err != nil {
 			return err
 		}
 	}
@@ -148,21 +196,21 @@ func (c contractHandler) InsertContract(contract Contract) error {
 		var si sensorId
 
 		klog.Infof("insert only sensor")
-		id, err := c.insertOnlySensor(sensor.Name, sensor.Meta)
+		id, err := c.insertOnlySensor(tx, sensor.Name, sensor.Meta)
 		if err != nil {
 			return err
 		}
 		si.Sensor = id
 
 		klog.Infof("insert machine sensor")
-		ms, err := c.insertMachineSensor(contract.Body.Machine, id)
+		ms, err := c.insertMachineSensor(tx, contract.Body.Machine, id)
 		if err != nil {
 			return err
 		}
 		si.MachineSensor = ms
 
 		klog.Infof("insert contract machine sensor")
-		cms, err := c.insertContractMachineSensor(contract.Body.Contract.ID, ms)
+		cms, err := c.insertContractMachineSensor(tx, contract.Body.Contract.ID, ms)
 		if err != nil {
 			return err
 		}
@@ -171,7 +219,7 @@ func (c contractHandler) InsertContract(contract Contract) error {
 
 		klog.Infof("insert storage duration")
 		for _, duration := range sensor.StorageDuration {
-			err = c.insertStorageDuration(cms, duration.Duration, systemMap[duration.SystemName])
+			err = c.insertStorageDuration(tx, cms, duration.Duration, systemMap[duration.SystemName])
 			if err != nil {
 				return err
 			}
@@ -181,14 +229,29 @@ func (c contractHandler) InsertContract(contract Contract) error {
 	}
 
 	klog.Infof("insert analysis")
-	if err := c.insertAnalysis(contract.Body.Analysis, systemMap, sensorMap); err != nil {
+	if err := c.insertAnalysis(tx, contract.Body.Analysis, systemMap, sensorMap); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (c contractHandler) insertAnalysis(analysis Analysis, systemMap map[string]int64, sensorId map[string]sensorId) error {
+func (c contractHandler) contractExists(tx dbExecutor, contract string) (bool, error) {
+	query, err := tx.Query("SELECT id FROM contracts WHERE id = $1", contract)
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	return query.Next(), nil
+}
+
+func (c contractHandler) insertAnalysis(tx dbExecutor, analysis Analysis, systemMap map[string]int64, sensorId map[string]sensorId) error {
 	if !analysis.Enable {
 		return nil
 	}
@@ -207,7 +270,7 @@ func (c contractHandler) insertAnalysis(analysis Analysis, systemMap map[string]
 			var pIds []int64
 			for _, sensor := range pipeline.Sensors {
 				klog.Infof("analysis of system: %s", system.Name)
-				pId, err := c.insertPipeline(sensorId[sensor].ContractMachineSensor, systemMap[system.Name], pipeline.Trigger)
+				pId, err := c.insertPipeline(tx, sensorId[sensor].ContractMachineSensor, systemMap[system.Name], pipeline.Trigger)
 				if err != nil {
 					return err
 				}
@@ -217,7 +280,7 @@ func (c contractHandler) insertAnalysis(analysis Analysis, systemMap map[string]
 
 			modelContainerMap := make(map[string]int64)
 			for _, pipe := range pipeline.Pipeline {
-				exec, err := c.insertModel(pipe.Container)
+				exec, err := c.insertModel(tx, pipe.Container)
 				if err != nil {
 					return err
 				}
@@ -240,7 +303,7 @@ func (c contractHandler) insertAnalysis(analysis Analysis, systemMap map[string]
 
 				if pipe.From == nil && pipe.To == nil {
 					for _, pId := range pIds {
-						_, err := c.db.Exec("INSERT INTO analysis (pipeline, persist, execute) VALUES ($1, $2, $3)",
+						_, err := tx.Exec("INSERT INTO analysis (pipeline, persist, execute) VALUES ($1, $2, $3)",
 							pId,
 							pipe.Persist,
 							exec,
@@ -250,13 +313,13 @@ func (c contractHandler) insertAnalysis(analysis Analysis, systemMap map[string]
 						}
 					}
 				} else if pipe.From == nil {
-					to, err := c.getModelId(*(pipe.To))
+					to, err := c.getModelId(tx, *(pipe.To))
 					if err != nil {
 						return err
 					}
 
 					for _, pId := range pIds {
-						_, err := c.db.Exec("INSERT INTO analysis (pipeline, next_model, persist, execute) VALUES ($1, $2, $3, $4)",
+						_, err := tx.Exec("INSERT INTO analysis (pipeline, next_model, persist, execute) VALUES ($1, $2, $3, $4)",
 							pId,
 							to,
 							pipe.Persist,
@@ -267,13 +330,13 @@ func (c contractHandler) insertAnalysis(analysis Analysis, systemMap map[string]
 						}
 					}
 				} else if pipe.To == nil {
-					from, err := c.getModelId(*(pipe.From))
+					from, err := c.getModelId(tx, *(pipe.From))
 					if err != nil {
 						return err
 					}
 
 					for _, pId := range pIds {
-						_, err := c.db.Exec("INSERT INTO analysis (pipeline, prev_model, persist, execute) VALUES ($1, $2, $3, $4)",
+						_, err := tx.Exec("INSERT INTO analysis (pipeline, prev_model, persist, execute) VALUES ($1, $2, $3, $4)",
 							pId,
 							from,
 							pipe.Persist,
@@ -284,18 +347,18 @@ func (c contractHandler) insertAnalysis(analysis Analysis, systemMap map[string]
 						}
 					}
 				} else {
-					from, err := c.getModelId(*(pipe.From))
+					from, err := c.getModelId(tx, *(pipe.From))
 					if err != nil {
 						return err
 					}
 
-					to, err := c.getModelId(*(pipe.To))
+					to, err := c.getModelId(tx, *(pipe.To))
 					if err != nil {
 						return err
 					}
 
 					for _, pId := range pIds {
-						_, err := c.db.Exec("INSERT INTO analysis (pipeline, prev_model, next_model, persist, execute) VALUES ($1, $2, $3, $4, $5)",
+						_, err := tx.Exec("INSERT INTO analysis (pipeline, prev_model, next_model, persist, execute) VALUES ($1, $2, $3, $4, $5)",
 							pId,
 							from,
 							to,
@@ -314,8 +377,8 @@ func (c contractHandler) insertAnalysis(analysis Analysis, systemMap map[string]
 	return nil
 }
 
-func (c contractHandler) getModelId(model Model) (int64, error) {
-	query, err := c.db.Query("SELECT m.id FROM models AS m JOIN containers c on c.id = m.container WHERE c.url = $1 AND c.tag = $2",
+func (c contractHandler) getModelId(tx dbExecutor, model Model) (int64, error) {
+	query, err := tx.Query("SELECT m.id FROM models AS m JOIN containers c on c.id = m.container WHERE c.url = $1 AND c.tag = $2",
 		model.Url,
 		model.Tag,
 	)
@@ -338,7 +401,7 @@ func (c contractHandler) getModelId(model Model) (int64, error) {
 	return 0, fmt.Errorf("could not found model with url %s and tag %s", model.Url, model.Tag)
 }
 
-func (c contractHandler) insertPipeline(cms, system int64, trigger Trigger) (int64, error) {
+func (c contractHandler) insertPipeline(tx dbExecutor, cms, system int64, trigger Trigger) (int64, error) {
 	var tr string
 
 	if trigger.Definition == nil {
@@ -347,7 +410,7 @@ func (c contractHandler) insertPipeline(cms, system int64, trigger Trigger) (int
 		tr = trigger.Definition.After
 	}
 
-	queryID, err := c.db.Query("SELECT id FROM pipelines WHERE contract_machine_sensor = $1 AND system = $2 AND time_trigger = $3",
+	queryID, err := tx.Query("SELECT id FROM pipelines WHERE contract_machine_sensor = $1 AND system = $2 AND time_trigger = $3",
 		cms,
 		system,
 		tr,
@@ -371,13 +434,13 @@ func (c contractHandler) insertPipeline(cms, system int64, trigger Trigger) (int
 	var query *sql.Rows
 	if tr == "NULL" {
 		klog.Infof("tr == null\nsystemid is %d", system)
-		query, err = c.db.Query("INSERT INTO pipelines (contract_machine_sensor, system) VALUES ($1, $2) RETURNING id",
+		query, err = tx.Query("INSERT INTO pipelines (contract_machine_sensor, system) VALUES ($1, $2) RETURNING id",
 			cms,
 			system,
 		)
 	} else {
 		klog.Infof("tr != null")
-		query, err = c.db.Query("INSERT INTO pipelines (contract_machine_sensor, system, time_trigger) VALUES ($1, $2, $3) RETURNING id",
+		query, err = tx.Query("INSERT INTO pipelines (contract_machine_sensor, system, time_trigger) VALUES ($1, $2, $3) RETURNING id",
 			cms,
 			system,
 			tr,
@@ -404,13 +467,13 @@ func (c contractHandler) insertPipeline(cms, system int64, trigger Trigger) (int
 	return id, err
 }
 
-func (c contractHandler) insertModel(container Container) (int64, error) {
-	cId, err := c.insertContainer(container)
+func (c contractHandler) insertModel(tx dbExecutor, container Container) (int64, error) {
+	cId, err := c.insertContainer(tx, container)
 	if err != nil {
 		return 0, err
 	}
 
-	query, err := c.db.Query("SELECT id FROM models WHERE container = $1", cId)
+	query, err := tx.Query("SELECT id FROM models WHERE container = $1", cId)
 	if err != nil {
 		return 0, err
 	}
@@ -427,7 +490,7 @@ func (c contractHandler) insertModel(container Container) (int64, error) {
 		return id, err
 	}
 
-	insertQuery, err := c.db.Query("INSERT INTO models (container) VALUES ($1) RETURNING id", cId)
+	insertQuery, err := tx.Query("INSERT INTO models (container) VALUES ($1) RETURNING id", cId)
 	if err != nil {
 		return 0, err
 	}
@@ -447,8 +510,8 @@ func (c contractHandler) insertModel(container Container) (int64, error) {
 	return id, err
 }
 
-func (c contractHandler) insertMachine(machine string) error {
-	query, err := c.db.Query("SELECT id FROM machines WHERE id = $1", machine)
+func (c contractHandler) insertMachine(tx dbExecutor, machine string) error {
+	query, err := tx.Query("SELECT id FROM machines WHERE id = $1", machine)
 	if err != nil {
 		return err
 	}
@@ -463,18 +526,18 @@ func (c contractHandler) insertMachine(machine string) error {
 		return nil
 	}
 
-	_, err = c.db.Exec("INSERT INTO machines (id) VALUES ($1)", machine)
+	_, err = tx.Exec("INSERT INTO machines (id) VALUES ($1)", machine)
 	return err
 }
 
-func (c contractHandler) insertTechnicalContainer(system int64, containers []Container, contract string) error {
+func (c contractHandler) insertTechnicalContainer(tx dbExecutor, system int64, containers []Container, contract string) error {
 	for _, container := range containers {
-		id, err := c.insertContainer(container)
+		id, err := c.insertContainer(tx, container)
 		if err != nil {
 			return err
 		}
 
-		if _, err := c.db.Exec("INSERT INTO technical_containers (contract, container, system) VALUES ($1, $2, $3)", contract, id, system); err != nil {
+		if _, err := tx.Exec("INSERT INTO technical_containers (contract, container, system) VALUES ($1, $2, $3)", contract, id, system); err != nil {
 			return err
 		}
 	}
@@ -489,8 +552,8 @@ func (c contractHandler) stringArrayToString(arg []string) string {
 	return fmt.Sprintf("{'%s'}", strings.Join(arg, "','"))
 }
 
-func (c contractHandler) insertContainer(container Container) (int64, error) {
-	query, err := c.db.Query("SELECT id FROM containers WHERE url = $1 AND tag = $2 AND arguments = $3 AND environment = $4",
+func (c contractHandler) insertContainer(tx dbExecutor, container Container) (int64, error) {
+	query, err := tx.Query("SELECT id FROM containers WHERE url = $1 AND tag = $2 AND arguments = $3 AND environment = $4",
 		container.Url,
 		container.Tag,
 		c.stringArrayToString(container.Arguments),
@@ -512,7 +575,7 @@ func (c contractHandler) insertContainer(container Container) (int64, error) {
 		return id, err
 	}
 
-	queryInsert, err := c.db.Query("INSERT INTO containers (url, tag, arguments, environment) VALUES ($1, $2, $3, $4) RETURNING  id",
+	queryInsert, err := tx.Query("INSERT INTO containers (url, tag, arguments, environment) VALUES ($1, $2, $3, $4) RETURNING  id",
 		container.Url,
 		container.Tag,
 		c.stringArrayToString(container.Arguments),
@@ -538,9 +601,9 @@ func (c contractHandler) insertContainer(container Container) (int64, error) {
 
 }
 
-func (c contractHandler) insertPermission(write bool, contract string, orgs []string) error {
+func (c contractHandler) insertPermission(tx dbExecutor, write bool, contract string, orgs []string) error {
 	for _, org := range orgs {
-		id, err := c.insertOrganisations(org)
+		id, err := c.insertOrganisations(tx, org)
 		if err != nil {
 			return err
 		}
@@ -553,7 +616,7 @@ func (c contractHandler) insertPermission(write bool, contract string, orgs []st
 		}
 
 		queryString := fmt.Sprintf("INSERT INTO %s (contract, organisation) VALUES ($1, $2)", table)
-		if _, err := c.db.Exec(queryString, contract, id); err != nil {
+		if _, err := tx.Exec(queryString, contract, id); err != nil {
 			return err
 		}
 	}
@@ -561,14 +624,14 @@ func (c contractHandler) insertPermission(write bool, contract string, orgs []st
 	return nil
 }
 
-func (c contractHandler) insertPartners(contract string, partners []string) error {
+func (c contractHandler) insertPartners(tx dbExecutor, contract string, partners []string) error {
 	for _, partner := range partners {
-		org, err := c.insertOrganisations(partner)
+		org, err := c.insertOrganisations(tx, partner)
 		if err != nil {
 			return err
 		}
 
-		_, err = c.db.Exec("INSERT INTO partners (contract, organisation) VALUES ($1, $2)", contract, org)
+		_, err = tx.Exec("INSERT INTO partners (contract, organisation) VALUES ($1, $2)", contract, org)
 		if err != nil {
 			return err
 		}
@@ -577,8 +640,8 @@ func (c contractHandler) insertPartners(contract string, partners []string) erro
 	return nil
 }
 
-func (c contractHandler) insertOrganisations(organisation string) (int64, error) {
-	query, err := c.db.Query("SELECT id FROM organisations WHERE name = $1", organisation)
+func (c contractHandler) insertOrganisations(tx dbExecutor, organisation string) (int64, error) {
+	query, err := tx.Query("SELECT id FROM organisations WHERE name = $1", organisation)
 	if err != nil {
 		return 0, err
 	}
@@ -595,7 +658,7 @@ func (c contractHandler) insertOrganisations(organisation string) (int64, error)
 		return id, err
 	}
 
-	queryInsert, err := c.db.Query("INSERT INTO organisations (name) VALUES ($1) RETURNING id", organisation)
+	queryInsert, err := tx.Query("INSERT INTO organisations (name) VALUES ($1) RETURNING id", organisation)
 	if err != nil {
 		return 0, err
 	}
@@ -616,8 +679,8 @@ func (c contractHandler) insertOrganisations(organisation string) (int64, error)
 	return id, err
 }
 
-func (c contractHandler) insertMachineSensor(machine string, sensor int64) (int64, error) {
-	query, err := c.db.Query("SELECT id FROM machine_sensors WHERE machine = $1 AND sensor = $2", machine, sensor)
+func (c contractHandler) insertMachineSensor(tx dbExecutor, machine string, sensor int64) (int64, error) {
+	query, err := tx.Query("SELECT id FROM machine_sensors WHERE machine = $1 AND sensor = $2", machine, sensor)
 	if err != nil {
 		return 0, err
 	}
@@ -634,11 +697,17 @@ func (c contractHandler) insertMachineSensor(machine string, sensor int64) (int6
 		return id, err
 	}
 
-	queryInsert, err := c.db.Query("INSERT INTO machine_sensors (machine, sensor) VALUES ($1, $2) RETURNING id", machine, sensor)
+	queryInsert, err := tx.Query("INSERT INTO machine_sensors (machine, sensor) VALUES ($1, $2) RETURNING id", machine, sensor)
 	if err != nil {
 		return 0, err
 	}
 
+	defer func() {
+		if err := queryInsert.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
 	if !queryInsert.Next() {
 		return 0, fmt.Errorf("inertion was not successfull")
 	}
@@ -648,29 +717,30 @@ func (c contractHandler) insertMachineSensor(machine string, sensor int64) (int6
 	return id, err
 }
 
-func (c contractHandler) insertContractMachineSensor(contract string, machineSensor int64) (int64, error) {
-	query, err := c.db.Query("SELECT id FROM contract_machine_sensors WHERE contract = $1 AND machine_sensor = $2", contract, machineSensor)
+// insertContractMachineSensor returns the active contract machine sensor; a sensor of a previous version of the
+// contract is activated again
+func (c contractHandler) insertContractMachineSensor(tx dbExecutor, contract string, machineSensor int64) (int64, error) {
+	var existing int64
+	err := tx.QueryRow("SELECT id FROM contract_machine_sensors WHERE contract = $1 AND machine_sensor = $2", contract, machineSensor).Scan(&existing)
+	if err == nil {
+		_, err = tx.Exec("UPDATE contract_machine_sensors SET active = true WHERE id = $1", existing)
+		return existing, err
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	queryInsert, err := tx.Query("INSERT INTO contract_machine_sensors (contract, machine_sensor) VALUES ($1, $2) RETURNING id", contract, machineSensor)
 	if err != nil {
 		return 0, err
 	}
 
 	defer func() {
-		if err := query.Close(); err != nil {
+		if err := queryInsert.Close(); err != nil {
 			klog.Errorf("cannot close query object: %s", err)
 		}
 	}()
 
-	if query.Next() {
-		var id int64
-		err := query.Scan(&id)
-		return id, err
-	}
-
-	queryInsert, err := c.db.Query("INSERT INTO contract_machine_sensors (contract, machine_sensor) VALUES ($1, $2) RETURNING id", contract, machineSensor)
-	if err != nil {
-		return 0, err
-	}
-
 	if !queryInsert.Next() {
 		return 0, fmt.Errorf("inertion was not successfull")
 	}
@@ -680,8 +750,8 @@ func (c contractHandler) insertContractMachineSensor(contract string, machineSen
 	return id, err
 }
 
-func (c contractHandler) insertStorageDuration(contractMachineSensor int64, duration string, system int64) error {
-	_, err := c.db.Exec("INSERT INTO storage_duration (system, contract_machine_sensor, duration) VALUES ($1, $2, $3)",
+func (c contractHandler) insertStorageDuration(tx dbExecutor, contractMachineSensor int64, duration string, system int64) error {
+	_, err := tx.Exec("INSERT INTO storage_duration (system, contract_machine_sensor, duration) VALUES ($1, $2, $3)",
 		system,
 		contractMachineSensor,
 		duration,
@@ -689,7 +759,7 @@ func (c contractHandler) insertStorageDuration(contractMachineSensor int64, dura
 	return err
 }
 
-func (c contractHandler) insertOnlySensor(sensor string, meta interface{}) (int64, error) {
+func (c contractHandler) insertOnlySensor(tx dbExecutor, sensor string, meta interface{}) (int64, error) {
 	data, err := json.Marshal(meta)
 	if err != nil {
 		klog.Errorf("crasy 0")
@@ -698,9 +768,9 @@ func (c contractHandler) insertOnlySensor(sensor string, meta interface{}) (int6
 
 	var query, insertQuery *sql.Rows
 	if string(data) == "{}" {
-		query, err = c.db.Query("SELECT id FROM sensors WHERE transmitted_id = $1", sensor)
+		query, err = tx.Query("SELECT id FROM sensors WHERE transmitted_id = $1", sensor)
 	} else {
-		query, err = c.db.Query("SELECT id FROM sensors WHERE transmitted_id = $1 AND meta = $2", sensor, string(data))
+		query, err = tx.Query("SELECT id FROM sensors WHERE transmitted_id = $1 AND meta = $2", sensor, string(data))
 	}
 	if err != nil {
 		return 0, err
@@ -719,9 +789,9 @@ func (c contractHandler) insertOnlySensor(sensor string, meta interface{}) (int6
 	}
 
 	if string(data) == "{}" {
-		insertQuery, err = c.db.Query("INSERT INTO sensors (transmitted_id) VALUES ($1) RETURNING id", sensor)
+		insertQuery, err = tx.Query("INSERT INTO sensors (transmitted_id) VALUES ($1) RETURNING id", sensor)
 	} else {
-		insertQuery, err = c.db.Query("INSERT INTO sensors (transmitted_id, meta) VALUES ($1, $2) RETURNING id", sensor, string(data))
+		insertQuery, err = tx.Query("INSERT INTO sensors (transmitted_id, meta) VALUES ($1, $2) RETURNING id", sensor, string(data))
 	}
 	if err != nil {
 		return 0, err
@@ -742,9 +812,80 @@ func (c contractHandler) insertOnlySensor(sensor string, meta interface{}) (int6
 	return id, err
 }
 
-func (c contractHandler) DeleteContract(contract string) error {
-	_, err := c.db.Exec("UPDATE contracts SET active = false WHERE id = $1", contract)
-	return err
+// DeleteContract deactivates the contract and stores the removal in a single transaction
+func (c contractHandler) DeleteContract(removal ContractRemoval, hook ChangeHook) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	contract, err := c.deleteContract(tx, removal)
+	if err := runHook(tx, contract, hook, err); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			klog.Errorf("cannot rollback transaction: %s", rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteContract deactivates the contract and returns the deactivated contract
+func (c contractHandler) deleteContract(tx dbExecutor, removal ContractRemoval) (Contract, error) {
+	var contractJson []byte
+	err := tx.QueryRow("UPDATE contracts SET active = false WHERE id = $1 AND active RETURNING contract", removal.ContractID).Scan(&contractJson)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Contract{}, ErrContractNotFound
+	}
+	if err != nil {
+		return Contract{}, err
+	}
+
+	var contract Contract
+	if err := json.Unmarshal(contractJson, &contract); err != nil {
+		return Contract{}, err
+	}
+
+	_, err = tx.Exec("INSERT INTO contract_removals (contract, blame, reason, signature) VALUES ($1, $2, $3, $4)",
+		removal.ContractID,
+		removal.Blame,
+		removal.Reason,
+		removal.Signature,
+	)
+	return contract, err
+}
+
+// runHook executes the hook of a change, if the change has succeeded
+func runHook(tx *sql.Tx, contract Contract, hook ChangeHook, err error) error {
+	if err != nil || hook == nil {
+		return err
+	}
+	return hook(tx, contract)
+}
+
+func (c contractHandler) GetContractRemovals(contract string) ([]ContractRemovalRecord, error) {
+	query, err := c.db.Query("SELECT blame, reason, signature, removed FROM contract_removals WHERE contract = $1 ORDER BY removed", contract)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	var removals []ContractRemovalRecord
+	for query.Next() {
+		removal := ContractRemovalRecord{ContractRemoval: ContractRemoval{ContractID: contract}}
+		if err := query.Scan(&removal.Blame, &removal.Reason, &removal.Signature, &removal.Removed); err != nil {
+			return nil, err
+		}
+
+		removals = append(removals, removal)
+	}
+
+	return removals, nil
 }
 
 func (c contractHandler) GetContract(contract string) (Contract, error) {
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -10,21 +11,64 @@ import (
 )
 
 func TestDeleteContract(t *testing.T) {
+	contractRows := func() *dbMock.Rows {
+		return dbMock.NewRows([]string{"contract"}).AddRow([]byte(`{"body": {"contract": {"id": "contract"}}}`))
+	}
+
 	testTable := []struct {
 		description string
-		contractID  string
+		removal     ContractRemoval
+		updateRows  *dbMock.Rows
+		updateError error
+		insertError error
+		hookError   error
 		expectError error
 	}{
 		{
 			"success",
-			"contract",
+			ContractRemoval{ContractID: "contract", Blame: "org", Reason: "reason", Signature: "sig"},
+			contractRows(),
+			nil,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"contract not found or inactive",
+			ContractRemoval{ContractID: "contract", Blame: "org"},
+			dbMock.NewRows([]string{"contract"}),
+			nil,
+			nil,
 			nil,
+			ErrContractNotFound,
 		},
 		{
 			"db query error",
-			"contract",
+			ContractRemoval{ContractID: "contract", Blame: "org"},
+			nil,
+			fmt.Errorf("error"),
+			nil,
+			nil,
+			fmt.Errorf("error"),
+		},
+		{
+			"insert removal error",
+			ContractRemoval{ContractID: "contract", Blame: "org"},
+			contractRows(),
+			nil,
+			fmt.Errorf("error"),
+			nil,
 			fmt.Errorf("error"),
 		},
+		{
+			"hook error",
+			ContractRemoval{ContractID: "contract", Blame: "org"},
+			contractRows(),
+			nil,
+			nil,
+			fmt.Errorf("hook error"),
+			fmt.Errorf("hook error"),
+		},
 	}
 
 	for _, v := range testTable {
@@ -36,14 +80,38 @@ func TestDeleteContract(t *testing.T) {
 
 			defer db.Close()
 
+			mock.ExpectBegin()
+			update := mock.ExpectQuery("UPDATE contracts SET active = false WHERE id = $1 AND active RETURNING contract").WithArgs(v.removal.ContractID)
+			if v.updateError == nil {
+				update.WillReturnRows(v.updateRows)
+			} else {
+				update.WillReturnError(v.updateError)
+			}
+
+			if v.updateError == nil && v.expectError != ErrContractNotFound {
+				insert := mock.ExpectExec("INSERT INTO contract_removals (contract, blame, reason, signature) VALUES ($1, $2, $3, $4)").
+					WithArgs(v.removal.ContractID, v.removal.Blame, v.removal.Reason, v.removal.Signature)
+				if v.insertError == nil {
+					insert.WillReturnResult(dbMock.NewResult(1, 1))
+				} else {
+					insert.WillReturnError(v.insertError)
+				}
+			}
+
 			if v.expectError == nil {
-				mock.ExpectExec("UPDATE contracts SET active = false WHERE id = $1").WithArgs(v.contractID).WillReturnResult(dbMock.NewResult(0, 0))
+				mock.ExpectCommit()
 			} else {
-				mock.ExpectExec("UPDATE contracts SET active = false WHERE id = $1").WithArgs(v.contractID).WillReturnError(v.expectError)
+				mock.ExpectRollback()
+			}
+
+			var deactivated []string
+			hook := func(tx *sql.Tx, contract Contract) error {
+				deactivated = append(deactivated, contract.Body.Contract.ID)
+				return v.hookError
 			}
 
 			handler := contractHandler{db: db}
-			err = handler.DeleteContract(v.contractID)
+			err = handler.DeleteContract(v.removal, hook)
 
 			if v.expectError != nil && err != nil {
 				if v.expectError.Error() != err.Error() {
@@ -53,6 +121,10 @@ func TestDeleteContract(t *testing.T) {
 				t.Errorf("returned error is not equal to expected error\n\t%s != %s", v.expectError, err)
 			}
 
+			if v.expectError == nil && !reflect.DeepEqual(deactivated, []string{"contract"}) {
+				t.Errorf("hook is not executed for the deactivated contract: %v", deactivated)
+			}
+
 			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Errorf("not all expectaions are met: %s", err)
 			}
@@ -180,7 +252,7 @@ func TestInsertStorageDuration(t *testing.T) {
 			}
 
 			handler := contractHandler{db: db}
-			err = handler.insertStorageDuration(v.contractMachineSensor, v.duration, v.system)
+			err = handler.insertStorageDuration(db, v.contractMachineSensor, v.duration, v.system)
 			if err != nil && v.expectedError != nil {
 				if err.Error() != v.expectedError.Error() {
 					t.Errorf("returned error != expected Error\n\t%s != %s", err, v.expectedError)
@@ -195,3 +267,92 @@ func TestInsertStorageDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestInsertContract(t *testing.T) {
+	testContract := Contract{}
+	testContract.Body.Contract.ID = "contract"
+	testContract.Body.Contract.Partners = []string{"partner"}
+	jsonTestContract, err := json.Marshal(testContract)
+	if err != nil {
+		t.Fatalf("cannot marshal contract: %s", err)
+	}
+
+	testTable := []struct {
+		description   string
+		existingRows  *dbMock.Rows
+		insert        bool
+		insertError   error
+		partnerError  error
+		expectedError error
+	}{
+		{
+			"contract already exists",
+			dbMock.NewRows([]string{"id"}).AddRow("contract"),
+			false,
+			nil,
+			nil,
+			fmt.Errorf("the contract with id: contract already exists"),
+		},
+		{
+			"insert contract fails",
+			dbMock.NewRows([]string{"id"}),
+			true,
+			fmt.Errorf("error"),
+			nil,
+			fmt.Errorf("error"),
+		},
+		{
+			"insert partner fails after contract is inserted",
+			dbMock.NewRows([]string{"id"}),
+			true,
+			nil,
+			fmt.Errorf("error"),
+			fmt.Errorf("error"),
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("cannot create mocked database: %s", err)
+			}
+
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM contracts WHERE id = $1").WithArgs("contract").WillReturnRows(v.existingRows)
+
+			if v.insert {
+				insert := mock.ExpectExec("INSERT INTO contracts (id, start_time, end_time, creation, validate_signature, contract) VALUES  ($1, $2, $3, $4, $5, $6)").
+					WithArgs("contract", "", "", "", false, jsonTestContract)
+				if v.insertError != nil {
+					insert.WillReturnError(v.insertError)
+				} else {
+					insert.WillReturnResult(dbMock.NewResult(1, 1))
+				}
+			}
+
+			if v.partnerError != nil {
+				mock.ExpectQuery("SELECT id FROM organisations WHERE name = $1").WithArgs("partner").WillReturnRows(dbMock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectExec("INSERT INTO partners (contract, organisation) VALUES ($1, $2)").WithArgs("contract", 1).WillReturnError(v.partnerError)
+			}
+
+			mock.ExpectRollback()
+
+			handler := contractHandler{db: db, system: "cloud"}
+			err = handler.InsertContract(testContract, nil)
+			if err != nil && v.expectedError != nil {
+				if err.Error() != v.expectedError.Error() {
+					t.Errorf("returned error != expected Error\n\t%s != %s", err, v.expectedError)
+				}
+			} else if err != nil || v.expectedError != nil {
+				t.Errorf("returned error != expected Error\n\t%s != %s", err, v.expectedError)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("not all expectations were met: %s", err)
+			}
+		})
+	}
+}
@@ -1,7 +1,35 @@
 package models
 
+import "time"
+
+// ContractRemoval is the signed request to remove a contract
 type ContractRemoval struct {
 	ContractID string `json:"contract"`
-	Blame string `json:"blame"`
-	Signature string `json:"signature"`
+	Blame      string `json:"blame"`
+	Reason     string `json:"reason"`
+	Signature  string `json:"signature"`
+}
+
+// ContractRemovalRecord is a stored contract removal
+type ContractRemovalRecord struct {
+	ContractRemoval
+	Removed time.Time `json:"removed"`
+}
+
+// SignedBody returns the part of the removal, which is covered by the signature
+func (c ContractRemoval) SignedBody() interface{} {
+	return struct {
+		ContractID string `json:"contract"`
+		Blame      string `json:"blame"`
+		Reason     string `json:"reason"`
+	}{
+		ContractID: c.ContractID,
+		Blame:      c.Blame,
+		Reason:     c.Reason,
+	}
+}
+
+// Valid checks if the removal belongs to the contract and contains the organisation, which removes the contract
+func (c ContractRemoval) Valid(contract string) bool {
+	return c.ContractID == contract && c.Blame != ""
 }
@@ -0,0 +1,202 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+
+	"k8s.io/klog"
+)
+
+var (
+	// ErrContractNotFound will be returned, if the requested contract does not exists
+	ErrContractNotFound = errors.New("contract not found")
+
+	// ErrContractInactive will be returned, if an update is made on a deactivated contract
+	ErrContractInactive = errors.New("contract is not active")
+
+	// ErrVersionExists will be returned, if the version of an updated contract is already stored
+	ErrVersionExists = errors.New("contract version already exists")
+)
+
+// ContractVersion describes a single stored version of a contract
+type ContractVersion struct {
+	Version      string `json:"version"`
+	CreationTime string `json:"creationTime"`
+	Start        string `json:"start"`
+	End          string `json:"end"`
+	Active       bool   `json:"active"`
+}
+
+// UpdateContract stores the transmitted contract as new version of the contract with the same id.
+// The previous version will be archived in the table contract_versions. All data derived from the
+// contract will be replaced in a single transaction.
+func (c contractHandler) UpdateContract(contract Contract, hook ChangeHook) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := runHook(tx, contract, hook, c.updateContract(tx, contract)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			klog.Errorf("cannot rollback transaction: %s", rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (c contractHandler) updateContract(tx dbExecutor, contract Contract) error {
+	id := contract.Body.Contract.ID
+
+	history, err := c.getContractHistory(tx, id)
+	if err != nil {
+		return err
+	}
+
+	if len(history) == 0 {
+		return ErrContractNotFound
+	}
+
+	if !history[0].Active {
+		return ErrContractInactive
+	}
+
+	for _, version := range history {
+		if version.Version == contract.Body.Contract.Version {
+			return ErrVersionExists
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO contract_versions (contract, version, creation, body) SELECT id, $2, creation, contract FROM contracts WHERE id = $1",
+		id,
+		history[0].Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	contractJson, err := json.Marshal(contract)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE contracts SET start_time = $2, end_time = $3, creation = $4, validate_signature = $5, contract = $6, expired = false WHERE id = $1",
+		id,
+		contract.Body.Contract.Valid.Start,
+		contract.Body.Contract.Valid.End,
+		contract.Body.Contract.CreationTime,
+		contract.Body.CheckSignature,
+		contractJson,
+	)
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("remove derived data of the previous contract version")
+	if err := c.removeContractDetails(tx, id); err != nil {
+		return err
+	}
+
+	return c.insertContractDetails(tx, contract)
+}
+
+// removeContractDetails removes the data, which are derived from the contract body and will
+// be recreated by insertContractDetails. Contract machine sensors are referenced by the stored
+// data, so they are deactivated and only the sensors of the new version are activated again.
+func (c contractHandler) removeContractDetails(tx dbExecutor, contract string) error {
+	statements := []string{
+		"DELETE FROM partners WHERE contract = $1",
+		"DELETE FROM read_permissions WHERE contract = $1",
+		"DELETE FROM write_permissions WHERE contract = $1",
+		"DELETE FROM technical_containers WHERE contract = $1",
+		"DELETE FROM storage_duration WHERE contract_machine_sensor IN (SELECT id FROM contract_machine_sensors WHERE contract = $1)",
+		"DELETE FROM analysis WHERE pipeline IN (SELECT p.id FROM pipelines AS p JOIN contract_machine_sensors cms on p.contract_machine_sensor = cms.id WHERE cms.contract = $1)",
+		"DELETE FROM pipelines WHERE contract_machine_sensor IN (SELECT id FROM contract_machine_sensors WHERE contract = $1)",
+		"UPDATE contract_machine_sensors SET active = false WHERE contract = $1",
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, contract); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c contractHandler) GetContractHistory(contract string) ([]ContractVersion, error) {
+	return c.getContractHistory(c.db, contract)
+}
+
+// getContractHistory returns the actual version of the contract followed by the archived versions,
+// the latest archived version first.
+func (c contractHandler) getContractHistory(tx dbExecutor, contract string) ([]ContractVersion, error) {
+	contracts, actives, err := c.queryHistory(tx, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []ContractVersion
+	for i, con := range contracts {
+		history = append(history, ContractVersion{
+			Version:      con.Body.Contract.Version,
+			CreationTime: con.Body.Contract.CreationTime,
+			Start:        con.Body.Contract.Valid.Start,
+			End:          con.Body.Contract.Valid.End,
+			Active:       actives[i],
+		})
+	}
+
+	return history, nil
+}
+
+func (c contractHandler) GetContractVersion(contract, version string) (Contract, error) {
+	contracts, _, err := c.queryHistory(c.db, contract)
+	if err != nil {
+		return Contract{}, err
+	}
+
+	for _, con := range contracts {
+		if con.Body.Contract.Version == version {
+			return con, nil
+		}
+	}
+
+	return Contract{}, ErrContractNotFound
+}
+
+func (c contractHandler) queryHistory(tx dbExecutor, contract string) ([]Contract, []bool, error) {
+	query, err := tx.Query("SELECT contract, active FROM (SELECT contract, active, NULL::timestamptz AS archived FROM contracts WHERE id = $1 UNION ALL SELECT body, false, archived FROM contract_versions WHERE contract = $1) AS history ORDER BY archived DESC NULLS FIRST",
+		contract,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	var contracts []Contract
+	var actives []bool
+	for query.Next() {
+		var contractJson string
+		var active bool
+		if err := query.Scan(&contractJson, &active); err != nil {
+			return nil, nil, err
+		}
+
+		var con Contract
+		if err := json.Unmarshal([]byte(contractJson), &con); err != nil {
+			return nil, nil, err
+		}
+
+		contracts = append(contracts, con)
+		actives = append(actives, active)
+	}
+
+	return contracts, actives, nil
+}
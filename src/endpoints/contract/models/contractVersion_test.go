@@ -0,0 +1,191 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	dbMock "github.com/DATA-DOG/go-sqlmock"
+)
+
+const historyQuery = "SELECT contract, active FROM (SELECT contract, active, NULL::timestamptz AS archived FROM contracts WHERE id = $1 UNION ALL SELECT body, false, archived FROM contract_versions WHERE contract = $1) AS history ORDER BY archived DESC NULLS FIRST"
+
+func versionedContract(t *testing.T, version string) (Contract, string) {
+	var contract Contract
+	contract.Body.Contract.ID = "contract"
+	contract.Body.Contract.Version = version
+
+	data, err := json.Marshal(contract)
+	if err != nil {
+		t.Fatalf("cannot marshal contract: %s", err)
+	}
+
+	return contract, string(data)
+}
+
+func TestUpdateContract(t *testing.T) {
+	_, v1 := versionedContract(t, "v1")
+	_, v2 := versionedContract(t, "v2")
+	update, _ := versionedContract(t, "v2")
+
+	testTable := []struct {
+		description   string
+		rows          *dbMock.Rows
+		expectedError error
+	}{
+		{
+			"contract not found",
+			dbMock.NewRows([]string{"contract", "active"}),
+			ErrContractNotFound,
+		},
+		{
+			"contract is inactive",
+			dbMock.NewRows([]string{"contract", "active"}).AddRow(v1, false),
+			ErrContractInactive,
+		},
+		{
+			"version already exists",
+			dbMock.NewRows([]string{"contract", "active"}).AddRow(v2, true).AddRow(v1, false),
+			ErrVersionExists,
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("cannot create mocked database: %s", err)
+			}
+
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(historyQuery).WithArgs("contract").WillReturnRows(v.rows)
+			mock.ExpectRollback()
+
+			handler := contractHandler{db: db, system: "cloud"}
+			err = handler.UpdateContract(update, nil)
+			if !errors.Is(err, v.expectedError) {
+				t.Errorf("returned error != expected error\n\t%s != %s", err, v.expectedError)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("not all expectations were met: %s", err)
+			}
+		})
+	}
+}
+
+func TestUpdateContractArchivesPreviousVersion(t *testing.T) {
+	_, v1 := versionedContract(t, "v1")
+	update, updateJson := versionedContract(t, "v2")
+
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mocked database: %s", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(historyQuery).WithArgs("contract").WillReturnRows(dbMock.NewRows([]string{"contract", "active"}).AddRow(v1, true))
+	mock.ExpectExec("INSERT INTO contract_versions (contract, version, creation, body) SELECT id, $2, creation, contract FROM contracts WHERE id = $1").
+		WithArgs("contract", "v1").
+		WillReturnResult(dbMock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE contracts SET start_time = $2, end_time = $3, creation = $4, validate_signature = $5, contract = $6, expired = false WHERE id = $1").
+		WithArgs("contract", "", "", "", false, []byte(updateJson)).
+		WillReturnResult(dbMock.NewResult(0, 1))
+	for _, statement := range []string{
+		"DELETE FROM partners WHERE contract = $1",
+		"DELETE FROM read_permissions WHERE contract = $1",
+		"DELETE FROM write_permissions WHERE contract = $1",
+		"DELETE FROM technical_containers WHERE contract = $1",
+		"DELETE FROM storage_duration WHERE contract_machine_sensor IN (SELECT id FROM contract_machine_sensors WHERE contract = $1)",
+		"DELETE FROM analysis WHERE pipeline IN (SELECT p.id FROM pipelines AS p JOIN contract_machine_sensors cms on p.contract_machine_sensor = cms.id WHERE cms.contract = $1)",
+		"DELETE FROM pipelines WHERE contract_machine_sensor IN (SELECT id FROM contract_machine_sensors WHERE contract = $1)",
+		"UPDATE contract_machine_sensors SET active = false WHERE contract = $1",
+	} {
+		mock.ExpectExec(statement).WithArgs("contract").WillReturnResult(dbMock.NewResult(0, 0))
+	}
+	mock.ExpectQuery("SELECT id FROM machines WHERE id = $1").WithArgs("").WillReturnRows(dbMock.NewRows([]string{"id"}))
+	mock.ExpectExec("INSERT INTO machines (id) VALUES ($1)").WithArgs("").WillReturnResult(dbMock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id FROM systems WHERE name = $1").WithArgs("cloud").WillReturnRows(dbMock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	handler := contractHandler{db: db, system: "cloud"}
+	if err := handler.UpdateContract(update, nil); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("not all expectations were met: %s", err)
+	}
+}
+
+func TestGetContractHistory(t *testing.T) {
+	_, v1 := versionedContract(t, "v1")
+	_, v2 := versionedContract(t, "v2")
+
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mocked database: %s", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectQuery(historyQuery).WithArgs("contract").WillReturnRows(dbMock.NewRows([]string{"contract", "active"}).AddRow(v2, true).AddRow(v1, false))
+
+	handler := contractHandler{db: db}
+	history, err := handler.GetContractHistory("contract")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []ContractVersion{{Version: "v2", Active: true}, {Version: "v1", Active: false}}
+	if !reflect.DeepEqual(history, expected) {
+		t.Errorf("returned history != expected history\n\t%v != %v", history, expected)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("not all expectations were met: %s", err)
+	}
+}
+
+func TestInsertContractMachineSensorActivatesPreviousSensor(t *testing.T) {
+	testTable := []struct {
+		description string
+		rows        *dbMock.Rows
+		expected    int64
+	}{
+		{"sensor of a previous version", dbMock.NewRows([]string{"id"}).AddRow(3), 3},
+		{"new sensor", dbMock.NewRows([]string{"id"}), 4},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("cannot create mock: %s", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("SELECT id FROM contract_machine_sensors WHERE contract = $1 AND machine_sensor = $2").WithArgs("contract", 2).WillReturnRows(v.rows)
+			if v.expected == 3 {
+				mock.ExpectExec("UPDATE contract_machine_sensors SET active = true WHERE id = $1").WithArgs(3).WillReturnResult(dbMock.NewResult(0, 1))
+			} else {
+				mock.ExpectQuery("INSERT INTO contract_machine_sensors (contract, machine_sensor) VALUES ($1, $2) RETURNING id").WithArgs("contract", 2).WillReturnRows(dbMock.NewRows([]string{"id"}).AddRow(4))
+			}
+
+			handler := contractHandler{db: db, system: "cloud"}
+			id, err := handler.insertContractMachineSensor(db, "contract", 2)
+			if err != nil || id != v.expected {
+				t.Errorf("expected %d, got %d (%v)", v.expected, id, err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("not all expectations are met: %s", err)
+			}
+		})
+	}
+}
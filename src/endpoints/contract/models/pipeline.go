@@ -0,0 +1,149 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stageKey identifies a stage of a pipeline by its container, like the models, which are
+// referenced by from and to
+func stageKey(url, tag string) string {
+	return url + ":" + tag
+}
+
+// ValidatePipelines builds the graph of every pipeline of the analysis and returns its problems: sensors, which
+// are not declared in the contract, duplicate stages, references to stages, which are not part of the pipeline,
+// and cycles
+func (c Contract) ValidatePipelines() ValidationErrors {
+	body := c.Body
+	sensors := make(map[string]bool)
+	for _, sensor := range body.Sensors {
+		sensors[sensor.Name] = true
+	}
+
+	var errs ValidationErrors
+	for i, system := range body.Analysis.Systems {
+		for j, pipeline := range system.Pipelines {
+			path := fmt.Sprintf("/body/analysis/systems/%d/pipelines/%d", i, j)
+
+			for k, sensor := range pipeline.Sensors {
+				if !sensors[sensor] {
+					errs = append(errs, ValidationError{
+						Path:    fmt.Sprintf("%s/sensors/%d", path, k),
+						Message: fmt.Sprintf("sensor %s is not declared in the contract", sensor),
+					})
+				}
+			}
+
+			errs = append(errs, validateStages(path+"/pipeline", pipeline.Pipeline)...)
+		}
+	}
+
+	return errs
+}
+
+// validateStages validates the graph of the stages of a single pipeline; an edge leads from a stage to the stages,
+// which are executed after it
+func validateStages(path string, stages []Pipeline) ValidationErrors {
+	var errs ValidationErrors
+
+	index := make(map[string]int)
+	for i, stage := range stages {
+		key := stageKey(stage.Container.Url, stage.Container.Tag)
+		if first, ok := index[key]; ok {
+			errs = append(errs, ValidationError{
+				Path:    fmt.Sprintf("%s/%d/container", path, i),
+				Message: fmt.Sprintf("stage %s is already defined in %s/%d", key, path, first),
+			})
+			continue
+		}
+		index[key] = i
+	}
+
+	// resolve returns the index of the referenced stage
+	resolve := func(i int, field string, model *Model) (int, bool) {
+		if model == nil {
+			return 0, false
+		}
+
+		key := stageKey(model.Url, model.Tag)
+		referenced, ok := index[key]
+		if !ok {
+			errs = append(errs, ValidationError{
+				Path:    fmt.Sprintf("%s/%d/%s", path, i, field),
+				Message: fmt.Sprintf("stage %s is not part of the pipeline", key),
+			})
+		}
+		return referenced, ok
+	}
+
+	edges := make([][]int, len(stages))
+	for i, stage := range stages {
+		if from, ok := resolve(i, "from", stage.From); ok {
+			edges[from] = append(edges[from], i)
+		}
+		if to, ok := resolve(i, "to", stage.To); ok {
+			edges[i] = append(edges[i], to)
+		}
+	}
+
+	if cycle := findCycle(edges); cycle != nil {
+		names := make([]string, 0, len(cycle))
+		for _, i := range cycle {
+			names = append(names, stageKey(stages[i].Container.Url, stages[i].Container.Tag))
+		}
+		errs = append(errs, ValidationError{
+			Path:    fmt.Sprintf("%s/%d", path, cycle[0]),
+			Message: fmt.Sprintf("the stages form a cycle: %s", strings.Join(names, " -> ")),
+		})
+	}
+
+	return errs
+}
+
+// findCycle returns the nodes of a cycle of the graph, which starts and ends with the same node, or nil
+func findCycle(edges [][]int) []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(edges))
+	var stack []int
+
+	var visit func(node int) []int
+	visit = func(node int) []int {
+		state[node] = visiting
+		stack = append(stack, node)
+
+		for _, next := range edges[node] {
+			switch state[next] {
+			case visiting:
+				for i, n := range stack {
+					if n == next {
+						return append(append([]int{}, stack[i:]...), next)
+					}
+				}
+			case unvisited:
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[node] = visited
+		return nil
+	}
+
+	for node := range edges {
+		if state[node] == unvisited {
+			if cycle := visit(node); cycle != nil {
+				return cycle
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// pipelineContract returns a contract with the sensors s1 and s2 and a single pipeline with the given stages
+func pipelineContract(t *testing.T, sensors, stages string) Contract {
+	data := fmt.Sprintf(`{
+  "body": {
+    "sensors": [{"name": "s1"}, {"name": "s2"}],
+    "analysis": {
+      "enable": true,
+      "systems": [{"enable": true, "system": "cloud", "pipelines": [{"ml-trigger": {"type": "event"}, "sensors": %s, "pipeline": %s}]}]
+    }
+  }
+}`, sensors, stages)
+
+	var contract Contract
+	if err := json.Unmarshal([]byte(data), &contract); err != nil {
+		t.Fatalf("cannot parse contract: %s", err)
+	}
+	return contract
+}
+
+func TestContract_ValidatePipelines(t *testing.T) {
+	const path = "/body/analysis/systems/0/pipelines/0"
+
+	testTable := []struct {
+		description string
+		sensors     string
+		stages      string
+		expected    []string
+	}{
+		{
+			"valid chain", `["s1", "s2"]`,
+			`[{"container": {"url": "a", "tag": "1"}, "to": {"url": "b", "tag": "1"}},
+			  {"container": {"url": "b", "tag": "1"}, "from": {"url": "a", "tag": "1"}}]`,
+			nil,
+		},
+		{
+			"undeclared sensor", `["s1", "s3"]`,
+			`[{"container": {"url": "a", "tag": "1"}}]`,
+			[]string{path + "/sensors/1"},
+		},
+		{
+			"duplicate stage", `["s1"]`,
+			`[{"container": {"url": "a", "tag": "1"}}, {"container": {"url": "a", "tag": "1"}}]`,
+			[]string{path + "/pipeline/1/container"},
+		},
+		{
+			"unknown references", `["s1"]`,
+			`[{"container": {"url": "a", "tag": "1"}, "from": {"url": "x", "tag": "1"}, "to": {"url": "a", "tag": "2"}}]`,
+			[]string{path + "/pipeline/0/from", path + "/pipeline/0/to"},
+		},
+		{
+			"cycle", `["s1"]`,
+			`[{"container": {"url": "a", "tag": "1"}, "to": {"url": "b", "tag": "1"}},
+			  {"container": {"url": "b", "tag": "1"}, "to": {"url": "c", "tag": "1"}},
+			  {"container": {"url": "c", "tag": "1"}, "to": {"url": "a", "tag": "1"}}]`,
+			[]string{path + "/pipeline/0"},
+		},
+		{
+			"self reference", `["s1"]`,
+			`[{"container": {"url": "a", "tag": "1"}, "from": {"url": "a", "tag": "1"}}]`,
+			[]string{path + "/pipeline/0"},
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			errs := pipelineContract(t, v.sensors, v.stages).ValidatePipelines()
+
+			var paths []string
+			for _, err := range errs {
+				paths = append(paths, err.Path)
+			}
+
+			if !reflect.DeepEqual(paths, v.expected) {
+				t.Errorf("expected errors at %v, got %v", v.expected, errs)
+			}
+		})
+	}
+}
+
+func TestFindCycle(t *testing.T) {
+	cycle := findCycle([][]int{{1}, {2}, {3}, {1}})
+	if !reflect.DeepEqual(cycle, []int{1, 2, 3, 1}) {
+		t.Errorf("unexpected cycle %v", cycle)
+	}
+
+	if cycle := findCycle([][]int{{1, 2}, {2}, {}}); cycle != nil {
+		t.Errorf("unexpected cycle %v in acyclic graph", cycle)
+	}
+}
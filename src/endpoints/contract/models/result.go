@@ -2,22 +2,179 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/lib/pq"
 	"k8s.io/klog"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
 )
 
+// ErrInvalidFilter will be returned, if the filter of the contract list cannot be parsed
+var ErrInvalidFilter = errors.New("invalid contract filter")
+
+// ContractFilter restricts the list of contracts
+type ContractFilter struct {
+	// State includes only contracts in this state
+	State State
+	// Machine includes only contracts of this machine
+	Machine string
+	// Partner includes only contracts of this partner
+	Partner string
+	// ValidFrom includes only contracts, which are valid after this time
+	ValidFrom time.Time
+	// ValidTo includes only contracts, which are valid before this time
+	ValidTo time.Time
+}
+
+// ParseContractFilter creates a contract filter from the query parameters of a request
+func ParseContractFilter(queryParams map[string][]string) (ContractFilter, error) {
+	var filter ContractFilter
+	for i, v := range queryParams {
+		if len(v) != 1 {
+			return filter, fmt.Errorf("%w: unexpected length of the query parameter %s", ErrInvalidFilter, i)
+		}
+
+		switch i {
+		case "state":
+			switch state := State(v[0]); state {
+			case StateNotYetValid, StateActive, StateExpired, StateDeactivated:
+				filter.State = state
+			default:
+				return filter, fmt.Errorf("%w: unknown state %s", ErrInvalidFilter, v[0])
+			}
+		case "machine":
+			filter.Machine = v[0]
+		case "partner":
+			filter.Partner = v[0]
+		case "validFrom", "validTo":
+			date, err := time.Parse(time.RFC3339, v[0])
+			if err != nil {
+				return filter, fmt.Errorf("%w: %s", ErrInvalidFilter, err)
+			}
+			if i == "validFrom" {
+				filter.ValidFrom = date
+			} else {
+				filter.ValidTo = date
+			}
+		}
+	}
+
+	return filter, nil
+}
+
+// ReadScope describes, on behalf of whom the contracts are listed
+type ReadScope struct {
+	// Organisations read the contracts through their read permissions
+	Organisations []string
+	// Contracts limits the contracts, if it is not empty
+	Contracts []string
+	// Machines limits the machines of the contracts, if it is not empty
+	Machines []string
+}
+
+// ContractSummary is the representation of a contract in the contract list
+type ContractSummary struct {
+	ID       string   `json:"id"`
+	Version  string   `json:"version"`
+	Machine  string   `json:"machine"`
+	Partners []string `json:"partners"`
+	Sensors  []string `json:"sensors"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	State    State    `json:"state"`
+}
+
 type ResultList interface {
-	GetAllContracts(string) ([]string, error)
+	// GetAllContracts returns a page of the contracts, which can be read in the scope, and the cursor of the next page
+	GetAllContracts(ReadScope, ContractFilter, pagination.Page) ([]ContractSummary, string, error)
 }
 
 type resultList struct {
-	db *sql.DB
+	db  *sql.DB
+	now func() time.Time
+}
+
+// stateCondition returns the sql condition, which matches the not deactivated contracts in the state
+// at the time of the parameter. The unset flags are evaluated like their defaults, as GetState does.
+func stateCondition(state State, param int) string {
+	switch state {
+	case StateNotYetValid:
+		return fmt.Sprintf("COALESCE(c.active, true) AND NOT COALESCE(c.expired, false) AND c.start_time > $%d AND (c.end_time IS NULL OR c.end_time >= $%d)", param, param)
+	case StateActive:
+		return fmt.Sprintf("COALESCE(c.active, true) AND NOT COALESCE(c.expired, false) AND (c.start_time IS NULL OR c.start_time <= $%d) AND (c.end_time IS NULL OR c.end_time >= $%d)", param, param)
+	default:
+		return fmt.Sprintf("COALESCE(c.active, true) AND (COALESCE(c.expired, false) OR c.end_time < $%d)", param)
+	}
 }
 
-func (r resultList) GetAllContracts(token string) ([]string, error) {
-	query, err := r.db.Query("SELECT id FROM contracts")
+func (r resultList) GetAllContracts(scope ReadScope, filter ContractFilter, page pagination.Page) ([]ContractSummary, string, error) {
+	now := r.now()
+
+	queryWhere := []string{
+		"c.id IN (SELECT rp.contract FROM read_permissions AS rp JOIN organisations AS o ON o.id = rp.organisation WHERE o.name = ANY($1))",
+	}
+	argWhere := []interface{}{pq.Array(scope.Organisations)}
+
+	if len(scope.Contracts) > 0 {
+		argWhere = append(argWhere, pq.Array(scope.Contracts))
+		queryWhere = append(queryWhere, fmt.Sprintf("c.id = ANY($%d)", len(argWhere)))
+	}
+
+	if len(scope.Machines) > 0 {
+		argWhere = append(argWhere, pq.Array(scope.Machines))
+		queryWhere = append(queryWhere, fmt.Sprintf("c.contract->'body'->>'machine' = ANY($%d)", len(argWhere)))
+	}
+
+	switch filter.State {
+	case "":
+	case StateDeactivated:
+		queryWhere = append(queryWhere, "NOT COALESCE(c.active, true)")
+	default:
+		argWhere = append(argWhere, now)
+		queryWhere = append(queryWhere, stateCondition(filter.State, len(argWhere)))
+	}
+
+	if filter.Machine != "" {
+		argWhere = append(argWhere, filter.Machine)
+		queryWhere = append(queryWhere, fmt.Sprintf("c.id IN (SELECT cms.contract FROM contract_machine_sensors AS cms JOIN machine_sensors AS ms ON ms.id = cms.machine_sensor WHERE cms.active AND ms.machine = $%d)", len(argWhere)))
+	}
+
+	if filter.Partner != "" {
+		argWhere = append(argWhere, filter.Partner)
+		queryWhere = append(queryWhere, fmt.Sprintf("c.id IN (SELECT p.contract FROM partners AS p JOIN organisations AS o ON o.id = p.organisation WHERE o.name = $%d)", len(argWhere)))
+	}
+
+	if !filter.ValidFrom.IsZero() {
+		argWhere = append(argWhere, filter.ValidFrom)
+		queryWhere = append(queryWhere, fmt.Sprintf("(c.end_time IS NULL OR c.end_time >= $%d)", len(argWhere)))
+	}
+
+	if !filter.ValidTo.IsZero() {
+		argWhere = append(argWhere, filter.ValidTo)
+		queryWhere = append(queryWhere, fmt.Sprintf("(c.start_time IS NULL OR c.start_time <= $%d)", len(argWhere)))
+	}
+
+	if condition, args := page.Condition("", "c.id", len(argWhere)+1); condition != "" {
+		queryWhere = append(queryWhere, condition)
+		argWhere = append(argWhere, args...)
+	}
+
+	limits, limitArgs := page.Limits(len(argWhere) + 1)
+
+	where := strings.Join(queryWhere, " AND ")
+	klog.V(2).Infof("WHERE clause: %s\nvalues: %v", where, argWhere)
+
+	query, err := r.db.Query(
+		fmt.Sprintf("SELECT c.id, c.start_time, c.end_time, c.active, c.expired, c.contract FROM contracts AS c WHERE %s %s %s", where, page.OrderBy("", "c.id"), limits),
+		append(argWhere, limitArgs...)...,
+	)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	defer func() {
@@ -26,20 +183,50 @@ func (r resultList) GetAllContracts(token string) ([]string, error) {
 		}
 	}()
 
-	var ids []string
+	contracts := []ContractSummary{}
+	count := 0
 	for query.Next() {
-		var id string
-		err := query.Scan(&id)
-		if err != nil {
-			return nil, err
+		// the additional row shows only, that a next page exists
+		if count++; count > page.Limit {
+			continue
+		}
+
+		var id, data string
+		var start, end sql.NullTime
+		var active, expired sql.NullBool
+		if err := query.Scan(&id, &start, &end, &active, &expired, &data); err != nil {
+			return nil, "", err
+		}
+
+		var contract Contract
+		if err := json.Unmarshal([]byte(data), &contract); err != nil {
+			return nil, "", fmt.Errorf("cannot parse contract %s: %s", id, err)
+		}
+
+		summary := ContractSummary{
+			ID:       id,
+			Version:  contract.Body.Contract.Version,
+			Machine:  contract.Body.Machine,
+			Partners: contract.Body.Contract.Partners,
+			Start:    contract.Body.Contract.Valid.Start,
+			End:      contract.Body.Contract.Valid.End,
+			State:    EvaluateState(start.Time, end.Time, !active.Valid || active.Bool, expired.Bool, now),
 		}
+		for _, sensor := range contract.Body.Sensors {
+			summary.Sensors = append(summary.Sensors, sensor.Name)
+		}
+
+		contracts = append(contracts, summary)
+	}
 
-		ids = append(ids, id)
+	var next string
+	if len(contracts) > 0 {
+		next = page.Next(count, pagination.Cursor{ID: contracts[len(contracts)-1].ID})
 	}
 
-	return ids, nil
+	return contracts, next, nil
 }
 
 func NewResultList(db *sql.DB) ResultList {
-	return resultList{db: db}
+	return resultList{db: db, now: time.Now}
 }
@@ -0,0 +1,233 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	dbMock "github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
+)
+
+func TestParseContractFilter(t *testing.T) {
+	validFrom := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testTable := []struct {
+		description   string
+		queryParams   map[string][]string
+		expected      ContractFilter
+		expectedError error
+	}{
+		{
+			"no filter",
+			map[string][]string{},
+			ContractFilter{},
+			nil,
+		},
+		{
+			"all filters",
+			map[string][]string{"state": {"active"}, "machine": {"m"}, "partner": {"p"}, "validFrom": {"2021-01-01T00:00:00Z"}},
+			ContractFilter{State: StateActive, Machine: "m", Partner: "p", ValidFrom: validFrom},
+			nil,
+		},
+		{
+			"unknown state",
+			map[string][]string{"state": {"unknown"}},
+			ContractFilter{},
+			ErrInvalidFilter,
+		},
+		{
+			"invalid time",
+			map[string][]string{"validTo": {"yesterday"}},
+			ContractFilter{},
+			ErrInvalidFilter,
+		},
+		{
+			"repeated parameter",
+			map[string][]string{"machine": {"a", "b"}},
+			ContractFilter{},
+			ErrInvalidFilter,
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			filter, err := ParseContractFilter(v.queryParams)
+			if !errors.Is(err, v.expectedError) {
+				t.Fatalf("expected error %v, got %v", v.expectedError, err)
+			}
+
+			if err == nil && filter != v.expected {
+				t.Errorf("expected filter %v, got %v", v.expected, filter)
+			}
+		})
+	}
+}
+
+func TestGetAllContracts(t *testing.T) {
+	now := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	columns := []string{"id", "start_time", "end_time", "active", "expired", "contract"}
+	contract := `{"body":{"contract":{"id":"a","version":"v1","partners":["p"],"valid":{"start":"2021-01-01T00:00:00Z","end":"2021-02-01T00:00:00Z"}},"machine":"m","sensors":[{"name":"s1"},{"name":"s2"}]}}`
+
+	baseQuery := "SELECT c.id, c.start_time, c.end_time, c.active, c.expired, c.contract FROM contracts AS c WHERE c.id IN (SELECT rp.contract FROM read_permissions AS rp JOIN organisations AS o ON o.id = rp.organisation WHERE o.name = ANY($1))"
+
+	summary := ContractSummary{
+		ID:       "a",
+		Version:  "v1",
+		Machine:  "m",
+		Partners: []string{"p"},
+		Sensors:  []string{"s1", "s2"},
+		Start:    "2021-01-01T00:00:00Z",
+		End:      "2021-02-01T00:00:00Z",
+		State:    StateActive,
+	}
+
+	defaultPage := pagination.Page{Limit: pagination.DefaultLimit, Order: pagination.Ascending}
+	second := strings.Replace(contract, `"id":"a"`, `"id":"b"`, 1)
+
+	testTable := []struct {
+		description string
+		filter      ContractFilter
+		query       string
+		args        []driver.Value
+		page        pagination.Page
+		rows        *dbMock.Rows
+		expected    []ContractSummary
+		next        string
+	}{
+		{
+			"no filter",
+			ContractFilter{},
+			baseQuery + " ORDER BY c.id ASC LIMIT $2 OFFSET $3",
+			[]driver.Value{"{\"org\"}", pagination.DefaultLimit + 1, 0},
+			defaultPage,
+			dbMock.NewRows(columns).AddRow("a", start, end, true, false, contract),
+			[]ContractSummary{summary},
+			"",
+		},
+		{
+			"no readable contracts",
+			ContractFilter{},
+			baseQuery + " ORDER BY c.id ASC LIMIT $2 OFFSET $3",
+			[]driver.Value{"{\"org\"}", pagination.DefaultLimit + 1, 0},
+			defaultPage,
+			dbMock.NewRows(columns),
+			[]ContractSummary{},
+			"",
+		},
+		{
+			"active contracts of a machine",
+			ContractFilter{State: StateActive, Machine: "m"},
+			baseQuery + " AND COALESCE(c.active, true) AND NOT COALESCE(c.expired, false) AND (c.start_time IS NULL OR c.start_time <= $2) AND (c.end_time IS NULL OR c.end_time >= $2) AND c.id IN (SELECT cms.contract FROM contract_machine_sensors AS cms JOIN machine_sensors AS ms ON ms.id = cms.machine_sensor WHERE cms.active AND ms.machine = $3) ORDER BY c.id ASC LIMIT $4 OFFSET $5",
+			[]driver.Value{"{\"org\"}", now, "m", pagination.DefaultLimit + 1, 0},
+			defaultPage,
+			dbMock.NewRows(columns).AddRow("a", start, end, true, false, contract),
+			[]ContractSummary{summary},
+			"",
+		},
+		{
+			"active contracts without flags",
+			ContractFilter{State: StateActive},
+			baseQuery + " AND COALESCE(c.active, true) AND NOT COALESCE(c.expired, false) AND (c.start_time IS NULL OR c.start_time <= $2) AND (c.end_time IS NULL OR c.end_time >= $2) ORDER BY c.id ASC LIMIT $3 OFFSET $4",
+			[]driver.Value{"{\"org\"}", now, pagination.DefaultLimit + 1, 0},
+			defaultPage,
+			dbMock.NewRows(columns).AddRow("a", start, end, nil, nil, contract),
+			[]ContractSummary{summary},
+			"",
+		},
+		{
+			"deactivated contracts of a partner in a validity range",
+			ContractFilter{State: StateDeactivated, Partner: "p", ValidFrom: start, ValidTo: end},
+			baseQuery + " AND NOT COALESCE(c.active, true) AND c.id IN (SELECT p.contract FROM partners AS p JOIN organisations AS o ON o.id = p.organisation WHERE o.name = $2) AND (c.end_time IS NULL OR c.end_time >= $3) AND (c.start_time IS NULL OR c.start_time <= $4) ORDER BY c.id ASC LIMIT $5 OFFSET $6",
+			[]driver.Value{"{\"org\"}", "p", start, end, pagination.DefaultLimit + 1, 0},
+			defaultPage,
+			dbMock.NewRows(columns).AddRow("a", start, end, false, false, contract),
+			[]ContractSummary{func() ContractSummary { s := summary; s.State = StateDeactivated; return s }()},
+			"",
+		},
+		{
+			"first page",
+			ContractFilter{},
+			baseQuery + " ORDER BY c.id ASC LIMIT $2 OFFSET $3",
+			[]driver.Value{"{\"org\"}", 2, 0},
+			pagination.Page{Limit: 1, Order: pagination.Ascending},
+			dbMock.NewRows(columns).AddRow("a", start, end, true, false, contract).AddRow("b", start, end, true, false, second),
+			[]ContractSummary{summary},
+			pagination.Cursor{ID: "a"}.Encode(),
+		},
+		{
+			"page after cursor in descending order",
+			ContractFilter{},
+			baseQuery + " AND c.id < $2 ORDER BY c.id DESC LIMIT $3 OFFSET $4",
+			[]driver.Value{"{\"org\"}", "b", 2, 0},
+			pagination.Page{Limit: 1, Order: pagination.Descending, Cursor: &pagination.Cursor{ID: "b"}},
+			dbMock.NewRows(columns).AddRow("a", start, end, true, false, contract),
+			[]ContractSummary{summary},
+			"",
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("cannot create mocked database: %s", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery(v.query).WithArgs(v.args...).WillReturnRows(v.rows)
+
+			list := resultList{db: db, now: func() time.Time { return now }}
+			contracts, next, err := list.GetAllContracts(ReadScope{Organisations: []string{"org"}}, v.filter, v.page)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if next != v.next {
+				t.Errorf("expected next cursor %s, got %s", v.next, next)
+			}
+
+			if !reflect.DeepEqual(contracts, v.expected) {
+				t.Errorf("expected contracts %v, got %v", v.expected, contracts)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestGetAllContracts_Scope(t *testing.T) {
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mocked database: %s", err)
+	}
+	defer db.Close()
+
+	// the contracts of an api key are limited to the contracts and the machines of the key
+	mock.ExpectQuery("SELECT c.id, c.start_time, c.end_time, c.active, c.expired, c.contract FROM contracts AS c WHERE c.id IN (SELECT rp.contract FROM read_permissions AS rp JOIN organisations AS o ON o.id = rp.organisation WHERE o.name = ANY($1)) AND c.id = ANY($2) AND c.contract->'body'->>'machine' = ANY($3) ORDER BY c.id ASC LIMIT $4 OFFSET $5").
+		WithArgs("{\"org\"}", "{\"c1\"}", "{\"m1\"}", pagination.DefaultLimit+1, 0).
+		WillReturnRows(dbMock.NewRows([]string{"id", "start_time", "end_time", "active", "expired", "contract"}))
+
+	list := resultList{db: db, now: time.Now}
+	scope := ReadScope{Organisations: []string{"org"}, Contracts: []string{"c1"}, Machines: []string{"m1"}}
+	contracts, _, err := list.GetAllContracts(scope, ContractFilter{}, pagination.Page{Limit: pagination.DefaultLimit, Order: pagination.Ascending})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(contracts) != 0 {
+		t.Errorf("unexpected contracts: %v", contracts)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
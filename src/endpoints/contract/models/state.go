@@ -0,0 +1,192 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// State is the state of a contract, which is derived from the validity window and the activation of the contract
+type State string
+
+const (
+	// StateNotYetValid is the state of a contract, which validity window has not started yet
+	StateNotYetValid State = "notYetValid"
+	// StateActive is the state of a contract, which can be used
+	StateActive State = "active"
+	// StateExpired is the state of a contract, which validity window has ended
+	StateExpired State = "expired"
+	// StateDeactivated is the state of a removed contract
+	StateDeactivated State = "deactivated"
+)
+
+// EvaluateState derives the state of a contract at the time now
+func EvaluateState(start, end time.Time, active, expired bool, now time.Time) State {
+	switch {
+	case !active:
+		return StateDeactivated
+	case expired || (!end.IsZero() && now.After(end)):
+		return StateExpired
+	case !start.IsZero() && now.Before(start):
+		return StateNotYetValid
+	default:
+		return StateActive
+	}
+}
+
+// StatusCode returns the http status code, which is returned, if data of a contract in this state are requested
+func (s State) StatusCode() int {
+	switch s {
+	case StateActive:
+		return http.StatusOK
+	case StateNotYetValid:
+		return http.StatusForbidden
+	case StateExpired:
+		return http.StatusGone
+	default:
+		return http.StatusNotFound
+	}
+}
+
+// StateError will be returned, if a contract cannot be used because of its state
+type StateError struct {
+	Contract string
+	State    State
+}
+
+func (s StateError) Error() string {
+	return fmt.Sprintf("contract %s is in state %s", s.Contract, s.State)
+}
+
+// StateHandler evaluates the states of the contracts
+type StateHandler interface {
+	// GetState returns the actual state of the contract
+	GetState(contract string) (State, error)
+
+	// Check returns a StateError, if the contract is not active
+	Check(contract string) error
+
+	// Expire marks all active contracts, which validity window has ended, as expired and returns the ids
+	// of these contracts. The hook is executed for every expired contract inside the same transaction.
+	Expire(hook ChangeHook) ([]string, error)
+}
+
+type stateHandler struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+func (s stateHandler) GetState(contract string) (State, error) {
+	query, err := s.db.Query("SELECT start_time, end_time, active, expired FROM contracts WHERE id = $1", contract)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	if !query.Next() {
+		return "", ErrContractNotFound
+	}
+
+	var start, end sql.NullTime
+	var active, expired sql.NullBool
+	if err := query.Scan(&start, &end, &active, &expired); err != nil {
+		return "", err
+	}
+
+	// active defaults to true in the database
+	isActive := !active.Valid || active.Bool
+
+	return EvaluateState(start.Time, end.Time, isActive, expired.Bool, s.now()), nil
+}
+
+func (s stateHandler) Check(contract string) error {
+	state, err := s.GetState(contract)
+	if err != nil {
+		return err
+	}
+
+	if state != StateActive {
+		return StateError{Contract: contract, State: state}
+	}
+
+	return nil
+}
+
+func (s stateHandler) Expire(hook ChangeHook) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := s.expire(tx, hook)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			klog.Errorf("cannot rollback transaction: %s", rbErr)
+		}
+		return nil, err
+	}
+
+	return ids, tx.Commit()
+}
+
+func (s stateHandler) expire(tx *sql.Tx, hook ChangeHook) ([]string, error) {
+	contracts, err := s.expiredContracts(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, contract := range contracts {
+		if err := runHook(tx, contract, hook, nil); err != nil {
+			return nil, err
+		}
+		ids = append(ids, contract.Body.Contract.ID)
+	}
+
+	return ids, nil
+}
+
+// expiredContracts marks the contracts as expired and returns them; the rows are read completely,
+// before the hooks are executed in the same transaction
+func (s stateHandler) expiredContracts(tx *sql.Tx) ([]Contract, error) {
+	query, err := tx.Query("UPDATE contracts SET expired = true WHERE active AND NOT expired AND end_time < $1 RETURNING contract", s.now())
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	var contracts []Contract
+	for query.Next() {
+		var contractJson []byte
+		if err := query.Scan(&contractJson); err != nil {
+			return nil, err
+		}
+
+		var contract Contract
+		if err := json.Unmarshal(contractJson, &contract); err != nil {
+			return nil, err
+		}
+		contracts = append(contracts, contract)
+	}
+
+	return contracts, query.Err()
+}
+
+// NewStateHandler creates a new state handler, which reads the contract states from the database
+func NewStateHandler(db *sql.DB) StateHandler {
+	return stateHandler{db: db, now: time.Now}
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	dbMock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEvaluateState(t *testing.T) {
+	now := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	testTable := []struct {
+		description string
+		start       time.Time
+		end         time.Time
+		active      bool
+		expired     bool
+		expected    State
+	}{
+		{"active", start, end, true, false, StateActive},
+		{"no validity window", time.Time{}, time.Time{}, true, false, StateActive},
+		{"not yet valid", end, end.Add(time.Hour), true, false, StateNotYetValid},
+		{"end passed", start.Add(-time.Hour), start, true, false, StateExpired},
+		{"marked as expired", start, end, true, true, StateExpired},
+		{"deactivated", start, end, false, false, StateDeactivated},
+		{"deactivated and expired", start, end, false, true, StateDeactivated},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			if state := EvaluateState(v.start, v.end, v.active, v.expired, now); state != v.expected {
+				t.Errorf("expected state %s, got %s", v.expected, state)
+			}
+		})
+	}
+}
+
+func TestStateHandlerCheck(t *testing.T) {
+	now := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	columns := []string{"start_time", "end_time", "active", "expired"}
+
+	testTable := []struct {
+		description   string
+		rows          *dbMock.Rows
+		expectedError error
+	}{
+		{
+			"active contract",
+			dbMock.NewRows(columns).AddRow(start, end, true, false),
+			nil,
+		},
+		{
+			"unknown contract",
+			dbMock.NewRows(columns),
+			ErrContractNotFound,
+		},
+		{
+			"expired contract",
+			dbMock.NewRows(columns).AddRow(start, start.Add(time.Hour), true, false),
+			StateError{Contract: "contract", State: StateExpired},
+		},
+		{
+			"deactivated contract",
+			dbMock.NewRows(columns).AddRow(start, end, false, false),
+			StateError{Contract: "contract", State: StateDeactivated},
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("cannot create mocked database: %s", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("SELECT start_time, end_time, active, expired FROM contracts WHERE id = $1").WithArgs("contract").WillReturnRows(v.rows)
+
+			states := stateHandler{db: db, now: func() time.Time { return now }}
+			err = states.Check("contract")
+			if !errors.Is(err, v.expectedError) && err != v.expectedError {
+				t.Errorf("expected error %v, got %v", v.expectedError, err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestStateHandlerExpire(t *testing.T) {
+	now := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mocked database: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE contracts SET expired = true WHERE active AND NOT expired AND end_time < $1 RETURNING contract").WithArgs(now).
+		WillReturnRows(dbMock.NewRows([]string{"contract"}).
+			AddRow([]byte(`{"body": {"contract": {"id": "a"}}}`)).
+			AddRow([]byte(`{"body": {"contract": {"id": "b"}}}`)))
+	mock.ExpectCommit()
+
+	var hooked []string
+	hook := func(tx *sql.Tx, contract Contract) error {
+		hooked = append(hooked, contract.Body.Contract.ID)
+		return nil
+	}
+
+	states := stateHandler{db: db, now: func() time.Time { return now }}
+	contracts, err := states.Expire(hook)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(contracts, []string{"a", "b"}) {
+		t.Errorf("expected contracts [a b], got %v", contracts)
+	}
+
+	if !reflect.DeepEqual(hooked, contracts) {
+		t.Errorf("expected the hook to be executed for %v, got %v", contracts, hooked)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStateHandlerExpireHookError(t *testing.T) {
+	now := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mocked database: %s", err)
+	}
+	defer db.Close()
+
+	// the contracts stay unexpired, if an event cannot be queued
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE contracts SET expired = true WHERE active AND NOT expired AND end_time < $1 RETURNING contract").WithArgs(now).
+		WillReturnRows(dbMock.NewRows([]string{"contract"}).AddRow([]byte(`{"body": {"contract": {"id": "a"}}}`)))
+	mock.ExpectRollback()
+
+	states := stateHandler{db: db, now: func() time.Time { return now }}
+	if _, err := states.Expire(func(*sql.Tx, Contract) error { return fmt.Errorf("outbox not available") }); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
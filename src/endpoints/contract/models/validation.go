@@ -0,0 +1,97 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/duration"
+)
+
+// ValidationError is a problem of a contract; path is the JSON pointer of the invalid value
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors are all problems of a contract
+type ValidationErrors []ValidationError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, 0, len(v))
+	for _, err := range v {
+		messages = append(messages, fmt.Sprintf("%s: %s", err.Path, err.Message))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate returns all problems of the contract. The analysis systems and the systems of the technical containers
+// have to be kosmos local systems of the contract or the local system.
+func (c Contract) Validate(localSystem string) ValidationErrors {
+	body := c.Body
+	systems := make(map[string]bool)
+
+	for _, system := range body.KosmosLocalSystems {
+		systems[system] = true
+	}
+
+	systems[localSystem] = true
+
+	var errs ValidationErrors
+	for i, analyse := range body.Analysis.Systems {
+		if !systems[analyse.Name] {
+			errs = append(errs, ValidationError{
+				Path:    fmt.Sprintf("/body/analysis/systems/%d/system", i),
+				Message: fmt.Sprintf("system %s is not a kosmos local system of the contract", analyse.Name),
+			})
+		}
+	}
+
+	for i, container := range body.TechnicalContainers {
+		if !systems[container.System] {
+			errs = append(errs, ValidationError{
+				Path:    fmt.Sprintf("/body/requiredTechnicalContainers/%d/system", i),
+				Message: fmt.Sprintf("system %s is not a kosmos local system of the contract", container.System),
+			})
+		}
+	}
+
+	start, startErr := time.Parse(time.RFC3339, body.Contract.Valid.Start)
+	if startErr != nil {
+		errs = append(errs, timeError("/body/contract/valid/start", body.Contract.Valid.Start))
+	}
+
+	end, endErr := time.Parse(time.RFC3339, body.Contract.Valid.End)
+	if endErr != nil {
+		errs = append(errs, timeError("/body/contract/valid/end", body.Contract.Valid.End))
+	}
+
+	if startErr == nil && endErr == nil && start.After(end) {
+		errs = append(errs, ValidationError{
+			Path:    "/body/contract/valid",
+			Message: fmt.Sprintf("start %s is after end %s", body.Contract.Valid.Start, body.Contract.Valid.End),
+		})
+	}
+
+	if _, err := time.Parse(time.RFC3339, body.Contract.CreationTime); err != nil {
+		errs = append(errs, timeError("/body/contract/creationTime", body.Contract.CreationTime))
+	}
+
+	for i, sensor := range body.Sensors {
+		for j, storage := range sensor.StorageDuration {
+			if _, err := duration.Parse(storage.Duration); err != nil {
+				errs = append(errs, ValidationError{
+					Path:    fmt.Sprintf("/body/sensors/%d/storageDuration/%d/duration", i, j),
+					Message: err.Error(),
+				})
+			}
+		}
+	}
+
+	return append(errs, c.ValidatePipelines()...)
+}
+
+// timeError reports a time, which is not formatted as RFC3339
+func timeError(path, value string) ValidationError {
+	return ValidationError{Path: path, Message: fmt.Sprintf("%q is not a RFC3339 time", value)}
+}
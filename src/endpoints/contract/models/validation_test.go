@@ -0,0 +1,70 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestContract_Validate(t *testing.T) {
+	testTable := []struct {
+		description string
+		contract    string
+		expected    []string
+	}{
+		{
+			"valid",
+			`{"body": {"contract": {"valid": {"start": "2020-01-01T00:00:00Z", "end": "2021-01-01T00:00:00Z"}, "creationTime": "2020-01-01T00:00:00Z"},
+			  "kosmosLocalSystems": ["edge"], "requiredTechnicalContainers": [{"system": "edge"}],
+			  "analysis": {"systems": [{"system": "cloud"}, {"system": "edge"}]}}}`,
+			nil,
+		},
+		{
+			"unknown systems",
+			`{"body": {"contract": {"valid": {"start": "2020-01-01T00:00:00Z", "end": "2021-01-01T00:00:00Z"}, "creationTime": "2020-01-01T00:00:00Z"},
+			  "requiredTechnicalContainers": [{"system": "cloud"}, {"system": "edge"}],
+			  "analysis": {"systems": [{"system": "edge"}]}}}`,
+			[]string{"/body/analysis/systems/0/system", "/body/requiredTechnicalContainers/1/system"},
+		},
+		{
+			"invalid times",
+			`{"body": {"contract": {"valid": {"start": "2020-01-01", "end": ""}, "creationTime": "now"}}}`,
+			[]string{"/body/contract/valid/start", "/body/contract/valid/end", "/body/contract/creationTime"},
+		},
+		{
+			"start after end",
+			`{"body": {"contract": {"valid": {"start": "2021-01-01T00:00:00Z", "end": "2020-01-01T00:00:00Z"}, "creationTime": "2020-01-01T00:00:00Z"}}}`,
+			[]string{"/body/contract/valid"},
+		},
+		{
+			"invalid storage duration",
+			`{"body": {"contract": {"valid": {"start": "2020-01-01T00:00:00Z", "end": "2021-01-01T00:00:00Z"}, "creationTime": "2020-01-01T00:00:00Z"},
+			  "sensors": [{"name": "s1", "storageDuration": [{"systemName": "cloud", "duration": "P30D"}, {"systemName": "edge", "duration": "30 days"}]}]}}`,
+			[]string{"/body/sensors/0/storageDuration/1/duration"},
+		},
+		{
+			"invalid pipeline",
+			`{"body": {"contract": {"valid": {"start": "2020-01-01T00:00:00Z", "end": "2021-01-01T00:00:00Z"}, "creationTime": "2020-01-01T00:00:00Z"},
+			  "analysis": {"systems": [{"system": "cloud", "pipelines": [{"sensors": ["s1"], "pipeline": []}]}]}}}`,
+			[]string{"/body/analysis/systems/0/pipelines/0/sensors/0"},
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			var contract Contract
+			if err := json.Unmarshal([]byte(v.contract), &contract); err != nil {
+				t.Fatalf("cannot parse contract: %s", err)
+			}
+
+			var paths []string
+			for _, err := range contract.Validate("cloud") {
+				paths = append(paths, err.Path)
+			}
+
+			if !reflect.DeepEqual(paths, v.expected) {
+				t.Errorf("expected errors at %v, got %v", v.expected, paths)
+			}
+		})
+	}
+}
@@ -2,128 +2,319 @@ package machineData
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"k8s.io/klog"
 
 	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/auth"
+	contractModels "github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
 	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
 	mqttModels "github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/outbox"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/signature"
 )
 
 type MachineData interface {
 	ServeHTTP(http.ResponseWriter, *http.Request)
 }
 
-func NewMachineDataEndpoint(sendChan chan mqtt.Msg, authHelper auth.Helper, contract Contract) MachineData {
-	return machineData{sendChan: sendChan, auth: authHelper, contr: contract}
+// PipelineTrigger executes the event triggered pipelines, when new data of a sensor arrives
+type PipelineTrigger interface {
+	Trigger(contract, machine, sensor string, data []byte)
+}
+
+// NewMachineDataEndpoint creates the endpoint of the machine data; the messages are queued in the outbox
+// inside the transaction, which stores the updates
+func NewMachineDataEndpoint(topic mqtt.Topic, authHelper auth.Helper, contract Contract, signatures signature.ContractVerifier, states contractModels.StateHandler, updates UpdateMessageHandler, pipelines PipelineTrigger) MachineData {
+	return machineData{messages: outbox.In, topic: topic, auth: authHelper, contr: contract, signatures: signatures, states: states, updates: updates, pipelines: pipelines}
 }
 
 type machineData struct {
-	sendChan chan mqtt.Msg
-	auth     auth.Helper
-	contr    Contract
+	messages   func(tx outbox.Executor) outbox.Outbox
+	topic      mqtt.Topic
+	auth       auth.Helper
+	contr      Contract
+	signatures signature.ContractVerifier
+	states     contractModels.StateHandler
+	updates    UpdateMessageHandler
+	pipelines  PipelineTrigger
 }
 
-func (m machineData) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// handleGet returns the stored updates of a sensor
+func (m machineData) handleGet(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(path) != 4 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	contract, machine, sensor := path[1], path[2], path[3]
 
-	switch r.Method {
-	// handle requests of all other http methods
-	default:
-		w.WriteHeader(405)
-	// handle post requests
-	case "POST":
-		var data []Model
-		var sData mqttModels.MachineData
-		var msg mqtt.Msg
+	authenticated, statusCode, err := m.auth.IsAuthenticated(r, contract, false)
+	if err != nil {
+		klog.Errorf("cannot check authentication: %s", err)
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	if !authenticated {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := m.states.Check(contract); err != nil {
+		var stateErr contractModels.StateError
+		switch {
+		case errors.As(err, &stateErr):
+			w.WriteHeader(stateErr.State.StatusCode())
+		case errors.Is(err, contractModels.ErrContractNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			klog.Errorf("cannot check contract state: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	page, queryParams, err := pagination.Parse(r.URL.Query())
+	if err != nil {
+		klog.Infof("cannot parse pagination: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
-		// read data from body
-		body, err := ioutil.ReadAll(r.Body)
+	var start, end time.Time
+	for name, value := range map[string]*time.Time{"start": &start, "end": &end} {
+		v, ok := queryParams[name]
+		if !ok {
+			continue
+		}
+
+		if len(v) != 1 {
+			klog.Infof("unexpected length of the query parameter %s", name)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if *value, err = time.Parse(time.RFC3339, v[0]); err != nil {
+			klog.Infof("cannot parse %s: %s", name, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	messages, next, err := m.updates.Get(contract, machine, sensor, start, end, page)
+	if err != nil {
+		klog.Errorf("cannot query stored updates: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(messages)
+	if err != nil {
+		klog.Errorf("cannot marshal stored updates: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if next != "" {
+		w.Header().Set(pagination.NextCursorHeader, next)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		klog.Errorf("could not send message %v\n", err)
+	}
+}
+
+// contract returns the first active contract of the sensor of the data, to which the user is permitted
+// to write. If no contract can be used, the status code of the response is returned.
+func (m machineData) contract(r *http.Request, dat Model) (string, int) {
+	contracts, err := m.contr.GetContracts(dat.Body.MachineID, dat.Body.Sensor)
+	if err != nil {
+		klog.Errorf("cannot get contract: %s", err)
+		return "", http.StatusInternalServerError
+	}
+
+	// state of the first authenticated contract, which cannot be used
+	var stateErr contractModels.StateError
+	for _, cont := range contracts {
+		authenticated, statusCode, err := m.auth.IsAuthenticated(r, cont, true)
 		if err != nil {
-			klog.Errorf("could not read data from request")
+			klog.Errorf("cannot check authentication: %s", err)
+			return "", statusCode
+		}
+
+		if !authenticated {
+			continue
+		}
+
+		// only active contracts can receive data
+		if err := m.states.Check(cont); err != nil {
+			if errors.As(err, &stateErr) {
+				klog.Infof("contract %s cannot receive data: %s", cont, err)
+				continue
+			}
+			klog.Errorf("cannot check contract state: %s", err)
+			return "", http.StatusInternalServerError
+		}
+
+		return cont, 0
+	}
+
+	if stateErr.Contract != "" {
+		return "", stateErr.State.StatusCode()
+	}
+
+	klog.Infof("cannot authenticate on any contract of machine %s and sensor %s", dat.Body.MachineID, dat.Body.Sensor)
+	return "", http.StatusUnauthorized
+}
+
+// toMessage converts the data to the payload, which is sent to the mqtt broker
+func toMessage(dat Model) ([]byte, error) {
+	var sData mqttModels.MachineData
+	var columns []mqttModels.Column
+	for _, col := range dat.Body.Columns {
+		column := mqttModels.Column{
+			Name: col.Name,
+			Type: col.Type,
+			Meta: struct {
+				Future      interface{} `json:"future,omitempty"`
+				Unit        string      `json:"unit"`
+				Description string      `json:"description"`
+			}{
+				Future:      col.Meta.Future,
+				Unit:        col.Meta.Unit,
+				Description: col.Meta.Description,
+			},
+		}
+		columns = append(columns, column)
+	}
+	sData.Body.Columns = columns
+	sData.Body.Data = dat.Body.Data
+	sData.Body.Metadata = dat.Body.Metadata
+	sData.Body.Timestamp = dat.Body.Timestamp
+	sData.Signature = dat.Signature
+
+	return json.Marshal(sData)
+}
+
+// handlePost forwards the uploaded data to the mqtt broker. Every item is validated and authorised, before
+// any of them is stored or queued; the items are stored and queued in one transaction.
+func (m machineData) handlePost(w http.ResponseWriter, r *http.Request) {
+	var data []Model
+
+	// read data from body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		klog.Errorf("could not read data from request")
+		w.WriteHeader(400)
+		return
+	}
+
+	// convert body to internal data type
+	if err := json.Unmarshal(body, &data); err != nil {
+		klog.Errorf("could not unmarshal data: %s\n", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	// the signatures are verified against the transmitted bodies
+	rawBodies, err := signature.TransmittedBodies(body)
+	if err != nil {
+		klog.Errorf("could not read the bodies of the data: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+	for i := range data {
+		data[i].RawBody = rawBodies[i]
+	}
+
+	updates := make([]Update, 0, len(data))
+	messages := make([]mqtt.Msg, 0, len(data))
+	payloads := make([][]byte, 0, len(data))
+	for _, dat := range data {
+		if _, err := time.Parse(time.RFC3339, dat.Body.Timestamp); err != nil {
+			klog.Errorf("cannot validate timestamp: %s", err)
 			w.WriteHeader(400)
 			return
 		}
 
-		// convert body to internal data type
-		if err := json.Unmarshal(body, &data); err != nil {
-			klog.Errorf("could not unmarshal data: %s\n", err)
-			w.WriteHeader(400)
+		contract, statusCode := m.contract(r, dat)
+		if contract == "" {
+			w.WriteHeader(statusCode)
 			return
 		}
 
-		// split in multiple mqtt messages
-		for _, dat := range data {
-			var columns []mqttModels.Column
-			for _, col := range dat.Body.Columns {
-				column := mqttModels.Column{
-					Name: col.Name,
-					Type: col.Type,
-					Meta: struct {
-						Future      interface{} `json:"future,omitempty"`
-						Unit        string      `json:"unit"`
-						Description string      `json:"description"`
-					}{
-						Future:      col.Meta.Future,
-						Unit:        col.Meta.Unit,
-						Description: col.Meta.Description,
-					},
-				}
-				columns = append(columns, column)
-			}
-			sData.Body.Columns = columns
-			sData.Body.Data = dat.Body.Data
-			sData.Body.Metadata = dat.Body.Metadata
-			sData.Body.Timestamp = dat.Body.Timestamp
-			sData.Signature = dat.Signature
-
-			if _, err := time.Parse(time.RFC3339, dat.Body.Timestamp); err != nil {
-				klog.Errorf("cannot validate timestamp: %s", err)
-				w.WriteHeader(400)
-				return
+		// validate the signature, if it is required by the contract
+		if err := m.signatures.Verify(contract, dat.SignedBody(), dat.Signature); err != nil {
+			klog.Errorf("cannot verify signature: %s", err)
+			if errors.Is(err, signature.ErrInvalidSignature) {
+				w.WriteHeader(http.StatusForbidden)
+			} else if errors.Is(err, signature.ErrContractNotFound) {
+				w.WriteHeader(http.StatusNotFound)
+			} else {
+				w.WriteHeader(http.StatusInternalServerError)
 			}
+			return
+		}
 
-			authenticated := false
-			var statusCode int
-			var err error
-			contracts, err := m.contr.GetContracts(dat.Body.MachineID, dat.Body.Sensor)
-			if err != nil {
-				klog.Errorf("cannot get contract: %s", err)
-				w.WriteHeader(statusCode)
-				return
-			}
-			for _, cont := range contracts {
-				authenticated, statusCode, err = m.auth.IsAuthenticated(r, cont, true)
-				if err != nil {
-					klog.Errorf("cannot check authentication: %s", err)
-					w.WriteHeader(statusCode)
-					return
-				}
-
-				if authenticated {
-					break
-				}
-			}
+		payload, err := toMessage(dat)
+		if err != nil {
+			klog.Errorf("could not translate to used data: %s\n", err)
+			w.WriteHeader(500)
+			return
+		}
 
-			if !authenticated {
-				klog.Infof("cannot authenticate: %t", authenticated)
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
+		// the machine and sensor have to be single levels of the topic
+		msg, err := m.topic.Msg(payload, map[string]string{"contract": contract, "machine": dat.Body.MachineID, "sensor": dat.Body.Sensor})
+		if err != nil {
+			klog.Infof("cannot create message: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 
-			msg.Topic = fmt.Sprintf("kosmos/machine-data/%s/sensor/%s/update", dat.Body.MachineID, dat.Body.Sensor)
-			msg.Msg, err = json.Marshal(sData)
-			if err != nil {
-				klog.Errorf("could not translate to used data: %s\n", err)
-				w.WriteHeader(500)
-				return
-			}
+		updates = append(updates, Update{Contract: contract, Data: dat})
+		messages = append(messages, msg)
+		payloads = append(payloads, payload)
+	}
 
-			// sending message to mqtt broker
-			m.sendChan <- msg
+	// store the updates, which have a storage duration, and queue the messages, which will be sent to the mqtt broker
+	_, err = m.updates.Insert(updates, func(tx outbox.Executor) error {
+		for _, msg := range messages {
+			if err := m.messages(tx).Enqueue(msg); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		klog.Errorf("cannot store the data: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for i, update := range updates {
+		m.pipelines.Trigger(update.Contract, update.Data.Body.MachineID, update.Data.Body.Sensor, payloads[i])
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (m machineData) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	// handle requests of all other http methods
+	default:
+		w.WriteHeader(405)
+	// handle get requests
+	case "GET":
+		m.handleGet(w, r)
+	// handle post requests
+	case "POST":
+		m.handlePost(w, r)
 	}
 }
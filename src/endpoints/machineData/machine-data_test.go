@@ -0,0 +1,209 @@
+package machineData
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dbMock "github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/auth"
+	contractModels "github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/signature"
+)
+
+// testAuthHelper grants the write permission on the contracts in write
+type testAuthHelper struct {
+	write map[string]bool
+}
+
+func (h testAuthHelper) IsAuthenticated(r *http.Request, contract string, write bool) (bool, int, error) {
+	return h.write[contract], 0, nil
+}
+
+func (testAuthHelper) CreateSession(string, auth.Permissions, time.Time, string, string) error {
+	panic("implement me")
+}
+
+func (testAuthHelper) DeleteSession(string) error {
+	panic("implement me")
+}
+
+func (testAuthHelper) CleanUp() {
+	panic("implement me")
+}
+
+func (testAuthHelper) TokenValid(r *http.Request) (bool, error) {
+	panic("implement me")
+}
+
+func (testAuthHelper) ContractWriteAccess(r *http.Request) (bool, int, error) {
+	panic("implement me")
+}
+
+func (testAuthHelper) ContractDeleteAccess(r *http.Request) (bool, int, error) {
+	panic("implement me")
+}
+
+func (testAuthHelper) AdminAccess(r *http.Request) (bool, int, error) {
+	panic("implement me")
+}
+
+func (testAuthHelper) Organisations(r *http.Request) ([]string, int, error) {
+	panic("implement me")
+}
+
+func (testAuthHelper) ReadScope(r *http.Request) (auth.Scope, int, error) {
+	panic("implement me")
+}
+
+// testContracts returns the contracts of the sensors
+type testContracts map[string][]string
+
+func (c testContracts) GetContracts(machine, sensor string) ([]string, error) {
+	return c[sensor], nil
+}
+
+type testVerifier struct{}
+
+func (testVerifier) Verify(contract string, body interface{}, sig signature.Signature) error {
+	return nil
+}
+
+type testStateHandler struct{}
+
+func (testStateHandler) GetState(contract string) (contractModels.State, error) {
+	panic("implement me")
+}
+
+func (testStateHandler) Check(contract string) error {
+	return nil
+}
+
+func (testStateHandler) Expire(hook contractModels.ChangeHook) ([]string, error) {
+	panic("implement me")
+}
+
+// testExecutor counts the executed statements
+type testExecutor struct {
+	statements int
+}
+
+func (e *testExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	e.statements++
+	return dbMock.NewResult(1, 1), nil
+}
+
+// testUpdates records the inserted updates and the statements of the hook
+type testUpdates struct {
+	inserted []Update
+	executor testExecutor
+}
+
+func (u *testUpdates) Insert(updates []Update, hook InsertHook) (int, error) {
+	u.inserted = append(u.inserted, updates...)
+	return len(updates), hook(&u.executor)
+}
+
+func (u *testUpdates) Get(contract, machine, sensor string, start, end time.Time, page pagination.Page) ([]UpdateMessage, string, error) {
+	panic("implement me")
+}
+
+// testTrigger records the sensors, which have triggered the pipelines
+type testTrigger struct {
+	sensors []string
+}
+
+func (t *testTrigger) Trigger(contract, machine, sensor string, data []byte) {
+	t.sensors = append(t.sensors, sensor)
+}
+
+func TestMachineData_Post(t *testing.T) {
+	// the second item is uploaded for the sensor of the test case
+	data := `[
+		{"body": {"machineID": "m", "sensor": "s1", "timestamp": "2020-09-23T10:24:55Z"}, "signature": ""},
+		{"body": {"machineID": "m", "sensor": %q, "timestamp": "2020-09-23T10:24:56Z"}, "signature": ""}
+	]`
+
+	testTable := []struct {
+		description string
+		sensor      string
+		contracts   testContracts
+		statusCode  int
+		stored      int
+	}{
+		{
+			"all items are stored",
+			"s2",
+			testContracts{"s1": {"c1"}, "s2": {"c2", "c1"}},
+			http.StatusAccepted,
+			2,
+		},
+		{
+			"an item without a writable contract",
+			"s2",
+			testContracts{"s1": {"c1"}, "s2": {"c2"}},
+			http.StatusUnauthorized,
+			0,
+		},
+		{
+			"an item of a sensor, which is not a single topic level",
+			"s/#",
+			testContracts{"s1": {"c1"}, "s/#": {"c1"}},
+			http.StatusBadRequest,
+			0,
+		},
+		{
+			"an item of a sensor without contract",
+			"s2",
+			testContracts{"s1": {"c1"}},
+			http.StatusUnauthorized,
+			0,
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			updates := &testUpdates{}
+			trigger := &testTrigger{}
+			endpoint := NewMachineDataEndpoint(
+				mqtt.Topic{Template: "kosmos/machine-data/{contract}/{machine}/{sensor}"},
+				testAuthHelper{write: map[string]bool{"c1": true}},
+				v.contracts,
+				testVerifier{},
+				testStateHandler{},
+				updates,
+				trigger,
+			)
+
+			req, err := http.NewRequest("POST", "/machine-data", strings.NewReader(fmt.Sprintf(data, v.sensor)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			endpoint.ServeHTTP(rr, req)
+
+			if rr.Code != v.statusCode {
+				t.Errorf("handler returnes wrong status code: got %d want %d", rr.Code, v.statusCode)
+			}
+
+			// either all or none of the items are stored, queued and trigger the pipelines
+			if len(updates.inserted) != v.stored || updates.executor.statements != v.stored || len(trigger.sensors) != v.stored {
+				t.Errorf("expected %d stored items, got %d updates, %d queued messages and %d triggers", v.stored, len(updates.inserted), updates.executor.statements, len(trigger.sensors))
+			}
+
+			for _, update := range updates.inserted {
+				if update.Contract != "c1" {
+					t.Errorf("update of sensor %s is stored in contract %s", update.Data.Body.Sensor, update.Contract)
+				}
+			}
+		})
+	}
+}
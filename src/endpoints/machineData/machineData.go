@@ -1,5 +1,11 @@
 package machineData
 
+import (
+	"encoding/json"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/signature"
+)
+
 type Model struct {
 	Body struct {
 		MachineID string `json:"machineID"`
@@ -22,5 +28,16 @@ type Model struct {
 			Value       string `json:"value"`
 		} `json:"meta"`
 	} `json:"body"`
-	Signature string `json:"signature"`
+	Signature signature.Signature `json:"signature"`
+	// RawBody is the body as it is transmitted by the client
+	RawBody json.RawMessage `json:"-"`
+}
+
+// SignedBody returns the body, which is covered by the signature. This is the transmitted body, if the model
+// has been received.
+func (m Model) SignedBody() interface{} {
+	if m.RawBody != nil {
+		return m.RawBody
+	}
+	return m.Body
 }
@@ -0,0 +1,199 @@
+package machineData
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/outbox"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
+)
+
+// UpdateMessage is a stored sensor update
+type UpdateMessage struct {
+	ID        int64           `json:"id"`
+	Timestamp string          `json:"timestamp"`
+	Columns   json.RawMessage `json:"columns"`
+	Data      json.RawMessage `json:"data"`
+	Metadata  json.RawMessage `json:"meta"`
+}
+
+// Update is a sensor update, which is uploaded to a contract
+type Update struct {
+	Contract string
+	Data     Model
+}
+
+// InsertHook is executed inside the transaction, which stores the updates; if it fails, no update is stored
+type InsertHook func(tx outbox.Executor) error
+
+// UpdateMessageHandler stores the sensor updates of the contracts
+type UpdateMessageHandler interface {
+	// Insert stores the sensor updates in one transaction, together with the changes of the hook. An update
+	// is only stored, if the contract defines a storage duration of the sensor for the system of the connector.
+	// It returns the count of the stored updates.
+	Insert(updates []Update, hook InsertHook) (int, error)
+
+	// Get returns a page of the stored updates of a sensor between start and end, which can be zero,
+	// and the cursor of the next page
+	Get(contract, machine, sensor string, start, end time.Time, page pagination.Page) ([]UpdateMessage, string, error)
+}
+
+// NewUpdateMessageHandler creates a new handler, which stores the sensor updates of the system in the database
+func NewUpdateMessageHandler(db *sql.DB, system string) UpdateMessageHandler {
+	return updateMessageHandler{db: db, system: system}
+}
+
+type updateMessageHandler struct {
+	db     *sql.DB
+	system string
+}
+
+func (u updateMessageHandler) Insert(updates []Update, hook InsertHook) (int, error) {
+	tx, err := u.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	stored, err := u.insert(tx, updates, hook)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			klog.Errorf("cannot rollback transaction: %s", rbErr)
+		}
+		return 0, err
+	}
+
+	return stored, tx.Commit()
+}
+
+func (u updateMessageHandler) insert(tx *sql.Tx, updates []Update, hook InsertHook) (int, error) {
+	stored := 0
+	for _, update := range updates {
+		ok, err := u.insertUpdate(tx, update.Contract, update.Data)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			stored++
+		}
+	}
+
+	if hook != nil {
+		if err := hook(tx); err != nil {
+			return 0, err
+		}
+	}
+
+	return stored, nil
+}
+
+// insertUpdate stores the sensor update, if the contract defines a storage duration of the sensor for the
+// system of the connector. It returns, whether the update has been stored.
+func (u updateMessageHandler) insertUpdate(tx *sql.Tx, contract string, data Model) (bool, error) {
+	columns, err := json.Marshal(data.Body.Columns)
+	if err != nil {
+		return false, err
+	}
+
+	values, err := json.Marshal(data.Body.Data)
+	if err != nil {
+		return false, err
+	}
+
+	meta, err := json.Marshal(data.Body.Metadata)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := tx.Exec(
+		"INSERT INTO update_message (contract_machine_sensor, time, meta, columns, data) SELECT cms.id, $5, $6, $7, $8 FROM contract_machine_sensors AS cms JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id JOIN storage_duration sd on sd.contract_machine_sensor = cms.id JOIN systems sy on sd.system = sy.id WHERE cms.contract = $1 AND cms.active AND ms.machine = $2 AND s.transmitted_id = $3 AND sy.name = $4 LIMIT 1",
+		contract,
+		data.Body.MachineID,
+		data.Body.Sensor,
+		u.system,
+		data.Body.Timestamp,
+		string(meta),
+		string(columns),
+		string(values),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (u updateMessageHandler) Get(contract, machine, sensor string, start, end time.Time, page pagination.Page) ([]UpdateMessage, string, error) {
+	queryWhere := []string{"cms.contract = $1", "ms.machine = $2", "s.transmitted_id = $3"}
+	argWhere := []interface{}{contract, machine, sensor}
+
+	if !start.IsZero() {
+		argWhere = append(argWhere, start)
+		queryWhere = append(queryWhere, fmt.Sprintf("um.time >= $%d", len(argWhere)))
+	}
+
+	if !end.IsZero() {
+		argWhere = append(argWhere, end)
+		queryWhere = append(queryWhere, fmt.Sprintf("um.time <= $%d", len(argWhere)))
+	}
+
+	if condition, args := page.Condition("um.time", "um.id", len(argWhere)+1); condition != "" {
+		queryWhere = append(queryWhere, condition)
+		argWhere = append(argWhere, args...)
+	}
+
+	limits, limitArgs := page.Limits(len(argWhere) + 1)
+
+	query, err := u.db.Query(
+		fmt.Sprintf("SELECT um.id, um.time, um.columns, um.data, um.meta FROM update_message AS um JOIN contract_machine_sensors cms on um.contract_machine_sensor = cms.id JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE %s %s %s", strings.Join(queryWhere, " AND "), page.OrderBy("um.time", "um.id"), limits),
+		append(argWhere, limitArgs...)...,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	messages := []UpdateMessage{}
+	var last time.Time
+	count := 0
+	for query.Next() {
+		// the additional row shows only, that a next page exists
+		if count++; count > page.Limit {
+			continue
+		}
+
+		var message UpdateMessage
+		var columns, data, meta string
+		if err := query.Scan(&message.ID, &last, &columns, &data, &meta); err != nil {
+			return nil, "", err
+		}
+
+		message.Timestamp = last.Format(time.RFC3339Nano)
+		message.Columns = json.RawMessage(columns)
+		message.Data = json.RawMessage(data)
+		message.Metadata = json.RawMessage(meta)
+		messages = append(messages, message)
+	}
+
+	var next string
+	if len(messages) > 0 {
+		next = page.Next(count, pagination.Cursor{Time: last, ID: strconv.FormatInt(messages[len(messages)-1].ID, 10)})
+	}
+
+	return messages, next, nil
+}
@@ -0,0 +1,169 @@
+package machineData
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	dbMock "github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/outbox"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/pagination"
+)
+
+func TestUpdateMessageHandler_Insert(t *testing.T) {
+	var data Model
+	data.Body.MachineID = "machine"
+	data.Body.Sensor = "sensor"
+	data.Body.Timestamp = "2020-09-23T10:24:55Z"
+	data.Body.Data = [][]string{{"1"}}
+
+	msg := mqtt.Msg{Topic: "topic", Msg: []byte("payload"), QoS: 1}
+
+	testTable := []struct {
+		description string
+		result      driver.Result
+		queueErr    error
+		stored      int
+	}{
+		{"stored", dbMock.NewResult(1, 1), nil, 1},
+		{"no storage duration", dbMock.NewResult(0, 0), nil, 0},
+		{"message cannot be queued", dbMock.NewResult(1, 1), fmt.Errorf("error"), 0},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("cannot create mocked database: %s", err)
+			}
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO update_message (contract_machine_sensor, time, meta, columns, data) SELECT cms.id, $5, $6, $7, $8 FROM contract_machine_sensors AS cms JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id JOIN storage_duration sd on sd.contract_machine_sensor = cms.id JOIN systems sy on sd.system = sy.id WHERE cms.contract = $1 AND cms.active AND ms.machine = $2 AND s.transmitted_id = $3 AND sy.name = $4 LIMIT 1").
+				WithArgs("contract", "machine", "sensor", "cloud", "2020-09-23T10:24:55Z", "null", "null", `[["1"]]`).
+				WillReturnResult(v.result)
+
+			// the message is queued in the transaction of the update
+			queue := mock.ExpectExec("INSERT INTO outbox (topic, payload, qos, retain) VALUES ($1, $2, $3, $4)").
+				WithArgs("topic", []byte("payload"), 1, false)
+			if v.queueErr != nil {
+				queue.WillReturnError(v.queueErr)
+				mock.ExpectRollback()
+			} else {
+				queue.WillReturnResult(dbMock.NewResult(1, 1))
+				mock.ExpectCommit()
+			}
+
+			stored, err := NewUpdateMessageHandler(db, "cloud").Insert([]Update{{Contract: "contract", Data: data}}, func(tx outbox.Executor) error {
+				return outbox.In(tx).Enqueue(msg)
+			})
+			if !errors.Is(err, v.queueErr) {
+				t.Fatalf("returned error != expected error\n\t%s != %s", err, v.queueErr)
+			}
+
+			if stored != v.stored {
+				t.Errorf("expected %d stored updates, got %d", v.stored, stored)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestUpdateMessageHandler_Get(t *testing.T) {
+	first := time.Date(2020, 9, 23, 10, 24, 55, 0, time.UTC)
+	second := first.Add(time.Minute)
+	columns := []string{"id", "time", "columns", "data", "meta"}
+	selectQuery := "SELECT um.id, um.time, um.columns, um.data, um.meta FROM update_message AS um JOIN contract_machine_sensors cms on um.contract_machine_sensor = cms.id JOIN machine_sensors ms on cms.machine_sensor = ms.id JOIN sensors s on ms.sensor = s.id WHERE cms.contract = $1 AND ms.machine = $2 AND s.transmitted_id = $3"
+
+	message := UpdateMessage{
+		ID:        4,
+		Timestamp: "2020-09-23T10:24:55Z",
+		Columns:   json.RawMessage(`[]`),
+		Data:      json.RawMessage(`[["1"]]`),
+		Metadata:  json.RawMessage(`null`),
+	}
+
+	testTable := []struct {
+		description string
+		start       time.Time
+		end         time.Time
+		page        pagination.Page
+		query       string
+		args        []driver.Value
+		rows        *dbMock.Rows
+		expected    []UpdateMessage
+		next        string
+	}{
+		{
+			"first page",
+			time.Time{},
+			time.Time{},
+			pagination.Page{Limit: 1, Order: pagination.Ascending},
+			selectQuery + " ORDER BY um.time ASC, um.id ASC LIMIT $4 OFFSET $5",
+			[]driver.Value{"contract", "machine", "sensor", 2, 0},
+			dbMock.NewRows(columns).AddRow(4, first, "[]", `[["1"]]`, "null").AddRow(5, second, "[]", `[["2"]]`, "null"),
+			[]UpdateMessage{message},
+			pagination.Cursor{Time: first, ID: "4"}.Encode(),
+		},
+		{
+			"time range",
+			first,
+			second,
+			pagination.Page{Limit: 10, Order: pagination.Descending},
+			selectQuery + " AND um.time >= $4 AND um.time <= $5 ORDER BY um.time DESC, um.id DESC LIMIT $6 OFFSET $7",
+			[]driver.Value{"contract", "machine", "sensor", first, second, 11, 0},
+			dbMock.NewRows(columns).AddRow(4, first, "[]", `[["1"]]`, "null"),
+			[]UpdateMessage{message},
+			"",
+		},
+		{
+			"no updates",
+			time.Time{},
+			time.Time{},
+			pagination.Page{Limit: 10, Order: pagination.Ascending},
+			selectQuery + " ORDER BY um.time ASC, um.id ASC LIMIT $4 OFFSET $5",
+			[]driver.Value{"contract", "machine", "sensor", 11, 0},
+			dbMock.NewRows(columns),
+			[]UpdateMessage{},
+			"",
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			db, mock, err := dbMock.New(dbMock.QueryMatcherOption(dbMock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("cannot create mocked database: %s", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery(v.query).WithArgs(v.args...).WillReturnRows(v.rows)
+
+			messages, next, err := NewUpdateMessageHandler(db, "cloud").Get("contract", "machine", "sensor", v.start, v.end, v.page)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(messages, v.expected) {
+				t.Errorf("expected messages %v, got %v", v.expected, messages)
+			}
+
+			if next != v.next {
+				t.Errorf("expected next cursor %s, got %s", v.next, next)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
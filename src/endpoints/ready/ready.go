@@ -1,11 +1,63 @@
 package ready
 
 import (
+	"encoding/json"
 	"net/http"
+	"sync"
+
+	"k8s.io/klog"
 )
 
-type Ready struct{}
+// Ready reports, whether all components of the application are ready
+type Ready struct {
+	mutex  sync.Mutex
+	states map[string]bool
+}
+
+// NewReady creates a readiness endpoint without any components; it is ready, until a component reports otherwise
+func NewReady() *Ready {
+	return &Ready{states: make(map[string]bool)}
+}
+
+// SetReady sets the state of a component, e.g. of the connection to the mqtt broker
+func (re *Ready) SetReady(component string, ready bool) {
+	re.mutex.Lock()
+	defer re.mutex.Unlock()
+	re.states[component] = ready
+}
+
+// notReady returns the components, which are not ready
+func (re *Ready) notReady() []string {
+	re.mutex.Lock()
+	defer re.mutex.Unlock()
+
+	components := []string{}
+	for component, ready := range re.states {
+		if !ready {
+			components = append(components, component)
+		}
+	}
+	return components
+}
+
+func (re *Ready) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	components := re.notReady()
+	if len(components) == 0 {
+		w.WriteHeader(200)
+		return
+	}
+
+	data, err := json.Marshal(struct {
+		NotReady []string `json:"notReady"`
+	}{components})
+	if err != nil {
+		klog.Errorf("cannot marshal readiness: %s", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
 
-func (re Ready) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(200)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if _, err := w.Write(data); err != nil {
+		klog.Errorf("could not send message %v\n", err)
+	}
 }
@@ -0,0 +1,40 @@
+package ready
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReady_ServeHTTP(t *testing.T) {
+	testTable := []struct {
+		description string
+		states      map[string]bool
+		statusCode  int
+		body        string
+	}{
+		{"no components", map[string]bool{}, http.StatusOK, ""},
+		{"connected", map[string]bool{"mqtt": true}, http.StatusOK, ""},
+		{"disconnected", map[string]bool{"mqtt": false}, http.StatusServiceUnavailable, `{"notReady":["mqtt"]}`},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			re := NewReady()
+			for component, ready := range v.states {
+				re.SetReady(component, ready)
+			}
+
+			rec := httptest.NewRecorder()
+			re.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+			if rec.Code != v.statusCode {
+				t.Errorf("expected status code %d, got %d", v.statusCode, rec.Code)
+			}
+
+			if rec.Body.String() != v.body {
+				t.Errorf("expected body %s, got %s", v.body, rec.Body.String())
+			}
+		})
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -21,6 +26,10 @@ import (
 	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/machineData"
 	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/ready"
 	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/outbox"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/retention"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/scheduler"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/signature"
 )
 
 var cli struct {
@@ -62,6 +71,107 @@ func dbVersion(db *sql.DB) {
 	klog.Infof("database version string: %s", versionString)
 }
 
+// shutdownTimeout is the maximal time to finish the pending requests and mqtt publishes on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// parseDuration parses the configured duration; an empty value results in the default duration
+func parseDuration(name, value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		klog.Errorf("cannot parse %s: %s", name, err)
+		os.Exit(1)
+	}
+	return duration
+}
+
+// newTopic creates the topic definition of a kind of messages
+func newTopic(name string, topic config.Topic, defaultTemplate string) mqtt.Topic {
+	t, err := mqtt.NewTopic(topic.Template, defaultTemplate, topic.QoS, topic.Retain)
+	if err != nil {
+		klog.Errorf("invalid topic configuration of %s: %s", name, err)
+		os.Exit(1)
+	}
+	return t
+}
+
+const (
+	// mechanismSession authenticates the users with a session token, which is created by the oidc login
+	mechanismSession = "session"
+	// mechanismJWT authenticates the users and services with a bearer token of the user management
+	mechanismJWT = "jwt"
+)
+
+// parseMechanisms returns the configured authentication mechanisms; without configuration sessions are used
+func parseMechanisms(configured []string) map[string]bool {
+	if len(configured) == 0 {
+		configured = []string{mechanismSession}
+	}
+
+	mechanisms := make(map[string]bool)
+	for _, mechanism := range configured {
+		if mechanism != mechanismSession && mechanism != mechanismJWT {
+			klog.Errorf("unknown authentication mechanism %s", mechanism)
+			os.Exit(1)
+		}
+		mechanisms[mechanism] = true
+	}
+	return mechanisms
+}
+
+// parseCapabilities returns the capabilities of the names
+func parseCapabilities(names []string) []auth.Capability {
+	capabilities := make([]auth.Capability, 0, len(names))
+	for _, name := range names {
+		capability, err := auth.ParseCapability(name)
+		if err != nil {
+			klog.Errorf("cannot parse claim mapping: %s", err)
+			os.Exit(1)
+		}
+		capabilities = append(capabilities, capability)
+	}
+	return capabilities
+}
+
+// parseClaimMapping returns the mapping of the claims to the permissions; options, which are not configured,
+// are taken from the default mapping
+func parseClaimMapping(conf config.Configurations) auth.ClaimMapping {
+	claims := conf.UserMgmt.Claims
+	mapping := auth.DefaultClaimMapping()
+
+	if claims.Groups != "" {
+		mapping.GroupsClaim = claims.Groups
+	}
+	if claims.Roles != "" {
+		mapping.RolesClaim = claims.Roles
+	}
+	mapping.GroupPrefix = claims.GroupPrefix
+
+	if claims.GroupPattern != "" {
+		pattern, err := regexp.Compile(claims.GroupPattern)
+		if err != nil {
+			klog.Errorf("cannot parse group pattern: %s", err)
+			os.Exit(1)
+		}
+		mapping.GroupPattern = pattern
+	}
+
+	if claims.Capabilities != nil {
+		mapping.Capabilities = make(map[string][]auth.Capability)
+		for role, names := range claims.Capabilities {
+			mapping.Capabilities[role] = parseCapabilities(names)
+		}
+	}
+	if claims.DefaultCapabilities != nil {
+		mapping.DefaultCapabilities = parseCapabilities(claims.DefaultCapabilities)
+	}
+
+	return mapping
+}
+
 func main() {
 	flag.Parse()
 
@@ -96,21 +206,37 @@ func main() {
 
 	dbVersion(db)
 
+	readyHandler := ready.NewReady()
+
 	var mqttCo mqtt.Mqtt
 	mqttCon := &mqttCo
-	sendChan := make(chan mqtt.Msg, 100)
-	er := make(chan error)
-	if err := mqttCon.Init(pas.Mqtt.User, pas.Mqtt.Password, conf.Mqtt.Address, conf.Mqtt.Port, false, sendChan, er); err != nil {
+	mqttOptions := mqtt.Options{
+		ClientID:             conf.Mqtt.ClientID,
+		KeepAlive:            parseDuration("mqtt keepalive", conf.Mqtt.KeepAlive, 30*time.Second),
+		MaxReconnectInterval: parseDuration("mqtt max reconnect interval", conf.Mqtt.MaxReconnectInterval, time.Minute),
+		OnStateChange: func(connected bool) {
+			readyHandler.SetReady("mqtt", connected)
+		},
+		TLS: mqtt.TLSConfig{
+			Enabled:    conf.Mqtt.TLS.Enabled,
+			CAFile:     conf.Mqtt.TLS.CA,
+			CertFile:   conf.Mqtt.TLS.ClientCert,
+			KeyFile:    pas.Mqtt.ClientKey,
+			ServerName: conf.Mqtt.TLS.ServerName,
+		},
+	}
+	if err := mqttCon.Init(pas.Mqtt.User, pas.Mqtt.Password, conf.Mqtt.Address, conf.Mqtt.Port, mqttOptions); err != nil {
 		klog.Errorf("cannot connect to mqtt broker: %s", err)
 		os.Exit(1)
 	}
 
-	go func() {
-		for {
-			e := <-er
-			klog.Errorf("%v", e)
-		}
-	}()
+	machineDataTopic := newTopic("machine data", conf.Mqtt.Topics.MachineData, mqtt.DefaultMachineDataTopic)
+	contractEventTopic := newTopic("contract events", conf.Mqtt.Topics.ContractEvents, mqtt.DefaultContractEventTopic)
+	analysisResultTopic := newTopic("analysis results", conf.Mqtt.Topics.AnalysisResults, "")
+	executionRequestTopic := newTopic("execution requests", conf.Mqtt.Topics.ExecutionRequests, mqtt.DefaultExecutionRequestTopic)
+
+	messages := outbox.NewOutbox(db)
+	go outbox.NewDispatcher(db, mqttCon, time.Second).Run()
 
 	klog.Infof("setting up logic")
 	/*
@@ -124,38 +250,106 @@ func main() {
 	//modelLogic.Model(db)
 	//cont.Contract(db)
 
-	authHelper := auth.NewAuthHelper(db, "contract_create")
+	mechanisms := parseMechanisms(conf.UserMgmt.Mechanisms)
+	claimMapping := parseClaimMapping(conf)
 
-	go authHelper.CleanUp()
+	var authHelper auth.Helper
+	var authHandler auth.Auth
+	if mechanisms[mechanismSession] {
+		tokenCipher, err := auth.NewTokenCipher(pas.UserMgmt.SessionKey)
+		if err != nil {
+			klog.Errorf("cannot create cipher of the refresh tokens: %s", err)
+			os.Exit(1)
+		}
+		if pas.UserMgmt.SessionKey == "" {
+			klog.Warningf("no session key is configured, the sessions cannot be refreshed")
+		}
 
-	authHandler, err := auth.NewOidcAuth(conf.UserMgmt.UserMgmt, "auth", pas.UserMgmt.ClientSecret, pas.UserMgmt.ClientId, conf.UserMgmt.ServerAddress, authHelper)
-	if err != nil {
-		klog.Errorf("cannot create new oidc handler: %s", err)
-		os.Exit(1)
+		authHelper = auth.NewAuthHelper(db, tokenCipher)
+		go authHelper.CleanUp()
+
+		loginStates := auth.NewLoginStateStore(db)
+		go loginStates.CleanUp()
+
+		authHandler, err = auth.NewOidcAuth(conf.UserMgmt.UserMgmt, "auth", pas.UserMgmt.ClientSecret, pas.UserMgmt.ClientId, conf.UserMgmt.ServerAddress, authHelper, loginStates, auth.NewSessionStore(db, tokenCipher, parseDuration("max session lifetime", conf.UserMgmt.MaxSessionLifetime, 24*time.Hour)), claimMapping, conf.UserMgmt.Redirects)
+		if err != nil {
+			klog.Errorf("cannot create new oidc handler: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	if mechanisms[mechanismJWT] {
+		audience := conf.UserMgmt.Audience
+		if audience == "" {
+			audience = pas.UserMgmt.ClientId
+		}
+
+		verifier, err := auth.NewClaimsVerifier(conf.UserMgmt.UserMgmt, audience)
+		if err != nil {
+			klog.Errorf("cannot create verifier of bearer tokens: %s", err)
+			os.Exit(1)
+		}
+		authHelper = auth.NewJWTHelper(db, verifier, claimMapping, authHelper)
 	}
 
+	apiKeyStore := auth.NewAPIKeyStore(db)
+	authHelper = auth.NewAPIKeyHelper(apiKeyStore, authHelper)
+	apiKeyHandler := auth.NewAPIKeyEndpoint(apiKeyStore, authHelper)
+
 	contractMachineDataHandler := machineData.NewPsqlContract(db)
 
 	klog.Infof("define endpoints")
-	machineHandler := machineData.NewMachineDataEndpoint(sendChan, authHelper, contractMachineDataHandler)
+	signatureVerifier := signature.NewVerifier(signature.NewFileKeyStore(conf.Signature.KeyStore))
+	contractSignatureVerifier := signature.NewContractVerifier(db, signatureVerifier)
+	contractStateHandler := contractModel.NewStateHandler(db)
+	contractHandleWorker := contractModel.NewContractHandler(db, "cloud")
+	contractEvents := contract.NewEventPublisher(contractEventTopic)
+	go contract.NewExpiryWatcher(contractStateHandler, contractEvents, time.Minute).Run()
+
+	retentionInterval := parseDuration("retention interval", conf.Retention.Interval, time.Hour)
+	go retention.NewWorker(db, "cloud", retentionInterval, conf.Retention.DryRun).Run()
+
+	executionStore := scheduler.NewExecutionStore(db)
+	pipelineScheduler := scheduler.NewScheduler(executionStore, "cloud", messages, executionRequestTopic, parseDuration("scheduler interval", conf.Scheduler.Interval, 10*time.Second))
+	go pipelineScheduler.Run()
+
+	updateMessageHandler := machineData.NewUpdateMessageHandler(db, "cloud")
+	machineHandler := machineData.NewMachineDataEndpoint(machineDataTopic, authHelper, contractMachineDataHandler, contractSignatureVerifier, contractStateHandler, updateMessageHandler, pipelineScheduler)
 
 	analysisHandler := analysisModel.NewAnalysisHandler(db)
 	analysisResultListHandler := analysisModel.NewResultList(db)
-	analysisLogic := analysis.NewAnalyseLogic(analysisResultListHandler, analysisHandler)
-	analysisEndpoint := analysis.NewAnalysisEndpoint(analysisLogic, authHelper)
+	analysisLogic := analysis.NewAnalyseLogic(analysisResultListHandler, analysisHandler, contractSignatureVerifier, contractStateHandler, analysis.NewBroker())
+	analysisEndpoint := analysis.NewAnalysisEndpoint(analysisLogic, authHelper, messages, analysisResultTopic)
+
+	// republished results, which match the subscription of the ingested results, would be ingested again
+	if analysisResultTopic.Overlaps(conf.Mqtt.ResultTopic) {
+		klog.Errorf("the topic %s of the analysis results overlaps the subscribed result topic %s", analysisResultTopic.Template, conf.Mqtt.ResultTopic)
+		os.Exit(1)
+	}
+
+	if conf.Mqtt.ResultTopic != "" {
+		resultIngester := analysis.NewResultIngester(analysisLogic, contractMachineDataHandler, messages, conf.Mqtt.DeadLetterTopic, executionStore)
+		if err := mqttCon.Subscribe(conf.Mqtt.ResultTopic, resultIngester.Handle); err != nil {
+			klog.Errorf("cannot subscribe to analysis results: %s", err)
+			os.Exit(1)
+		}
+	}
 
-	contractHandleWorker := contractModel.NewContractHandler(db, "cloud")
 	contractResultList := contractModel.NewResultList(db)
-	contractLogic := contract.NewContractLogic(contractResultList, contractHandleWorker, "cloud")
+	contractLogic := contract.NewContractLogic(contractResultList, contractHandleWorker, "cloud", signatureVerifier, contractEvents)
 	contractHandler := contract.NewContractEndpoint(contractLogic, authHelper)
 
-	http.Handle("/auth", authHandler)
-	http.Handle("/auth/", authHandler)
+	if authHandler != nil {
+		http.Handle("/auth", authHandler)
+		http.Handle("/auth/", authHandler)
+	}
+	http.Handle("/api-keys", apiKeyHandler)
+	http.Handle("/api-keys/", apiKeyHandler)
 	http.Handle("/health", new(health.Health))
 	http.Handle("/metrics", promhttp.Handler())
 	http.Handle("/machine-data", machineHandler)
 	http.Handle("/machine-data/", machineHandler)
-	http.Handle("/ready", new(ready.Ready))
+	http.Handle("/ready", readyHandler)
 	http.Handle("/analysis/", analysisEndpoint)
 	http.Handle("/contract/", contractHandler)
 
@@ -163,6 +357,23 @@ func main() {
 	//http.Handle("/model/", model)
 
 	klog.Infof("start webserver")
-	listen := fmt.Sprintf("%s:%d", conf.Webserver.Address, conf.Webserver.Port)
-	_ = http.ListenAndServe(listen, nil)
+	server := &http.Server{Addr: fmt.Sprintf("%s:%d", conf.Webserver.Address, conf.Webserver.Port)}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("webserver stopped: %s", err)
+			os.Exit(1)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	klog.Infof("shut down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		klog.Errorf("cannot shut down webserver: %s", err)
+	}
+	mqttCon.Close(shutdownTimeout)
 }
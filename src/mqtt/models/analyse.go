@@ -1,5 +1,7 @@
 package models
 
+import "github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/signature"
+
 type Analyse struct {
 	From string `json:"from"`
 	Timestamp string `json:"timestamp"`
@@ -16,7 +18,9 @@ type Analyse struct {
 		Received string `json:"received"`
 	} `json:"calculated"`
 	Results interface{} `json:"results"`
-	Signature string `json:"signature"`
+	Signature signature.Signature `json:"signature"`
+	// Execution is the id of the pipeline execution, which requested the result
+	Execution int64 `json:"execution,omitempty"`
 }
 
 type AnalysisText struct {
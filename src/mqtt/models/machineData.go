@@ -1,5 +1,7 @@
 package models
 
+import "github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/signature"
+
 type Column struct {
 	Name string `json:"name"`
 	Type string `json:"type"`
@@ -23,5 +25,5 @@ type MachineData struct {
 			Value       string `json:"value"`
 		} `json:"meta"`
 	} `json:"body"`
-	Signature string `json:"signature"`
+	Signature signature.Signature `json:"signature"`
 }
@@ -1,51 +1,198 @@
 package mqtt
 
 import (
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"k8s.io/klog"
 )
 
+const (
+	// publishTimeout is the maximal time to wait for the acknowledgement of a published message
+	publishTimeout = 30 * time.Second
+	// subscribeTimeout is the maximal time to wait for the acknowledgement of a subscription
+	subscribeTimeout = 10 * time.Second
+	// minConnectBackoff is the delay after the first failed connection attempt
+	minConnectBackoff = time.Second
+	// defaultMaxReconnectInterval is used, if no maximal reconnect interval is configured
+	defaultMaxReconnectInterval = time.Minute
+)
+
+// ErrClosed will be returned, if a message is published after the connection has been closed
+var ErrClosed = errors.New("mqtt connection is closed")
+
+// Options configures the managed connection to the broker
+type Options struct {
+	// ClientID is the client id of the connection; if it is empty, a random id is generated
+	ClientID string
+	// KeepAlive is the interval of the keepalive messages
+	KeepAlive time.Duration
+	// MaxReconnectInterval is the maximal delay between two connection attempts; the default is one minute
+	MaxReconnectInterval time.Duration
+	// OnStateChange is called, whenever the connection is established or lost
+	OnStateChange func(connected bool)
+	// TLS configures the encrypted connection to the broker
+	TLS TLSConfig
+}
+
 type Mqtt struct {
 	clientID string
 	client   MQTT.Client
+	options  Options
+
+	mutex         sync.Mutex
+	subscriptions map[string]MQTT.MessageHandler
+	closed        bool
+	publishes     sync.WaitGroup
 }
 
 type Msg struct {
-	Topic string
-	Msg   []byte
+	Topic  string
+	Msg    []byte
+	QoS    byte
+	Retain bool
 }
 
-func (m *Mqtt) Init(username, password, host string, port int, tls bool, sendChan <-chan Msg, err chan<- error) error {
-	mq := *m
-	rand.Seed(time.Now().UnixNano())
-	mq.clientID = fmt.Sprintf("connector-%d", rand.Int31())
-	er := m.connect(host, m.clientID, username, password, port, tls)
-	if er != nil {
-		return er
+// Init creates the connection to the broker. The host can contain the scheme (tcp://, ssl:// or tls://) of the
+// connection. If the broker is not available, the connection is established in the background; lost connections
+// are reestablished and the subscriptions are renewed.
+func (m *Mqtt) Init(username, password, host string, port int, options Options) error {
+	m.options = options
+	if m.options.MaxReconnectInterval <= 0 {
+		m.options.MaxReconnectInterval = defaultMaxReconnectInterval
 	}
-	go m.send(sendChan, err)
-	return nil
+	m.clientID = options.ClientID
+	if m.clientID == "" {
+		rand.Seed(time.Now().UnixNano())
+		m.clientID = fmt.Sprintf("connector-%d", rand.Int31())
+	}
+	m.subscriptions = make(map[string]MQTT.MessageHandler)
+
+	return m.connect(host, m.clientID, username, password, port)
 }
 
-func (m *Mqtt) send(sendChan <-chan Msg, err chan<- error) {
-	for {
-		msg := <-sendChan
+// Subscribe subscribes to the topic, which can contain wildcards, and calls the handler for every received message.
+// The subscription is renewed after every reconnection.
+func (m *Mqtt) Subscribe(topic string, handler func(topic string, payload []byte)) error {
+	msgHandler := func(_ MQTT.Client, msg MQTT.Message) {
+		handler(msg.Topic(), msg.Payload())
+	}
 
-		mqttToken := m.client.Publish(msg.Topic, 0, false, msg.Msg)
-		if mqttToken.Wait() && mqttToken.Error() != nil {
-			err <- mqttToken.Error()
-			return
+	m.mutex.Lock()
+	m.subscriptions[topic] = msgHandler
+	m.mutex.Unlock()
+
+	// without a connection, the subscription is made after the connection is established
+	if !m.client.IsConnectionOpen() {
+		klog.Infof("mqtt broker is not connected; subscribe to %s after connecting", topic)
+		return nil
+	}
+
+	return m.subscribe(topic, msgHandler)
+}
+
+func (m *Mqtt) subscribe(topic string, handler MQTT.MessageHandler) error {
+	token := m.client.Subscribe(topic, 1, handler)
+	if !token.WaitTimeout(subscribeTimeout) {
+		return fmt.Errorf("subscription to %s is not acknowledged", topic)
+	}
+
+	return token.Error()
+}
+
+// Publish sends the message to the broker and waits, until the broker has received it (for QoS 0 until it is sent)
+func (m *Mqtt) Publish(msg Msg) error {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return ErrClosed
+	}
+	m.publishes.Add(1)
+	m.mutex.Unlock()
+	defer m.publishes.Done()
+
+	mqttToken := m.client.Publish(msg.Topic, msg.QoS, msg.Retain, msg.Msg)
+	if !mqttToken.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("message on topic %s is not acknowledged", msg.Topic)
+	}
+
+	return mqttToken.Error()
+}
+
+// IsConnected returns, whether the connection to the broker is established
+func (m *Mqtt) IsConnected() bool {
+	return m.client.IsConnectionOpen()
+}
+
+// Close waits until the pending publishes are finished or the timeout is reached and disconnects from the broker
+func (m *Mqtt) Close(timeout time.Duration) {
+	m.mutex.Lock()
+	m.closed = true
+	m.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.publishes.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		klog.Errorf("pending mqtt publishes are not finished within %s", timeout)
+	}
+
+	m.client.Disconnect(uint(timeout / time.Millisecond))
+	m.stateChanged(false)
+}
+
+func (m *Mqtt) stateChanged(connected bool) {
+	if m.options.OnStateChange != nil {
+		m.options.OnStateChange(connected)
+	}
+}
+
+// onConnect renews the subscriptions after a connection is established
+func (m *Mqtt) onConnect(_ MQTT.Client) {
+	klog.Infof("connected to mqtt broker")
+
+	m.mutex.Lock()
+	subscriptions := make(map[string]MQTT.MessageHandler, len(m.subscriptions))
+	for topic, handler := range m.subscriptions {
+		subscriptions[topic] = handler
+	}
+	m.mutex.Unlock()
+
+	for topic, handler := range subscriptions {
+		if err := m.subscribe(topic, handler); err != nil {
+			klog.Errorf("cannot subscribe to %s: %s", topic, err)
 		}
 	}
+
+	m.stateChanged(true)
+}
+
+func (m *Mqtt) onConnectionLost(_ MQTT.Client, err error) {
+	klog.Errorf("connection to mqtt broker lost: %s", err)
+	m.stateChanged(false)
 }
 
-func (m *Mqtt) connect(host, deviceId, user, password string, port int, tlsVerify bool) error {
+func (m *Mqtt) connect(host, deviceId, user, password string, port int) error {
+	broker, encrypted := brokerURL(host, port, m.options.TLS.Enabled)
+
+	clientOpts := MQTT.NewClientOptions().AddBroker(broker).SetClientID(deviceId).SetCleanSession(true)
+	clientOpts.SetAutoReconnect(true)
+	clientOpts.SetOnConnectHandler(m.onConnect)
+	clientOpts.SetConnectionLostHandler(m.onConnectionLost)
+	clientOpts.SetMaxReconnectInterval(m.options.MaxReconnectInterval)
 
-	clientOpts := MQTT.NewClientOptions().AddBroker(fmt.Sprintf("tcp://%s:%d", host, port)).SetClientID(deviceId).SetCleanSession(true)
+	if m.options.KeepAlive > 0 {
+		clientOpts.SetKeepAlive(m.options.KeepAlive)
+	}
 
 	if user != "" {
 		clientOpts.SetUsername(user)
@@ -54,19 +201,53 @@ func (m *Mqtt) connect(host, deviceId, user, password string, port int, tlsVerif
 		}
 	}
 
-	if tlsVerify {
-		tlsConfig := &tls.Config{ClientAuth: tls.NoClientCert}
-		clientOpts.SetTLSConfig(tlsConfig)
-	} else {
-		tlsConfig := &tls.Config{InsecureSkipVerify: true, ClientAuth: tls.NoClientCert}
+	if encrypted {
+		tlsConfig, err := newTLSConfig(m.options.TLS)
+		if err != nil {
+			return err
+		}
 		clientOpts.SetTLSConfig(tlsConfig)
 	}
 
 	m.client = MQTT.NewClient(clientOpts)
+	m.stateChanged(false)
 
-	if tokenClient := m.client.Connect(); tokenClient.Wait() && tokenClient.Error() != nil {
-		return tokenClient.Error()
+	// the client reconnects lost connections automatically, but the first connection has to be retried
+	if err := m.tryConnect(); err != nil {
+		klog.Errorf("cannot connect to mqtt broker: %s", err)
+		go m.retryConnect()
 	}
 
 	return nil
 }
+
+func (m *Mqtt) tryConnect() error {
+	tokenClient := m.client.Connect()
+	tokenClient.Wait()
+	return tokenClient.Error()
+}
+
+// retryConnect tries to establish the first connection with an exponential backoff
+func (m *Mqtt) retryConnect() {
+	delay := minConnectBackoff
+	for {
+		time.Sleep(delay)
+
+		m.mutex.Lock()
+		closed := m.closed
+		m.mutex.Unlock()
+		if closed {
+			return
+		}
+
+		err := m.tryConnect()
+		if err == nil {
+			return
+		}
+		klog.Errorf("cannot connect to mqtt broker: %s", err)
+
+		if delay *= 2; delay > m.options.MaxReconnectInterval {
+			delay = m.options.MaxReconnectInterval
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package mqtt
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// closedPort returns a local port, on which no broker is listening
+func closedPort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	if err := listener.Close(); err != nil {
+		t.Fatalf("cannot close listener: %s", err)
+	}
+	return port
+}
+
+func TestMqtt_Init(t *testing.T) {
+	testTable := []struct {
+		description string
+		clientID    string
+	}{
+		{"configured client id", "connector-test"},
+		{"generated client id", ""},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			var mutex sync.Mutex
+			var states []bool
+
+			var m Mqtt
+			err := m.Init("", "", "127.0.0.1", closedPort(t), Options{
+				ClientID: v.clientID,
+				OnStateChange: func(connected bool) {
+					mutex.Lock()
+					defer mutex.Unlock()
+					states = append(states, connected)
+				},
+			})
+			if err != nil {
+				t.Fatalf("unavailable broker should be connected in the background: %s", err)
+			}
+			defer m.Close(time.Second)
+
+			if v.clientID != "" && m.clientID != v.clientID {
+				t.Errorf("expected client id %s, got %s", v.clientID, m.clientID)
+			}
+
+			if m.clientID == "" {
+				t.Errorf("client id is not set")
+			}
+
+			if m.IsConnected() {
+				t.Errorf("connection to an unavailable broker is open")
+			}
+
+			mutex.Lock()
+			if len(states) == 0 || states[0] {
+				t.Errorf("expected disconnected state, got %v", states)
+			}
+			mutex.Unlock()
+
+			// subscriptions are made after connecting
+			if err := m.Subscribe("topic", func(string, []byte) {}); err != nil {
+				t.Errorf("cannot register subscription: %s", err)
+			}
+
+			if _, ok := m.subscriptions["topic"]; !ok {
+				t.Errorf("subscription is not registered")
+			}
+		})
+	}
+}
+
+func TestMqtt_Close(t *testing.T) {
+	var m Mqtt
+	if err := m.Init("", "", "127.0.0.1", closedPort(t), Options{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	m.Close(time.Second)
+
+	if err := m.Publish(Msg{Topic: "topic", Msg: []byte("msg")}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected error %s, got %v", ErrClosed, err)
+	}
+}
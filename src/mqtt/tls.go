@@ -0,0 +1,71 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// TLSConfig configures the encrypted connection to the broker
+type TLSConfig struct {
+	// Enabled uses an encrypted connection, even if the address does not contain the scheme ssl:// or tls://
+	Enabled bool
+	// CAFile is the path to the PEM encoded certificates, which are trusted to sign the certificate of the
+	// broker; if it is empty, the certificates of the system are used
+	CAFile string
+	// CertFile and KeyFile are the paths to the PEM encoded client certificate and key for mutual TLS
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name, which is verified in the certificate of the broker
+	ServerName string
+}
+
+// tlsSchemes are the schemes of the broker address, which use an encrypted connection
+var tlsSchemes = map[string]bool{"ssl": true, "tls": true, "tcps": true}
+
+// brokerURL returns the url of the broker and whether the connection is encrypted. The host can contain a scheme.
+func brokerURL(host string, port int, enabled bool) (string, bool) {
+	scheme := "tcp"
+	if enabled {
+		scheme = "ssl"
+	}
+
+	if parts := strings.SplitN(host, "://", 2); len(parts) == 2 {
+		scheme, host = parts[0], parts[1]
+	}
+
+	return fmt.Sprintf("%s://%s:%d", scheme, host, port), tlsSchemes[scheme]
+}
+
+// newTLSConfig creates the configuration of an encrypted connection
+func newTLSConfig(config TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: config.ServerName}
+
+	if config.CAFile != "" {
+		data, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read ca bundle: %s", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("ca bundle %s contains no certificate", config.CAFile)
+		}
+	}
+
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, fmt.Errorf("the client certificate and key have to be set together")
+	}
+
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,282 @@
+package mqtt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs the certificates of the test broker and client
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cannot create ca certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("cannot parse ca certificate: %s", err)
+	}
+
+	return testCA{cert: cert, key: key}
+}
+
+// issue creates a certificate and key signed by the ca and writes them PEM encoded into the directory
+func (ca testCA) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %s", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("cannot marshal key: %s", err)
+	}
+
+	certFile := writePEM(t, dir, name+".crt", "CERTIFICATE", der)
+	keyFile := writePEM(t, dir, name+".key", "EC PRIVATE KEY", keyDer)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("cannot write %s: %s", name, err)
+	}
+	return path
+}
+
+// startTLSBroker starts a stand-in of a broker, which requires a client certificate signed by the ca and
+// accepts every mqtt connection. It returns the port of the broker.
+func startTLSBroker(t *testing.T, ca testCA, dir string) int {
+	certFile, keyFile := ca.issue(t, dir, "broker.test", x509.ExtKeyUsageServerAuth)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("cannot load broker certificate: %s", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConnect(conn)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// serveConnect reads the CONNECT packet, accepts it and discards all further packets
+func serveConnect(conn net.Conn) {
+	defer conn.Close()
+
+	header := make([]byte, 1)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+
+	// the remaining length is encoded as variable byte integer
+	length, multiplier := 0, 1
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length += int(header[0]&127) * multiplier
+		if header[0]&128 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, conn, int64(length)); err != nil {
+		return
+	}
+
+	// CONNACK: session not present, connection accepted
+	if _, err := conn.Write([]byte{0x20, 0x02, 0x00, 0x00}); err != nil {
+		return
+	}
+
+	_, _ = io.Copy(ioutil.Discard, conn)
+}
+
+func TestBrokerURL(t *testing.T) {
+	testTable := []struct {
+		description string
+		host        string
+		enabled     bool
+		url         string
+		encrypted   bool
+	}{
+		{"plain", "127.0.0.1", false, "tcp://127.0.0.1:1883", false},
+		{"enabled", "127.0.0.1", true, "ssl://127.0.0.1:1883", true},
+		{"ssl scheme", "ssl://broker", false, "ssl://broker:1883", true},
+		{"tls scheme", "tls://broker", false, "tls://broker:1883", true},
+		{"tcp scheme", "tcp://broker", false, "tcp://broker:1883", false},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			url, encrypted := brokerURL(v.host, 1883, v.enabled)
+			if url != v.url || encrypted != v.encrypted {
+				t.Errorf("expected %s (encrypted %t), got %s (encrypted %t)", v.url, v.encrypted, url, encrypted)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := writePEM(t, dir, "ca.crt", "CERTIFICATE", ca.cert.Raw)
+	certFile, keyFile := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+
+	testTable := []struct {
+		description string
+		config      TLSConfig
+		err         bool
+	}{
+		{"system certificates", TLSConfig{}, false},
+		{"ca bundle and client certificate", TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, false},
+		{"missing ca bundle", TLSConfig{CAFile: filepath.Join(dir, "missing.crt")}, true},
+		{"ca bundle without certificate", TLSConfig{CAFile: keyFile}, true},
+		{"certificate without key", TLSConfig{CertFile: certFile}, true},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			config, err := newTLSConfig(v.config)
+			if (err != nil) != v.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err == nil && config.InsecureSkipVerify {
+				t.Errorf("certificate of the broker is not verified")
+			}
+		})
+	}
+}
+
+func TestMqtt_InitTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := writePEM(t, dir, "ca.crt", "CERTIFICATE", ca.cert.Raw)
+	certFile, keyFile := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+	port := startTLSBroker(t, ca, dir)
+
+	otherCA := newTestCA(t)
+	otherCAFile := writePEM(t, dir, "other.crt", "CERTIFICATE", otherCA.cert.Raw)
+
+	testTable := []struct {
+		description string
+		host        string
+		tls         TLSConfig
+		connected   bool
+	}{
+		{
+			"mutual tls",
+			"ssl://127.0.0.1",
+			TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "broker.test"},
+			true,
+		},
+		{
+			"enabled tls",
+			"127.0.0.1",
+			TLSConfig{Enabled: true, CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "broker.test"},
+			true,
+		},
+		{
+			"untrusted broker",
+			"tls://127.0.0.1",
+			TLSConfig{CAFile: otherCAFile, CertFile: certFile, KeyFile: keyFile, ServerName: "broker.test"},
+			false,
+		},
+		{
+			"wrong server name",
+			"ssl://127.0.0.1",
+			TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "other.test"},
+			false,
+		},
+		{
+			"missing client certificate",
+			"ssl://127.0.0.1",
+			TLSConfig{CAFile: caFile, ServerName: "broker.test"},
+			false,
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			var m Mqtt
+			if err := m.Init("", "", v.host, port, Options{TLS: v.tls}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer m.Close(time.Second)
+
+			if m.IsConnected() != v.connected {
+				t.Errorf("expected connected %t, got %t", v.connected, m.IsConnected())
+			}
+		})
+	}
+}
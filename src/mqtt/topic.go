@@ -0,0 +1,99 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidTopicLevel will be returned, if a placeholder would be replaced by a value, which is not a single
+// topic level; these are values containing the level separator / or one of the wildcards + and #
+var ErrInvalidTopicLevel = errors.New("invalid topic level")
+
+const (
+	// DefaultMachineDataTopic is the topic of the uploaded machine data
+	DefaultMachineDataTopic = "kosmos/machine-data/{machine}/sensor/{sensor}/update"
+	// DefaultContractEventTopic is the topic of the events of a contract for a kosmos local system
+	DefaultContractEventTopic = "kosmos/contract/{system}/{contract}/{event}"
+	// DefaultExecutionRequestTopic is the topic of the execution requests of the pipelines
+	DefaultExecutionRequestTopic = "kosmos/pipeline/{system}/{contract}/{pipeline}/execute"
+)
+
+// Topic defines the topic and the delivery of a kind of messages. The template can contain placeholders
+// like {machine}, which are replaced by the parameters of the message.
+type Topic struct {
+	Template string
+	QoS      byte
+	Retain   bool
+}
+
+// NewTopic creates a topic definition; if the template is empty, the default template is used
+func NewTopic(template, defaultTemplate string, qos byte, retain bool) (Topic, error) {
+	if qos > 2 {
+		return Topic{}, fmt.Errorf("invalid qos %d of topic %s", qos, template)
+	}
+
+	if template == "" {
+		template = defaultTemplate
+	}
+
+	if strings.ContainsAny(template, "+#") {
+		return Topic{}, fmt.Errorf("topic %s contains a wildcard", template)
+	}
+
+	return Topic{Template: template, QoS: qos, Retain: retain}, nil
+}
+
+// Validate checks, that every value of the params, which replaces a placeholder of the template, is a single
+// topic level, so that a value cannot address another topic or subscription
+func (t Topic) Validate(params map[string]string) error {
+	for key, value := range params {
+		if strings.Contains(t.Template, "{"+key+"}") && strings.ContainsAny(value, "/+#") {
+			return fmt.Errorf("value %q of %s: %w", value, key, ErrInvalidTopicLevel)
+		}
+	}
+	return nil
+}
+
+// Msg creates a message on this topic; every placeholder {key} of the template is replaced by params[key].
+// An ErrInvalidTopicLevel is returned, if a value is not valid.
+func (t Topic) Msg(payload []byte, params map[string]string) (Msg, error) {
+	if err := t.Validate(params); err != nil {
+		return Msg{}, err
+	}
+
+	replacements := make([]string, 0, 2*len(params))
+	for key, value := range params {
+		replacements = append(replacements, "{"+key+"}", value)
+	}
+
+	return Msg{
+		Topic:  strings.NewReplacer(replacements...).Replace(t.Template),
+		Msg:    payload,
+		QoS:    t.QoS,
+		Retain: t.Retain,
+	}, nil
+}
+
+// Overlaps returns if a message on this topic can match the subscription filter. A placeholder can be
+// replaced by every value, so that a level with a placeholder matches every level of the filter.
+func (t Topic) Overlaps(filter string) bool {
+	if t.Template == "" || filter == "" {
+		return false
+	}
+
+	levels := strings.Split(t.Template, "/")
+	for i, f := range strings.Split(filter, "/") {
+		if f == "#" {
+			return true
+		}
+		if i >= len(levels) {
+			return false
+		}
+		if f != "+" && f != levels[i] && !strings.Contains(levels[i], "{") {
+			return false
+		}
+	}
+
+	return len(strings.Split(filter, "/")) == len(levels)
+}
@@ -0,0 +1,99 @@
+package mqtt
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewTopic(t *testing.T) {
+	testTable := []struct {
+		description string
+		template    string
+		qos         byte
+		expected    string
+		err         bool
+	}{
+		{"default template", "", 1, DefaultMachineDataTopic, false},
+		{"configured template", "edge/{machine}/{sensor}", 2, "edge/{machine}/{sensor}", false},
+		{"invalid qos", "", 3, "", true},
+		{"wildcard", "edge/+/{sensor}", 0, "", true},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			topic, err := NewTopic(v.template, DefaultMachineDataTopic, v.qos, false)
+			if (err != nil) != v.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if topic.Template != v.expected {
+				t.Errorf("expected template %s, got %s", v.expected, topic.Template)
+			}
+		})
+	}
+}
+
+func TestTopic_Msg(t *testing.T) {
+	topic := Topic{Template: "kosmos/{contract}/{machine}/{sensor}/{machine}", QoS: 2, Retain: true}
+
+	msg, err := topic.Msg([]byte("payload"), map[string]string{"contract": "c", "machine": "m", "sensor": "s"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if msg.Topic != "kosmos/c/m/s/m" {
+		t.Errorf("unexpected topic %s", msg.Topic)
+	}
+
+	if string(msg.Msg) != "payload" || msg.QoS != 2 || !msg.Retain {
+		t.Errorf("unexpected message %v", msg)
+	}
+}
+
+func TestTopic_MsgInvalidLevel(t *testing.T) {
+	topic := Topic{Template: "kosmos/{contract}/{machine}"}
+
+	testTable := []struct {
+		description string
+		params      map[string]string
+		err         error
+	}{
+		{"level separator", map[string]string{"contract": "c", "machine": "m/other"}, ErrInvalidTopicLevel},
+		{"single level wildcard", map[string]string{"contract": "+", "machine": "m"}, ErrInvalidTopicLevel},
+		{"multi level wildcard", map[string]string{"contract": "c", "machine": "#"}, ErrInvalidTopicLevel},
+		{"unused parameter", map[string]string{"contract": "c", "machine": "m", "sensor": "s/#"}, nil},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			if _, err := topic.Msg(nil, v.params); !errors.Is(err, v.err) {
+				t.Errorf("returned error != expected error\n\t%s != %s", err, v.err)
+			}
+		})
+	}
+}
+
+func TestTopic_Overlaps(t *testing.T) {
+	testTable := []struct {
+		description string
+		template    string
+		filter      string
+		overlaps    bool
+	}{
+		{"same topic", "kosmos/analyses/{contract}", "kosmos/analyses/c", true},
+		{"single level wildcard", "kosmos/analyses/{contract}", "kosmos/+/+", true},
+		{"multi level wildcard", "kosmos/analyses/{contract}/{machine}", "kosmos/#", true},
+		{"different level", "kosmos/analyses/{contract}", "kosmos/results/+", false},
+		{"shorter filter", "kosmos/analyses/{contract}", "kosmos/analyses", false},
+		{"longer filter", "kosmos/analyses/{contract}", "kosmos/analyses/+/+", false},
+		{"empty template", "", "#", false},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			if overlaps := (Topic{Template: v.template}).Overlaps(v.filter); overlaps != v.overlaps {
+				t.Errorf("expected %t, got %t", v.overlaps, overlaps)
+			}
+		})
+	}
+}
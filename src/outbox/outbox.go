@@ -0,0 +1,228 @@
+// Package outbox stores the mqtt messages in the database, until they are published on the broker
+package outbox
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/klog"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+)
+
+const (
+	// minBackoff is the delay after the first failed publish of a message
+	minBackoff = time.Second
+	// maxBackoff is the maximal delay between two publish attempts of a message
+	maxBackoff = 5 * time.Minute
+	// maxAttempts is the count of the failed publish attempts, after which a message is dead-lettered;
+	// with the maximal backoff a message is retried for about one day
+	maxAttempts = 300
+	// claimTimeout is the time, after which a claimed message, which is neither published nor retried,
+	// can be claimed again, e.g. because its dispatcher has stopped
+	claimTimeout = time.Minute
+)
+
+var (
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "outbox_queue_depth",
+		Help: "count of the messages, which are not published yet",
+	})
+
+	publishedMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "outbox_published_total",
+		Help: "count of the published messages",
+	})
+
+	failedPublishes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "outbox_publish_failures_total",
+		Help: "count of the failed publish attempts",
+	})
+
+	deadLetters = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "outbox_dead_letters",
+		Help: "count of the messages, which are not published after the maximal count of attempts",
+	})
+)
+
+// Outbox queues messages, which should be published on the mqtt broker
+type Outbox interface {
+	// Enqueue stores the message durably; it will be published, when the broker is available
+	Enqueue(msg mqtt.Msg) error
+}
+
+// Publisher publishes a message on the broker and returns, after the broker has received it
+type Publisher interface {
+	Publish(msg mqtt.Msg) error
+}
+
+// Dispatcher publishes the queued messages
+type Dispatcher interface {
+	// Run publishes the queued messages. Failed messages are retried with an exponential backoff, until
+	// they are dead-lettered; the messages of a topic are published in the order, in which they are queued.
+	Run()
+}
+
+// Executor is implemented by *sql.DB and *sql.Tx
+type Executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// NewOutbox creates a new outbox, which stores the messages in the database
+func NewOutbox(db *sql.DB) Outbox {
+	return outbox{db: db}
+}
+
+// In returns an outbox, which stores the messages inside the transaction; the messages are only
+// published, if the transaction is committed
+func In(tx Executor) Outbox {
+	return outbox{db: tx}
+}
+
+type outbox struct {
+	db Executor
+}
+
+func (o outbox) Enqueue(msg mqtt.Msg) error {
+	_, err := o.db.Exec("INSERT INTO outbox (topic, payload, qos, retain) VALUES ($1, $2, $3, $4)", msg.Topic, msg.Msg, msg.QoS, msg.Retain)
+	return err
+}
+
+// NewDispatcher creates a dispatcher, which checks for queued messages in the given interval
+func NewDispatcher(db *sql.DB, publisher Publisher, interval time.Duration) Dispatcher {
+	return dispatcher{db: db, publisher: publisher, interval: interval, now: time.Now}
+}
+
+type dispatcher struct {
+	db        *sql.DB
+	publisher Publisher
+	interval  time.Duration
+	now       func() time.Time
+}
+
+type queuedMsg struct {
+	id       int64
+	attempts int
+	msg      mqtt.Msg
+}
+
+// backoff returns the delay after the given count of failed attempts
+func backoff(attempts int) time.Duration {
+	delay := minBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
+
+// claim returns the oldest pending message of every topic, which can be published now. Newer messages of
+// a topic are only returned, after the older ones are published or dead-lettered. The messages are claimed
+// by moving their next attempt behind the claim timeout, so that the rows are only locked by the statement
+// and other dispatchers skip the messages while they are published.
+func (d dispatcher) claim() ([]queuedMsg, error) {
+	now := d.now()
+	query, err := d.db.Query("UPDATE outbox SET next_attempt = $2 WHERE id IN (SELECT id FROM outbox WHERE id IN (SELECT DISTINCT ON (topic) id FROM outbox WHERE failed IS NULL ORDER BY topic, id) AND next_attempt <= $1 FOR UPDATE SKIP LOCKED) RETURNING id, topic, payload, qos, retain, attempts", now, now.Add(claimTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	var messages []queuedMsg
+	for query.Next() {
+		var msg queuedMsg
+		if err := query.Scan(&msg.id, &msg.msg.Topic, &msg.msg.Msg, &msg.msg.QoS, &msg.msg.Retain, &msg.attempts); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	// the returned rows are not ordered
+	sort.Slice(messages, func(i, j int) bool { return messages[i].id < messages[j].id })
+	return messages, query.Err()
+}
+
+// dispatch publishes the pending messages once and returns the count of the published messages. Published
+// messages are deleted, failed messages are retried later or dead-lettered after the maximal count of attempts.
+// No transaction is held open, while the messages are published.
+func (d dispatcher) dispatch() (int, error) {
+	messages, err := d.claim()
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	defer func() { publishedMessages.Add(float64(published)) }()
+
+	for _, msg := range messages {
+		if err := d.publisher.Publish(msg.msg); err != nil {
+			failedPublishes.Inc()
+			klog.Errorf("cannot publish message on topic %s: %s", msg.msg.Topic, err)
+
+			if err := d.retry(msg); err != nil {
+				return published, err
+			}
+			continue
+		}
+
+		if _, err := d.db.Exec("DELETE FROM outbox WHERE id = $1", msg.id); err != nil {
+			return published, err
+		}
+		published++
+	}
+
+	return published, nil
+}
+
+// retry schedules the next attempt of a failed message or marks it as failed, if its attempts are exhausted
+func (d dispatcher) retry(msg queuedMsg) error {
+	attempts := msg.attempts + 1
+	if attempts >= maxAttempts {
+		klog.Errorf("give up message %d on topic %s after %d attempts", msg.id, msg.msg.Topic, attempts)
+		_, err := d.db.Exec("UPDATE outbox SET attempts = attempts + 1, failed = $2 WHERE id = $1", msg.id, d.now())
+		return err
+	}
+
+	_, err := d.db.Exec("UPDATE outbox SET attempts = attempts + 1, next_attempt = $2 WHERE id = $1", msg.id, d.now().Add(backoff(attempts)))
+	return err
+}
+
+// depth updates the queue depth and dead letter metrics
+func (d dispatcher) depth() error {
+	var pending, failed int64
+	if err := d.db.QueryRow("SELECT count(*) FILTER (WHERE failed IS NULL), count(*) FILTER (WHERE failed IS NOT NULL) FROM outbox").Scan(&pending, &failed); err != nil {
+		return err
+	}
+
+	queueDepth.Set(float64(pending))
+	deadLetters.Set(float64(failed))
+	return nil
+}
+func (d dispatcher) Run() {
+	for {
+		published, err := d.dispatch()
+		if err != nil {
+			klog.Errorf("cannot dispatch queued messages: %s", err)
+		}
+
+		if err := d.depth(); err != nil {
+			klog.Errorf("cannot count queued messages: %s", err)
+		}
+
+		// continue immediately, while the next messages of the topics are waiting
+		if published == 0 {
+			time.Sleep(d.interval)
+		}
+	}
+}
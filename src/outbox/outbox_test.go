@@ -0,0 +1,107 @@
+package outbox
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+)
+
+const claimQuery = "UPDATE outbox SET next_attempt = $2 WHERE id IN (SELECT id FROM outbox WHERE id IN (SELECT DISTINCT ON (topic) id FROM outbox WHERE failed IS NULL ORDER BY topic, id) AND next_attempt <= $1 FOR UPDATE SKIP LOCKED) RETURNING id, topic, payload, qos, retain, attempts"
+
+// testPublisher fails for the topics in failing and records the published messages
+type testPublisher struct {
+	failing   map[string]bool
+	published []mqtt.Msg
+}
+
+func (p *testPublisher) Publish(msg mqtt.Msg) error {
+	if p.failing[msg.Topic] {
+		return fmt.Errorf("broker is not available")
+	}
+	p.published = append(p.published, msg)
+	return nil
+}
+
+func TestBackoff(t *testing.T) {
+	testTable := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{20, maxBackoff},
+	}
+
+	for _, v := range testTable {
+		t.Run(fmt.Sprintf("%d attempts", v.attempts), func(t *testing.T) {
+			if delay := backoff(v.attempts); delay != v.expected {
+				t.Errorf("expected backoff %s, got %s", v.expected, delay)
+			}
+		})
+	}
+}
+
+func TestOutbox_Enqueue(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mocked database: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO outbox (topic, payload, qos, retain) VALUES ($1, $2, $3, $4)").
+		WithArgs("topic", []byte("payload"), 1, true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := NewOutbox(db).Enqueue(mqtt.Msg{Topic: "topic", Msg: []byte("payload"), QoS: 1, Retain: true}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDispatcher_Dispatch(t *testing.T) {
+	now := time.Date(2020, 9, 23, 10, 0, 0, 0, time.UTC)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mocked database: %s", err)
+	}
+	defer db.Close()
+
+	// the messages are published without a transaction; the rows are returned unordered
+	mock.ExpectQuery(claimQuery).WithArgs(now, now.Add(claimTimeout)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "topic", "payload", "qos", "retain", "attempts"}).
+			AddRow(3, "c", []byte("third"), 0, false, maxAttempts-1).
+			AddRow(1, "a", []byte("first"), 1, true, 0).
+			AddRow(2, "b", []byte("second"), 0, false, 2))
+	mock.ExpectExec("DELETE FROM outbox WHERE id = $1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE outbox SET attempts = attempts + 1, next_attempt = $2 WHERE id = $1").
+		WithArgs(2, now.Add(4*time.Second)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE outbox SET attempts = attempts + 1, failed = $2 WHERE id = $1").
+		WithArgs(3, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	publisher := &testPublisher{failing: map[string]bool{"b": true, "c": true}}
+	d := dispatcher{db: db, publisher: publisher, now: func() time.Time { return now }}
+
+	published, err := d.dispatch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if published != 1 || len(publisher.published) != 1 || publisher.published[0].Topic != "a" || publisher.published[0].QoS != 1 || !publisher.published[0].Retain {
+		t.Errorf("unexpected published messages: %v", publisher.published)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
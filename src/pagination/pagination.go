@@ -0,0 +1,171 @@
+// Package pagination provides the keyset pagination of the lists, which are returned by the endpoints
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidPage will be returned, if the pagination parameters of a request cannot be parsed
+var ErrInvalidPage = errors.New("invalid pagination parameter")
+
+// NextCursorHeader is the response header, which contains the cursor of the next page
+const NextCursorHeader = "X-Next-Cursor"
+
+const (
+	// DefaultLimit is the size of a page, if no limit is requested
+	DefaultLimit = 100
+	// MaxLimit is the maximal size of a page
+	MaxLimit = 1000
+)
+
+// Order is the sort order of a list
+type Order string
+
+const (
+	Ascending  Order = "asc"
+	Descending Order = "desc"
+)
+
+// Cursor identifies the last element of a page. The time is only set, if the list is sorted by time.
+type Cursor struct {
+	Time time.Time `json:"time,omitempty"`
+	ID   string    `json:"id"`
+}
+
+// Encode returns the opaque representation of the cursor, which is returned to the clients
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor created by Encode
+func DecodeCursor(cursor string) (Cursor, error) {
+	var c Cursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, fmt.Errorf("%w: cannot decode cursor: %s", ErrInvalidPage, err)
+	}
+
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return c, fmt.Errorf("%w: cannot parse cursor", ErrInvalidPage)
+	}
+
+	return c, nil
+}
+
+// Page is the requested part of a list
+type Page struct {
+	Limit  int
+	Offset int
+	Cursor *Cursor
+	Order  Order
+}
+
+// Parse reads the pagination parameters limit, cursor, offset and order from the query parameters
+// and returns the remaining query parameters
+func Parse(queryParams map[string][]string) (Page, map[string][]string, error) {
+	page := Page{Limit: DefaultLimit, Order: Ascending}
+	remaining := make(map[string][]string)
+
+	for i, v := range queryParams {
+		switch i {
+		case "limit", "offset", "cursor", "order":
+		default:
+			remaining[i] = v
+			continue
+		}
+
+		if len(v) != 1 {
+			return page, nil, fmt.Errorf("%w: unexpected length of the query parameter %s", ErrInvalidPage, i)
+		}
+
+		switch i {
+		case "limit":
+			limit, err := strconv.Atoi(v[0])
+			if err != nil || limit < 1 || limit > MaxLimit {
+				return page, nil, fmt.Errorf("%w: limit has to be between 1 and %d", ErrInvalidPage, MaxLimit)
+			}
+			page.Limit = limit
+		case "offset":
+			offset, err := strconv.Atoi(v[0])
+			if err != nil || offset < 0 {
+				return page, nil, fmt.Errorf("%w: offset has to be a positive number", ErrInvalidPage)
+			}
+			page.Offset = offset
+		case "cursor":
+			cursor, err := DecodeCursor(v[0])
+			if err != nil {
+				return page, nil, err
+			}
+			page.Cursor = &cursor
+		case "order":
+			switch order := Order(v[0]); order {
+			case Ascending, Descending:
+				page.Order = order
+			default:
+				return page, nil, fmt.Errorf("%w: unknown order %s", ErrInvalidPage, v[0])
+			}
+		}
+	}
+
+	if page.Cursor != nil && page.Offset != 0 {
+		return page, nil, fmt.Errorf("%w: cursor and offset cannot be combined", ErrInvalidPage)
+	}
+
+	return page, remaining, nil
+}
+
+// Condition returns the sql condition, which selects the elements after the cursor, and its arguments.
+// The parameters of the condition start with the number param. If the list is not sorted by time, the
+// timeColumn has to be empty. An empty condition is returned, if no cursor is set.
+func (p Page) Condition(timeColumn, idColumn string, param int) (string, []interface{}) {
+	if p.Cursor == nil {
+		return "", nil
+	}
+
+	operator := ">"
+	if p.Order == Descending {
+		operator = "<"
+	}
+
+	if timeColumn == "" {
+		return fmt.Sprintf("%s %s $%d", idColumn, operator, param), []interface{}{p.Cursor.ID}
+	}
+
+	return fmt.Sprintf("(%s, %s) %s ($%d, $%d)", timeColumn, idColumn, operator, param, param+1), []interface{}{p.Cursor.Time, p.Cursor.ID}
+}
+
+// OrderBy returns the sql order clause of the page
+func (p Page) OrderBy(timeColumn, idColumn string) string {
+	direction := "ASC"
+	if p.Order == Descending {
+		direction = "DESC"
+	}
+
+	if timeColumn == "" {
+		return fmt.Sprintf("ORDER BY %s %s", idColumn, direction)
+	}
+
+	return fmt.Sprintf("ORDER BY %s %s, %s %s", timeColumn, direction, idColumn, direction)
+}
+
+// Limits returns the sql limit and offset clause and its arguments. One more element than the limit
+// is queried to detect, if a next page exists.
+func (p Page) Limits(param int) (string, []interface{}) {
+	return fmt.Sprintf("LIMIT $%d OFFSET $%d", param, param+1), []interface{}{p.Limit + 1, p.Offset}
+}
+
+// Next returns the encoded cursor of the next page or an empty string, if the count of the queried
+// elements shows that this is the last page. last has to be the last element of this page.
+func (p Page) Next(count int, last Cursor) string {
+	if count <= p.Limit {
+		return ""
+	}
+
+	return last.Encode()
+}
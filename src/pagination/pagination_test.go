@@ -0,0 +1,143 @@
+package pagination
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	cursor := Cursor{Time: time.Date(2020, 9, 23, 10, 24, 55, 0, time.UTC), ID: "4"}
+
+	testTable := []struct {
+		description   string
+		queryParams   map[string][]string
+		page          Page
+		remaining     map[string][]string
+		expectedError error
+	}{
+		{
+			"defaults",
+			map[string][]string{"machine": {"m"}},
+			Page{Limit: DefaultLimit, Order: Ascending},
+			map[string][]string{"machine": {"m"}},
+			nil,
+		},
+		{
+			"all parameters",
+			map[string][]string{"limit": {"10"}, "order": {"desc"}, "cursor": {cursor.Encode()}},
+			Page{Limit: 10, Order: Descending, Cursor: &cursor},
+			map[string][]string{},
+			nil,
+		},
+		{
+			"offset",
+			map[string][]string{"offset": {"20"}},
+			Page{Limit: DefaultLimit, Offset: 20, Order: Ascending},
+			map[string][]string{},
+			nil,
+		},
+		{
+			"limit too large",
+			map[string][]string{"limit": {"1001"}},
+			Page{},
+			nil,
+			ErrInvalidPage,
+		},
+		{
+			"negative offset",
+			map[string][]string{"offset": {"-1"}},
+			Page{},
+			nil,
+			ErrInvalidPage,
+		},
+		{
+			"unknown order",
+			map[string][]string{"order": {"random"}},
+			Page{},
+			nil,
+			ErrInvalidPage,
+		},
+		{
+			"invalid cursor",
+			map[string][]string{"cursor": {"abc"}},
+			Page{},
+			nil,
+			ErrInvalidPage,
+		},
+		{
+			"cursor and offset",
+			map[string][]string{"cursor": {cursor.Encode()}, "offset": {"1"}},
+			Page{},
+			nil,
+			ErrInvalidPage,
+		},
+		{
+			"repeated limit",
+			map[string][]string{"limit": {"1", "2"}},
+			Page{},
+			nil,
+			ErrInvalidPage,
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			page, remaining, err := Parse(v.queryParams)
+			if !errors.Is(err, v.expectedError) {
+				t.Fatalf("expected error %v, got %v", v.expectedError, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(page, v.page) {
+				t.Errorf("expected page %v, got %v", v.page, page)
+			}
+
+			if !reflect.DeepEqual(remaining, v.remaining) {
+				t.Errorf("expected remaining parameters %v, got %v", v.remaining, remaining)
+			}
+		})
+	}
+}
+
+func TestPageQuery(t *testing.T) {
+	cursor := Cursor{Time: time.Date(2020, 9, 23, 10, 24, 55, 0, time.UTC), ID: "4"}
+	page := Page{Limit: 10, Order: Descending, Cursor: &cursor}
+
+	condition, args := page.Condition("time", "id", 2)
+	if condition != "(time, id) < ($2, $3)" || !reflect.DeepEqual(args, []interface{}{cursor.Time, "4"}) {
+		t.Errorf("unexpected condition %s with arguments %v", condition, args)
+	}
+
+	condition, args = page.Condition("", "id", 2)
+	if condition != "id < $2" || !reflect.DeepEqual(args, []interface{}{"4"}) {
+		t.Errorf("unexpected condition %s with arguments %v", condition, args)
+	}
+
+	if order := page.OrderBy("time", "id"); order != "ORDER BY time DESC, id DESC" {
+		t.Errorf("unexpected order %s", order)
+	}
+
+	limits, args := page.Limits(4)
+	if limits != "LIMIT $4 OFFSET $5" || !reflect.DeepEqual(args, []interface{}{11, 0}) {
+		t.Errorf("unexpected limits %s with arguments %v", limits, args)
+	}
+
+	if next := page.Next(10, cursor); next != "" {
+		t.Errorf("expected no next cursor, got %s", next)
+	}
+
+	next := page.Next(11, cursor)
+	decoded, err := DecodeCursor(next)
+	if err != nil {
+		t.Fatalf("cannot decode next cursor: %s", err)
+	}
+
+	if !decoded.Time.Equal(cursor.Time) || decoded.ID != cursor.ID {
+		t.Errorf("expected cursor %v, got %v", cursor, decoded)
+	}
+}
@@ -0,0 +1,159 @@
+// Package retention deletes the stored data, which is older than the storage duration defined in the contracts
+package retention
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/klog"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/duration"
+)
+
+// tables contains the tables, which rows are pruned; every table references the contract_machine_sensors
+// and stores the time of its rows in the column time
+var tables = []string{"analysis_result", "update_message"}
+
+var (
+	prunedRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "retention_pruned_rows_total",
+		Help: "count of the rows, which are deleted, because their storage duration has ended",
+	}, []string{"table"})
+
+	expiredRows = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "retention_expired_rows",
+		Help: "count of the rows, which storage duration has ended at the last run; in dry run mode these rows are not deleted",
+	}, []string{"table"})
+
+	failedRuns = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "retention_failed_runs_total",
+		Help: "count of the retention runs, which have failed",
+	})
+)
+
+// Worker enforces the storage durations of the contracts
+type Worker interface {
+	// Run prunes the stored data periodically
+	Run()
+}
+
+// NewWorker creates a worker, which prunes the data of the system in the given interval. If dryRun is set,
+// the expired rows are only counted.
+func NewWorker(db *sql.DB, system string, interval time.Duration, dryRun bool) Worker {
+	return worker{db: db, system: system, interval: interval, dryRun: dryRun, now: time.Now}
+}
+
+type worker struct {
+	db       *sql.DB
+	system   string
+	interval time.Duration
+	dryRun   bool
+	now      func() time.Time
+}
+
+// durations returns the storage duration of every contract machine sensor of the system. If multiple
+// durations are defined, the longest one is used. A contract machine sensor with an invalid duration is
+// skipped, so that its data is kept and the other contract machine sensors are pruned nevertheless.
+func (w worker) durations() (map[int64]time.Duration, error) {
+	query, err := w.db.Query("SELECT sd.contract_machine_sensor, sd.duration FROM storage_duration AS sd JOIN systems sy on sd.system = sy.id WHERE sy.name = $1", w.system)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	durations := make(map[int64]time.Duration)
+	invalid := make(map[int64]bool)
+	for query.Next() {
+		var contractMachineSensor int64
+		var value string
+		if err := query.Scan(&contractMachineSensor, &value); err != nil {
+			return nil, err
+		}
+
+		storage, err := duration.Parse(value)
+		if err != nil {
+			klog.Errorf("skip contract machine sensor %d, its storage duration is not valid: %s", contractMachineSensor, err)
+			invalid[contractMachineSensor] = true
+			continue
+		}
+
+		if storage > durations[contractMachineSensor] {
+			durations[contractMachineSensor] = storage
+		}
+	}
+
+	for contractMachineSensor := range invalid {
+		delete(durations, contractMachineSensor)
+	}
+
+	return durations, query.Err()
+}
+
+// pruneTable deletes (or in dry run mode counts) the rows of the table, which are older than the deadline
+func (w worker) pruneTable(table string, contractMachineSensor int64, deadline time.Time) (int64, error) {
+	if w.dryRun {
+		var count int64
+		err := w.db.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s WHERE contract_machine_sensor = $1 AND time < $2", table), contractMachineSensor, deadline).Scan(&count)
+		return count, err
+	}
+
+	res, err := w.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE contract_machine_sensor = $1 AND time < $2", table), contractMachineSensor, deadline)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// prune enforces the storage durations once and returns the count of the expired rows per table
+func (w worker) prune() (map[string]int64, error) {
+	durations, err := w.durations()
+	if err != nil {
+		return nil, fmt.Errorf("cannot query storage durations: %s", err)
+	}
+
+	now := w.now()
+	expired := make(map[string]int64)
+	for contractMachineSensor, duration := range durations {
+		for _, table := range tables {
+			count, err := w.pruneTable(table, contractMachineSensor, now.Add(-duration))
+			if err != nil {
+				return nil, fmt.Errorf("cannot prune %s of contract machine sensor %d: %s", table, contractMachineSensor, err)
+			}
+			expired[table] += count
+			if !w.dryRun {
+				prunedRows.WithLabelValues(table).Add(float64(count))
+			}
+		}
+	}
+
+	for _, table := range tables {
+		expiredRows.WithLabelValues(table).Set(float64(expired[table]))
+	}
+
+	return expired, nil
+}
+
+func (w worker) Run() {
+	for {
+		expired, err := w.prune()
+		switch {
+		case err != nil:
+			failedRuns.Inc()
+			klog.Error(err)
+		case w.dryRun:
+			klog.Infof("retention dry run: %v rows would be deleted", expired)
+		default:
+			klog.Infof("retention: %v rows are deleted", expired)
+		}
+		time.Sleep(w.interval)
+	}
+}
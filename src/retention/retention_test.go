@@ -0,0 +1,128 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWorker_Prune(t *testing.T) {
+	now := time.Date(2020, 9, 23, 10, 0, 0, 0, time.UTC)
+	deadline := now.Add(-5 * time.Hour)
+
+	testTable := []struct {
+		description string
+		dryRun      bool
+		expect      func(mock sqlmock.Sqlmock)
+		expected    map[string]int64
+		pruned      float64
+	}{
+		{
+			"delete expired rows",
+			false,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM analysis_result WHERE contract_machine_sensor = $1 AND time < $2").
+					WithArgs(1, deadline).WillReturnResult(sqlmock.NewResult(0, 3))
+				mock.ExpectExec("DELETE FROM update_message WHERE contract_machine_sensor = $1 AND time < $2").
+					WithArgs(1, deadline).WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+			map[string]int64{"analysis_result": 3, "update_message": 2},
+			3,
+		},
+		{
+			"dry run",
+			true,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT count(*) FROM analysis_result WHERE contract_machine_sensor = $1 AND time < $2").
+					WithArgs(1, deadline).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+				mock.ExpectQuery("SELECT count(*) FROM update_message WHERE contract_machine_sensor = $1 AND time < $2").
+					WithArgs(1, deadline).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			},
+			map[string]int64{"analysis_result": 4, "update_message": 0},
+			0,
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("cannot create mocked database: %s", err)
+			}
+			defer db.Close()
+
+			// the longest duration of a contract machine sensor is used
+			mock.ExpectQuery("SELECT sd.contract_machine_sensor, sd.duration FROM storage_duration AS sd JOIN systems sy on sd.system = sy.id WHERE sy.name = $1").
+				WithArgs("cloud").
+				WillReturnRows(sqlmock.NewRows([]string{"contract_machine_sensor", "duration"}).
+					AddRow(1, "PT1H").
+					AddRow(1, "PT5H").
+					AddRow(1, "2h"))
+			v.expect(mock)
+
+			before := testutil.ToFloat64(prunedRows.WithLabelValues("analysis_result"))
+
+			w := worker{db: db, system: "cloud", dryRun: v.dryRun, now: func() time.Time { return now }}
+			expired, err := w.prune()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			for table, count := range v.expected {
+				if expired[table] != count {
+					t.Errorf("expected %d expired rows of %s, got %d", count, table, expired[table])
+				}
+
+				if gauge := testutil.ToFloat64(expiredRows.WithLabelValues(table)); gauge != float64(count) {
+					t.Errorf("expected expired rows metric of %s to be %d, got %f", table, count, gauge)
+				}
+			}
+
+			if pruned := testutil.ToFloat64(prunedRows.WithLabelValues("analysis_result")) - before; pruned != v.pruned {
+				t.Errorf("expected %f pruned rows, got %f", v.pruned, pruned)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestWorker_PruneInvalidDuration(t *testing.T) {
+	now := time.Date(2020, 9, 23, 10, 0, 0, 0, time.UTC)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("cannot create mocked database: %s", err)
+	}
+	defer db.Close()
+
+	// the contract machine sensor 2 is skipped, although one of its durations is valid
+	mock.ExpectQuery("SELECT sd.contract_machine_sensor, sd.duration FROM storage_duration AS sd JOIN systems sy on sd.system = sy.id WHERE sy.name = $1").
+		WithArgs("cloud").
+		WillReturnRows(sqlmock.NewRows([]string{"contract_machine_sensor", "duration"}).
+			AddRow(1, "PT5H").
+			AddRow(2, "five days").
+			AddRow(2, "PT1H"))
+	mock.ExpectExec("DELETE FROM analysis_result WHERE contract_machine_sensor = $1 AND time < $2").
+		WithArgs(1, now.Add(-5*time.Hour)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM update_message WHERE contract_machine_sensor = $1 AND time < $2").
+		WithArgs(1, now.Add(-5*time.Hour)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	w := worker{db: db, system: "cloud", now: func() time.Time { return now }}
+	expired, err := w.prune()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if expired["analysis_result"] != 1 || expired["update_message"] != 0 {
+		t.Errorf("unexpected expired rows: %v", expired)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
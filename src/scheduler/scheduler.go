@@ -0,0 +1,239 @@
+// Package scheduler triggers the execution of the pipelines defined in the contracts
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/duration"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/outbox"
+)
+
+const (
+	// TriggerTime executes a pipeline periodically after the duration of the trigger definition
+	TriggerTime = "time"
+	// TriggerEvent executes a pipeline, when new data of one of its sensors arrives
+	TriggerEvent = "event"
+)
+
+// ExecutionRequest requests the execution of a pipeline
+type ExecutionRequest struct {
+	Execution int64             `json:"execution"`
+	Contract  string            `json:"contract"`
+	Pipeline  string            `json:"pipeline"`
+	Index     int               `json:"index"`
+	Machine   string            `json:"machine"`
+	Sensors   []string          `json:"sensors"`
+	Trigger   string            `json:"trigger"`
+	Stages    []models.Pipeline `json:"stages"`
+	Input     Input             `json:"input"`
+	Timestamp string            `json:"timestamp"`
+}
+
+// Input is the input of an execution. An event triggered execution contains the received data of the sensor;
+// a time triggered execution contains the time range of the stored data, which has to be analysed.
+type Input struct {
+	Sensor string          `json:"sensor,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Start  string          `json:"start,omitempty"`
+	End    string          `json:"end,omitempty"`
+}
+
+// Scheduler triggers the pipelines of the active contracts
+type Scheduler interface {
+	// Run reloads the pipelines and executes the time triggered pipelines periodically
+	Run()
+
+	// Trigger executes the event triggered pipelines of the contract, which analyse the sensor
+	Trigger(contract, machine, sensor string, data []byte)
+}
+
+// NewScheduler creates a scheduler of the pipelines of the system, which checks the time triggers in the given
+// interval. The execution requests are queued on the topic, which template can contain the placeholders
+// {system}, {contract} and {pipeline}.
+func NewScheduler(store ExecutionStore, system string, messages outbox.Outbox, topic mqtt.Topic, interval time.Duration) Scheduler {
+	return &scheduler{
+		store:    store,
+		system:   system,
+		messages: messages,
+		topic:    topic,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+type scheduler struct {
+	store    ExecutionStore
+	system   string
+	messages outbox.Outbox
+	topic    mqtt.Topic
+	interval time.Duration
+	now      func() time.Time
+
+	mutex     sync.Mutex
+	pipelines []Pipeline
+	// last contains the time of the last execution of the time triggered pipelines
+	last map[PipelineID]time.Time
+}
+
+// refresh reloads the pipelines of the active contracts
+func (s *scheduler) refresh() error {
+	pipelines, err := s.store.Pipelines(s.system, s.now())
+	if err != nil {
+		return fmt.Errorf("cannot load pipelines: %s", err)
+	}
+
+	last, err := s.store.LastExecutions(TriggerTime)
+	if err != nil {
+		return fmt.Errorf("cannot load last executions: %s", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pipelines = pipelines
+	s.last = last
+	return nil
+}
+
+// execute stores the execution and queues its request
+func (s *scheduler) execute(pipeline Pipeline, input Input, now time.Time) error {
+	id, err := s.store.Create(pipeline, now)
+	if err != nil {
+		return fmt.Errorf("cannot create execution: %s", err)
+	}
+
+	data, err := json.Marshal(ExecutionRequest{
+		Execution: id,
+		Contract:  pipeline.Contract,
+		Pipeline:  pipeline.Key,
+		Index:     pipeline.Index,
+		Machine:   pipeline.Machine,
+		Sensors:   pipeline.Sensors,
+		Trigger:   pipeline.Trigger.Type,
+		Stages:    pipeline.Stages,
+		Input:     input,
+		Timestamp: now.UTC().Format(time.RFC3339),
+	})
+	var msg mqtt.Msg
+	if err == nil {
+		msg, err = s.topic.Msg(data, map[string]string{
+			"system":   s.system,
+			"contract": pipeline.Contract,
+			"pipeline": pipeline.Key,
+		})
+	}
+	if err == nil {
+		err = s.messages.Enqueue(msg)
+	}
+
+	if err != nil {
+		if stateErr := s.store.SetState(id, StateFailed); stateErr != nil {
+			klog.Errorf("cannot set state of execution %d: %s", id, stateErr)
+		}
+		return fmt.Errorf("cannot queue execution request %d: %s", id, err)
+	}
+
+	klog.Infof("requested execution %d of pipeline %s of contract %s", id, pipeline.Key, pipeline.Contract)
+	return nil
+}
+
+// dueExecution is a time triggered pipeline, which has to be executed; start is the time of the last execution
+type dueExecution struct {
+	pipeline Pipeline
+	start    time.Time
+}
+
+// due returns the time triggered pipelines, which have to be executed
+func (s *scheduler) due(now time.Time) []dueExecution {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var due []dueExecution
+	for _, pipeline := range s.pipelines {
+		if pipeline.Trigger.Type != TriggerTime {
+			continue
+		}
+
+		if pipeline.Trigger.Definition == nil {
+			klog.Errorf("time trigger of pipeline %d of contract %s has no definition", pipeline.Index, pipeline.Contract)
+			continue
+		}
+
+		after, err := duration.Parse(pipeline.Trigger.Definition.After)
+		if err != nil {
+			klog.Errorf("invalid time trigger %q of pipeline %d of contract %s", pipeline.Trigger.Definition.After, pipeline.Index, pipeline.Contract)
+			continue
+		}
+
+		// without a previous execution, the pipeline is executed after the start of the contract
+		last, ok := s.last[pipeline.ID()]
+		if !ok {
+			last = pipeline.Start
+		}
+
+		if now.Sub(last) >= after {
+			due = append(due, dueExecution{pipeline: pipeline, start: last})
+		}
+	}
+
+	return due
+}
+
+// schedule executes the due time triggered pipelines. The time of the last execution only advances, if the
+// execution is requested, so that a failed execution is retried with the same start at the next check.
+func (s *scheduler) schedule() {
+	now := s.now()
+	for _, due := range s.due(now) {
+		input := Input{Start: due.start.UTC().Format(time.RFC3339), End: now.UTC().Format(time.RFC3339)}
+		if err := s.execute(due.pipeline, input, now); err != nil {
+			klog.Error(err)
+			continue
+		}
+
+		s.mutex.Lock()
+		s.last[due.pipeline.ID()] = now
+		s.mutex.Unlock()
+	}
+}
+
+func (s *scheduler) Trigger(contract, machine, sensor string, data []byte) {
+	var matching []Pipeline
+	s.mutex.Lock()
+	for _, pipeline := range s.pipelines {
+		if pipeline.Contract != contract || pipeline.Machine != machine || pipeline.Trigger.Type != TriggerEvent {
+			continue
+		}
+
+		for _, name := range pipeline.Sensors {
+			if name == sensor {
+				matching = append(matching, pipeline)
+				break
+			}
+		}
+	}
+	s.mutex.Unlock()
+
+	now := s.now()
+	for _, pipeline := range matching {
+		if err := s.execute(pipeline, Input{Sensor: sensor, Data: data}, now); err != nil {
+			klog.Error(err)
+		}
+	}
+}
+
+func (s *scheduler) Run() {
+	for {
+		if err := s.refresh(); err != nil {
+			klog.Error(err)
+		} else {
+			s.schedule()
+		}
+		time.Sleep(s.interval)
+	}
+}
@@ -0,0 +1,267 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/mqtt"
+)
+
+// testStore returns the pipelines and records the created executions and their states
+type testStore struct {
+	pipelines []Pipeline
+	last      map[PipelineID]time.Time
+	created   []Pipeline
+	states    map[int64]State
+}
+
+func (s *testStore) Pipelines(string, time.Time) ([]Pipeline, error) {
+	return s.pipelines, nil
+}
+
+func (s *testStore) LastExecutions(string) (map[PipelineID]time.Time, error) {
+	last := make(map[PipelineID]time.Time)
+	for id, requested := range s.last {
+		last[id] = requested
+	}
+	return last, nil
+}
+
+func (s *testStore) Create(pipeline Pipeline, _ time.Time) (int64, error) {
+	s.created = append(s.created, pipeline)
+	id := int64(len(s.created))
+	s.states[id] = StateRequested
+	return id, nil
+}
+
+func (s *testStore) SetState(execution int64, state State) error {
+	s.states[execution] = state
+	return nil
+}
+
+func (s *testStore) Complete(execution int64, _ []string) error {
+	return s.SetState(execution, StateCompleted)
+}
+
+func (s *testStore) Fail(execution int64) error {
+	return s.SetState(execution, StateFailed)
+}
+
+// testOutbox records the queued messages and fails, if err is set
+type testOutbox struct {
+	messages []mqtt.Msg
+	err      error
+}
+
+func (o *testOutbox) Enqueue(msg mqtt.Msg) error {
+	if o.err != nil {
+		return o.err
+	}
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+var (
+	contractStart = time.Date(2020, 9, 23, 10, 0, 0, 0, time.UTC)
+	timePipeline  = Pipeline{
+		Contract: "c1",
+		Key:      "time",
+		Index:    0,
+		Machine:  "m1",
+		Sensors:  []string{"s1"},
+		Trigger:  models.Trigger{Type: TriggerTime, Definition: &models.TriggerDefinition{After: "1h"}},
+		Start:    contractStart,
+	}
+	eventPipeline = Pipeline{
+		Contract: "c1",
+		Key:      "event",
+		Index:    1,
+		Machine:  "m1",
+		Sensors:  []string{"s1", "s2"},
+		Trigger:  models.Trigger{Type: TriggerEvent},
+		Start:    contractStart,
+	}
+)
+
+func newTestScheduler(store *testStore, messages *testOutbox, now time.Time) *scheduler {
+	return &scheduler{
+		store:    store,
+		system:   "cloud",
+		messages: messages,
+		topic:    mqtt.Topic{Template: mqtt.DefaultExecutionRequestTopic, QoS: 1},
+		interval: time.Minute,
+		now:      func() time.Time { return now },
+	}
+}
+
+func TestScheduler_schedule(t *testing.T) {
+	testTable := []struct {
+		description string
+		now         time.Time
+		last        map[PipelineID]time.Time
+		executions  int
+		start       string
+	}{
+		{"not due after contract start", contractStart.Add(30 * time.Minute), nil, 0, ""},
+		{"due after contract start", contractStart.Add(time.Hour), nil, 1, "2020-09-23T10:00:00Z"},
+		{"not due after last execution", contractStart.Add(90 * time.Minute), map[PipelineID]time.Time{timePipeline.ID(): contractStart.Add(time.Hour)}, 0, ""},
+		{"due after last execution", contractStart.Add(2 * time.Hour), map[PipelineID]time.Time{timePipeline.ID(): contractStart.Add(time.Hour)}, 1, "2020-09-23T11:00:00Z"},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			store := &testStore{pipelines: []Pipeline{timePipeline, eventPipeline}, last: v.last, states: make(map[int64]State)}
+			messages := &testOutbox{}
+			s := newTestScheduler(store, messages, v.now)
+
+			if err := s.refresh(); err != nil {
+				t.Fatalf("cannot refresh: %s", err)
+			}
+			s.schedule()
+
+			if len(messages.messages) != v.executions {
+				t.Fatalf("expected %d executions, got %d", v.executions, len(messages.messages))
+			}
+
+			// the pipeline is not executed again before the next period
+			s.schedule()
+			if len(messages.messages) != v.executions {
+				t.Fatalf("pipeline executed twice")
+			}
+
+			if v.executions == 0 {
+				return
+			}
+
+			msg := messages.messages[0]
+			if msg.Topic != "kosmos/pipeline/cloud/c1/time/execute" {
+				t.Errorf("unexpected topic %s", msg.Topic)
+			}
+
+			var request ExecutionRequest
+			if err := json.Unmarshal(msg.Msg, &request); err != nil {
+				t.Fatalf("cannot parse request: %s", err)
+			}
+
+			if request.Execution != 1 || request.Trigger != TriggerTime || request.Input.Start != v.start ||
+				request.Input.End != v.now.Format(time.RFC3339) {
+				t.Errorf("unexpected request: %v", request)
+			}
+		})
+	}
+}
+
+func TestScheduler_scheduleFailed(t *testing.T) {
+	store := &testStore{pipelines: []Pipeline{timePipeline}, states: make(map[int64]State)}
+	messages := &testOutbox{err: fmt.Errorf("error")}
+	s := newTestScheduler(store, messages, contractStart.Add(time.Hour))
+
+	if err := s.refresh(); err != nil {
+		t.Fatalf("cannot refresh: %s", err)
+	}
+	s.schedule()
+
+	if store.states[1] != StateFailed {
+		t.Errorf("expected state %q, got %q", StateFailed, store.states[1])
+	}
+
+	// the failed execution is retried with the same start
+	messages.err = nil
+	s.schedule()
+
+	if len(messages.messages) != 1 {
+		t.Fatalf("expected retried execution, got %d executions", len(messages.messages))
+	}
+
+	var request ExecutionRequest
+	if err := json.Unmarshal(messages.messages[0].Msg, &request); err != nil {
+		t.Fatalf("cannot parse request: %s", err)
+	}
+
+	if request.Execution != 2 || request.Input.Start != "2020-09-23T10:00:00Z" {
+		t.Errorf("unexpected request: %v", request)
+	}
+}
+
+func TestPipelineKey(t *testing.T) {
+	trigger := models.Trigger{Type: TriggerTime, Definition: &models.TriggerDefinition{After: "1h"}}
+
+	key, err := pipelineKey(trigger, []string{"s1"}, nil)
+	if err != nil {
+		t.Fatalf("cannot derive key: %s", err)
+	}
+
+	same, err := pipelineKey(trigger, []string{"s1"}, nil)
+	if err != nil {
+		t.Fatalf("cannot derive key: %s", err)
+	}
+
+	other, err := pipelineKey(trigger, []string{"s2"}, nil)
+	if err != nil {
+		t.Fatalf("cannot derive key: %s", err)
+	}
+
+	if key != same || key == other {
+		t.Errorf("unexpected keys %s, %s and %s", key, same, other)
+	}
+}
+
+func TestScheduler_Trigger(t *testing.T) {
+	testTable := []struct {
+		description string
+		contract    string
+		machine     string
+		sensor      string
+		err         error
+		executions  int
+		state       State
+	}{
+		{"matching sensor", "c1", "m1", "s2", nil, 1, StateRequested},
+		{"other sensor", "c1", "m1", "s3", nil, 0, ""},
+		{"other machine", "c1", "m2", "s1", nil, 0, ""},
+		{"other contract", "c2", "m1", "s1", nil, 0, ""},
+		{"outbox not available", "c1", "m1", "s1", fmt.Errorf("error"), 0, StateFailed},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			store := &testStore{pipelines: []Pipeline{timePipeline, eventPipeline}, states: make(map[int64]State)}
+			messages := &testOutbox{err: v.err}
+			s := newTestScheduler(store, messages, contractStart)
+
+			if err := s.refresh(); err != nil {
+				t.Fatalf("cannot refresh: %s", err)
+			}
+			s.Trigger(v.contract, v.machine, v.sensor, []byte(`{"value":1}`))
+
+			if len(messages.messages) != v.executions {
+				t.Fatalf("expected %d executions, got %d", v.executions, len(messages.messages))
+			}
+
+			if store.states[1] != v.state {
+				t.Errorf("expected state %q, got %q", v.state, store.states[1])
+			}
+
+			if v.executions == 0 {
+				return
+			}
+
+			var request ExecutionRequest
+			if err := json.Unmarshal(messages.messages[0].Msg, &request); err != nil {
+				t.Fatalf("cannot parse request: %s", err)
+			}
+
+			if request.Pipeline != "event" || request.Index != 1 || request.Input.Sensor != v.sensor || string(request.Input.Data) != `{"value":1}` {
+				t.Errorf("unexpected request: %v", request)
+			}
+
+			if !reflect.DeepEqual(request.Sensors, eventPipeline.Sensors) {
+				t.Errorf("unexpected sensors: %v", request.Sensors)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package scheduler
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"k8s.io/klog"
+
+	"github.com/kosmos-industrie40/kosmos-analyses-cloud-connector/src/endpoints/contract/models"
+)
+
+// State is the state of a pipeline execution
+type State string
+
+const (
+	// StateRequested is the state of an execution, which request is queued
+	StateRequested State = "requested"
+	// StateFailed is the state of an execution, which request cannot be queued or which result cannot be stored
+	StateFailed State = "failed"
+	// StateCompleted is the state of an execution, which result is stored
+	StateCompleted State = "completed"
+)
+
+// Pipeline is a pipeline of an active contract, which is executed by the system
+type Pipeline struct {
+	Contract string
+	// Key identifies the pipeline in the contract; it is derived from the trigger, the sensors and the stages,
+	// so that it does not change, if other pipelines of the contract are added or removed
+	Key string
+	// Index is the position of the pipeline in the pipelines of the system
+	Index   int
+	Machine string
+	Sensors []string
+	Trigger models.Trigger
+	Stages  []models.Pipeline
+	// Start is the start of the validity window of the contract
+	Start time.Time
+}
+
+// PipelineID identifies a pipeline
+type PipelineID struct {
+	Contract string
+	Key      string
+}
+
+// ID returns the id of the pipeline
+func (p Pipeline) ID() PipelineID {
+	return PipelineID{Contract: p.Contract, Key: p.Key}
+}
+
+// pipelineKey derives the key of a pipeline from its definition
+func pipelineKey(trigger models.Trigger, sensors []string, stages []models.Pipeline) (string, error) {
+	data, err := json.Marshal(struct {
+		Trigger models.Trigger    `json:"trigger"`
+		Sensors []string          `json:"sensors"`
+		Stages  []models.Pipeline `json:"stages"`
+	}{trigger, sensors, stages})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:8]), nil
+}
+
+// ExecutionStore stores the pipelines and the state of their executions
+type ExecutionStore interface {
+	// Pipelines returns the enabled pipelines of the system of all active contracts
+	Pipelines(system string, now time.Time) ([]Pipeline, error)
+
+	// LastExecutions returns the time of the last execution of every pipeline with the trigger type
+	LastExecutions(triggerType string) (map[PipelineID]time.Time, error)
+
+	// Create stores a new execution in the state requested and returns its id
+	Create(pipeline Pipeline, requested time.Time) (int64, error)
+
+	// SetState sets the state of an execution
+	SetState(execution int64, state State) error
+
+	// Complete marks a requested execution as completed, if it belongs to one of the contracts,
+	// in which its result is stored
+	Complete(execution int64, contracts []string) error
+
+	// Fail marks a requested execution as failed, because its result cannot be stored
+	Fail(execution int64) error
+}
+
+// NewExecutionStore creates a store, which uses the contracts and the executions of the database
+func NewExecutionStore(db *sql.DB) ExecutionStore {
+	return executionStore{db: db}
+}
+
+type executionStore struct {
+	db *sql.DB
+}
+
+// pipelines returns the enabled pipelines of the system of the contract
+func pipelines(contract models.Contract, system string) ([]Pipeline, error) {
+	body := contract.Body
+	if !body.Analysis.Enable {
+		return nil, nil
+	}
+
+	start, err := time.Parse(time.RFC3339, body.Contract.Valid.Start)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse start of contract %s: %s", body.Contract.ID, err)
+	}
+
+	var result []Pipeline
+	for _, analysisSystem := range body.Analysis.Systems {
+		if analysisSystem.Name != system || !analysisSystem.Enable {
+			continue
+		}
+
+		for index, pipeline := range analysisSystem.Pipelines {
+			key, err := pipelineKey(pipeline.Trigger, pipeline.Sensors, pipeline.Pipeline)
+			if err != nil {
+				return nil, fmt.Errorf("cannot derive key of pipeline %d of contract %s: %s", index, body.Contract.ID, err)
+			}
+
+			result = append(result, Pipeline{
+				Contract: body.Contract.ID,
+				Key:      key,
+				Index:    index,
+				Machine:  body.Machine,
+				Sensors:  pipeline.Sensors,
+				Trigger:  pipeline.Trigger,
+				Stages:   pipeline.Pipeline,
+				Start:    start,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func (e executionStore) Pipelines(system string, now time.Time) ([]Pipeline, error) {
+	query, err := e.db.Query("SELECT contract FROM contracts WHERE active AND NOT expired AND start_time <= $1 AND end_time > $1", now)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	var result []Pipeline
+	for query.Next() {
+		var data string
+		if err := query.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var contract models.Contract
+		if err := json.Unmarshal([]byte(data), &contract); err != nil {
+			return nil, err
+		}
+
+		contractPipelines, err := pipelines(contract, system)
+		if err != nil {
+			klog.Errorf("skip pipelines: %s", err)
+			continue
+		}
+		result = append(result, contractPipelines...)
+	}
+
+	return result, query.Err()
+}
+
+func (e executionStore) LastExecutions(triggerType string) (map[PipelineID]time.Time, error) {
+	query, err := e.db.Query("SELECT contract, pipeline_key, max(requested) FROM pipeline_executions WHERE trigger = $1 AND pipeline_key IS NOT NULL AND state <> $2 GROUP BY contract, pipeline_key", triggerType, StateFailed)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	executions := make(map[PipelineID]time.Time)
+	for query.Next() {
+		var id PipelineID
+		var requested time.Time
+		if err := query.Scan(&id.Contract, &id.Key, &requested); err != nil {
+			return nil, err
+		}
+		executions[id] = requested
+	}
+
+	return executions, query.Err()
+}
+
+func (e executionStore) Create(pipeline Pipeline, requested time.Time) (int64, error) {
+	var id int64
+	err := e.db.QueryRow(
+		"INSERT INTO pipeline_executions (contract, pipeline, pipeline_key, trigger, state, requested, updated) VALUES ($1, $2, $3, $4, $5, $6, $6) RETURNING id",
+		pipeline.Contract,
+		pipeline.Index,
+		pipeline.Key,
+		pipeline.Trigger.Type,
+		StateRequested,
+		requested,
+	).Scan(&id)
+	return id, err
+}
+
+func (e executionStore) SetState(execution int64, state State) error {
+	_, err := e.db.Exec("UPDATE pipeline_executions SET state = $2, updated = NOW() WHERE id = $1", execution, state)
+	return err
+}
+
+func (e executionStore) Complete(execution int64, contracts []string) error {
+	_, err := e.db.Exec("UPDATE pipeline_executions SET state = $2, updated = NOW() WHERE id = $1 AND state = $3 AND contract = ANY($4)", execution, StateCompleted, StateRequested, pq.Array(contracts))
+	return err
+}
+
+func (e executionStore) Fail(execution int64) error {
+	_, err := e.db.Exec("UPDATE pipeline_executions SET state = $2, updated = NOW() WHERE id = $1 AND state = $3", execution, StateFailed, StateRequested)
+	return err
+}
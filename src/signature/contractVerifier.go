@@ -0,0 +1,79 @@
+package signature
+
+import (
+	"database/sql"
+	"errors"
+
+	"k8s.io/klog"
+)
+
+// ErrContractNotFound is returned, if the contract of a message does not exist
+var ErrContractNotFound = errors.New("contract not found")
+
+// ContractVerifier verifies the signatures of messages, which belong to a contract
+type ContractVerifier interface {
+	// Verify checks the signature of the body, if the contract requires the validation of
+	// signatures. The signature has to be created by one of the partners of the contract.
+	Verify(contract string, body interface{}, signature Signature) error
+}
+
+// NewContractVerifier creates a new contract verifier, which reads the signature settings
+// and the partners of the contract from the database
+func NewContractVerifier(db *sql.DB, verifier Verifier) ContractVerifier {
+	return contractVerifier{db: db, verifier: verifier}
+}
+
+type contractVerifier struct {
+	db       *sql.DB
+	verifier Verifier
+}
+
+func (c contractVerifier) Verify(contract string, body interface{}, signature Signature) error {
+	required, partners, err := c.partners(contract)
+	if err != nil {
+		return err
+	}
+
+	if !required {
+		return nil
+	}
+
+	return c.verifier.Verify(partners, body, signature)
+}
+
+// partners returns if the contract requires the validation of signatures and the names of the partner organisations
+func (c contractVerifier) partners(contract string) (bool, []string, error) {
+	query, err := c.db.Query("SELECT c.validate_signature, o.name FROM contracts AS c LEFT JOIN partners p on p.contract = c.id LEFT JOIN organisations o on p.organisation = o.id WHERE c.id = $1", contract)
+	if err != nil {
+		return false, nil, err
+	}
+
+	defer func() {
+		if err := query.Close(); err != nil {
+			klog.Errorf("cannot close query object: %s", err)
+		}
+	}()
+
+	found := false
+	var required bool
+	var partners []string
+	for query.Next() {
+		var validate sql.NullBool
+		var name sql.NullString
+		if err := query.Scan(&validate, &name); err != nil {
+			return false, nil, err
+		}
+
+		found = true
+		required = validate.Bool
+		if name.Valid {
+			partners = append(partners, name.String)
+		}
+	}
+
+	if !found {
+		return false, nil, ErrContractNotFound
+	}
+
+	return required, partners, nil
+}
@@ -0,0 +1,129 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Key is a public key of an organisation. If the key is loaded from a certificate,
+// the certificate is set.
+type Key struct {
+	PublicKey   crypto.PublicKey
+	Certificate *x509.Certificate
+}
+
+// Matches checks if the key belongs to the serial number. Keys without a certificate
+// match only an empty serial number, an empty serial number matches every key.
+// The serial number can be transmitted as decimal or as hex string (with or without separators).
+func (k Key) Matches(serialNumber string) bool {
+	if serialNumber == "" {
+		return true
+	}
+
+	if k.Certificate == nil {
+		return false
+	}
+
+	if serial, ok := new(big.Int).SetString(serialNumber, 10); ok && serial.Cmp(k.Certificate.SerialNumber) == 0 {
+		return true
+	}
+
+	hex := strings.NewReplacer(":", "", "-", "", " ", "").Replace(serialNumber)
+	serial, ok := new(big.Int).SetString(hex, 16)
+	return ok && serial.Cmp(k.Certificate.SerialNumber) == 0
+}
+
+// KeyStore provides the public keys of an organisation
+type KeyStore interface {
+	// Keys returns all valid public keys of the organisation
+	Keys(organisation string) ([]Key, error)
+}
+
+// NewFileKeyStore creates a key store, which reads the keys from a local directory. Every
+// organisation has its own sub directory, which contains the PEM encoded public keys or
+// certificates (*.pem). The files will be read on every request, so that keys can be
+// changed without a restart. Certificates are only used inside of their validity period.
+func NewFileKeyStore(directory string) KeyStore {
+	return fileKeyStore{directory: directory}
+}
+
+type fileKeyStore struct {
+	directory string
+}
+
+func (f fileKeyStore) Keys(organisation string) ([]Key, error) {
+	if organisation == "" || organisation != filepath.Base(organisation) || organisation == ".." {
+		return nil, fmt.Errorf("invalid organisation name: %q", organisation)
+	}
+
+	files, err := filepath.Glob(filepath.Join(f.directory, organisation, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		fileKeys, err := parseKeys(data, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse keys in %s: %s", file, err)
+		}
+
+		keys = append(keys, fileKeys...)
+	}
+
+	return keys, nil
+}
+
+// parseKeys parses all PEM blocks of the data. Certificates, which are not valid at the time now are skipped.
+func parseKeys(data []byte, now time.Time) ([]Key, error) {
+	var keys []Key
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return keys, nil
+		}
+
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, Key{PublicKey: key})
+		case "RSA PUBLIC KEY":
+			key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, Key{PublicKey: key})
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+				continue
+			}
+			keys = append(keys, Key{PublicKey: cert.PublicKey, Certificate: cert})
+		default:
+			return nil, fmt.Errorf("unsupported pem block type: %s", block.Type)
+		}
+	}
+}
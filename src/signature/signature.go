@@ -0,0 +1,227 @@
+// Package signature provides the verification of the cryptographic signatures,
+// which are transmitted together with the messages of the KOSMoS partners
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidSignature will be returned, if no key of the organisations matches the signature
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Signature is a base64 encoded signature. The algorithm and the serial number of the
+// certificate are optional and restrict the keys, which are used for the verification.
+// In messages the signature is transmitted either as string or like the signature of a
+// contract together with its meta data.
+type Signature struct {
+	Value        string
+	Algorithm    string
+	SerialNumber string
+}
+
+// signatureJSON is the representation of a signature with meta data
+type signatureJSON struct {
+	Signature string `json:"signature"`
+	Meta      struct {
+		Algorithm    string `json:"algorithm,omitempty"`
+		SerialNumber string `json:"serialNumber,omitempty"`
+	} `json:"meta"`
+}
+
+func (s Signature) MarshalJSON() ([]byte, error) {
+	if s.Algorithm == "" && s.SerialNumber == "" {
+		return json.Marshal(s.Value)
+	}
+
+	var sig signatureJSON
+	sig.Signature = s.Value
+	sig.Meta.Algorithm = s.Algorithm
+	sig.Meta.SerialNumber = s.SerialNumber
+	return json.Marshal(sig)
+}
+
+func (s *Signature) UnmarshalJSON(data []byte) error {
+	if len(bytes.TrimSpace(data)) > 0 && bytes.TrimSpace(data)[0] == '"' {
+		*s = Signature{}
+		return json.Unmarshal(data, &s.Value)
+	}
+
+	var sig signatureJSON
+	if err := json.Unmarshal(data, &sig); err != nil {
+		return err
+	}
+	*s = Signature{Value: sig.Signature, Algorithm: sig.Meta.Algorithm, SerialNumber: sig.Meta.SerialNumber}
+	return nil
+}
+
+// Verifier checks the signatures of messages against the keys of the organisations
+type Verifier interface {
+	// Verify checks if the signature is a valid signature of the body, which is created
+	// by one of the organisations. The body will be serialized as canonical json.
+	Verify(organisations []string, body interface{}, signature Signature) error
+}
+
+// NewVerifier creates a new verifier, which uses the key store to find the keys of the organisations
+func NewVerifier(store KeyStore) Verifier {
+	return verifier{store: store}
+}
+
+type verifier struct {
+	store KeyStore
+}
+
+// algorithm defines the hash function and the signature scheme of a signature algorithm
+type algorithm struct {
+	hash   crypto.Hash
+	verify func(key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool
+}
+
+// algorithms contains the supported algorithms, named as defined in RFC 7518
+var algorithms = map[string]algorithm{
+	"ES256": {crypto.SHA256, verifyECDSA},
+	"ES384": {crypto.SHA384, verifyECDSA},
+	"ES512": {crypto.SHA512, verifyECDSA},
+	"RS256": {crypto.SHA256, verifyPKCS1v15},
+	"RS384": {crypto.SHA384, verifyPKCS1v15},
+	"RS512": {crypto.SHA512, verifyPKCS1v15},
+	"PS256": {crypto.SHA256, verifyPSS},
+	"PS384": {crypto.SHA384, verifyPSS},
+	"PS512": {crypto.SHA512, verifyPSS},
+}
+
+// defaultAlgorithm is used if the signature does not name an algorithm. The
+// signature scheme is selected by the type of the key.
+var defaultAlgorithm = algorithm{crypto.SHA256, func(key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool {
+	switch key.(type) {
+	case *ecdsa.PublicKey:
+		return verifyECDSA(key, hash, digest, sig)
+	case *rsa.PublicKey:
+		return verifyPKCS1v15(key, hash, digest, sig)
+	default:
+		return false
+	}
+}}
+
+func (v verifier) Verify(organisations []string, body interface{}, signature Signature) error {
+	alg := defaultAlgorithm
+	if signature.Algorithm != "" {
+		var ok bool
+		if alg, ok = algorithms[strings.ToUpper(signature.Algorithm)]; !ok {
+			return fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidSignature, signature.Algorithm)
+		}
+	}
+
+	data, err := Canonicalize(body)
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature.Value)
+	if err != nil || len(sig) == 0 {
+		return ErrInvalidSignature
+	}
+
+	h := alg.hash.New()
+	h.Write(data)
+	digest := h.Sum(nil)
+
+	for _, organisation := range organisations {
+		keys, err := v.store.Keys(organisation)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if !key.Matches(signature.SerialNumber) {
+				continue
+			}
+
+			if alg.verify(key.PublicKey, alg.hash, digest, sig) {
+				return nil
+			}
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+func verifyECDSA(key crypto.PublicKey, _ crypto.Hash, digest, sig []byte) bool {
+	k, ok := key.(*ecdsa.PublicKey)
+	return ok && ecdsa.VerifyASN1(k, digest, sig)
+}
+
+func verifyPKCS1v15(key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool {
+	k, ok := key.(*rsa.PublicKey)
+	return ok && rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+}
+
+func verifyPSS(key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool {
+	k, ok := key.(*rsa.PublicKey)
+	return ok && rsa.VerifyPSS(k, hash, digest, sig, nil) == nil
+}
+
+// Canonicalize serializes the value as canonical json. The keys of all objects are sorted,
+// insignificant whitespaces are removed and numbers are kept as transmitted. A json.RawMessage is
+// canonicalized as it is, without dropping the fields, which are not known to the connector.
+func Canonicalize(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(generic); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(buffer.Bytes(), "\n"), nil
+}
+
+// transmitted is a signed json object; only the body is covered by the signature
+type transmitted struct {
+	Body json.RawMessage `json:"body"`
+}
+
+// TransmittedBody returns the body of the signed json object as it is transmitted, so that the signature is verified
+// against the fields, which are unknown to the connector, as well.
+func TransmittedBody(data []byte) (json.RawMessage, error) {
+	var object transmitted
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	return object.Body, nil
+}
+
+// TransmittedBodies returns the bodies of a json array of signed objects as they are transmitted
+func TransmittedBodies(data []byte) ([]json.RawMessage, error) {
+	var objects []transmitted
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, err
+	}
+
+	bodies := make([]json.RawMessage, len(objects))
+	for i, object := range objects {
+		bodies[i] = object.Body
+	}
+	return bodies, nil
+}
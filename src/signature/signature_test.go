@@ -0,0 +1,430 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	dbMock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func writePublicKey(t *testing.T, directory, organisation string, key interface{}) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("cannot marshal public key: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(directory, organisation), 0700); err != nil {
+		t.Fatalf("cannot create organisation directory: %s", err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(directory, organisation, "key.pem"), data, 0600); err != nil {
+		t.Fatalf("cannot write public key: %s", err)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	testTable := []struct {
+		description string
+		value       interface{}
+		expected    string
+	}{
+		{
+			"sorted keys",
+			map[string]interface{}{"b": 1, "a": "x"},
+			`{"a":"x","b":1}`,
+		},
+		{
+			"struct fields are sorted",
+			struct {
+				Z string `json:"z"`
+				A []int  `json:"a"`
+			}{"<z>", []int{2, 1}},
+			`{"a":[2,1],"z":"<z>"}`,
+		},
+		{
+			"numbers are kept",
+			map[string]interface{}{"n": 12345678901234567},
+			`{"n":12345678901234567}`,
+		},
+		{
+			"transmitted json",
+			json.RawMessage(`{ "b": {"y": 1.50, "x": true}, "a": "<a>" }`),
+			`{"a":"<a>","b":{"x":true,"y":1.50}}`,
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			data, err := Canonicalize(v.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if string(data) != v.expected {
+				t.Errorf("canonical json != expected json\n\t%s != %s", data, v.expected)
+			}
+		})
+	}
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	directory, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(directory)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	writePublicKey(t, directory, "org", &key.PublicKey)
+
+	body := map[string]string{"contract": "contract", "blame": "org"}
+	data, err := Canonicalize(body)
+	if err != nil {
+		t.Fatalf("cannot canonicalize body: %s", err)
+	}
+	digest := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("cannot sign body: %s", err)
+	}
+	validSignature := base64.StdEncoding.EncodeToString(sig)
+
+	testTable := []struct {
+		description   string
+		organisations []string
+		body          interface{}
+		signature     Signature
+		err           error
+	}{
+		{
+			"valid signature",
+			[]string{"other", "org"},
+			body,
+			Signature{Value: validSignature},
+			nil,
+		},
+		{
+			"transmitted body",
+			[]string{"org"},
+			json.RawMessage(`{"blame": "org", "contract": "contract"}`),
+			Signature{Value: validSignature},
+			nil,
+		},
+		{
+			"transmitted body with unknown field",
+			[]string{"org"},
+			json.RawMessage(`{"blame": "org", "contract": "contract", "reason": "unsigned"}`),
+			Signature{Value: validSignature},
+			ErrInvalidSignature,
+		},
+		{
+			"modified body",
+			[]string{"org"},
+			map[string]string{"contract": "contract", "blame": "other"},
+			Signature{Value: validSignature},
+			ErrInvalidSignature,
+		},
+		{
+			"unknown organisation",
+			[]string{"other"},
+			body,
+			Signature{Value: validSignature},
+			ErrInvalidSignature,
+		},
+		{
+			"empty signature",
+			[]string{"org"},
+			body,
+			Signature{},
+			ErrInvalidSignature,
+		},
+		{
+			"explicit algorithm",
+			[]string{"org"},
+			body,
+			Signature{Value: validSignature, Algorithm: "ES256"},
+			nil,
+		},
+		{
+			"wrong algorithm",
+			[]string{"org"},
+			body,
+			Signature{Value: validSignature, Algorithm: "RS256"},
+			ErrInvalidSignature,
+		},
+		{
+			"serial number without certificate",
+			[]string{"org"},
+			body,
+			Signature{Value: validSignature, SerialNumber: "01"},
+			ErrInvalidSignature,
+		},
+	}
+
+	verifier := NewVerifier(NewFileKeyStore(directory))
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			err := verifier.Verify(v.organisations, v.body, v.signature)
+			if !errors.Is(err, v.err) {
+				t.Errorf("returned error != expected error\n\t%s != %s", err, v.err)
+			}
+		})
+	}
+}
+
+func TestTransmittedBodies(t *testing.T) {
+	data := []byte(`[{"body": {"b": 1, "unknown": [1.0]}, "signature": {"signature": "abc"}}, {"signature": {}}]`)
+
+	bodies, err := TransmittedBodies(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []json.RawMessage{json.RawMessage(`{"b": 1, "unknown": [1.0]}`), nil}
+	if !reflect.DeepEqual(bodies, expected) {
+		t.Errorf("returned bodies != expected bodies\n\t%s != %s", bodies, expected)
+	}
+
+	body, err := TransmittedBody([]byte(`{"signature": {"signature": "abc"}, "body": {"b": 1, "unknown": [1.0]}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != string(expected[0]) {
+		t.Errorf("returned body != expected body\n\t%s != %s", body, expected[0])
+	}
+}
+
+func TestFileKeyStore_InvalidOrganisation(t *testing.T) {
+	store := NewFileKeyStore(".")
+	for _, organisation := range []string{"", "..", "../etc", "a/b"} {
+		if _, err := store.Keys(organisation); err == nil {
+			t.Errorf("expected error for organisation %q", organisation)
+		}
+	}
+}
+
+func TestVerifier_VerifyRSACertificate(t *testing.T) {
+	directory, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(directory)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0x724e),
+		Subject:      pkix.Name{CommonName: "org"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(directory, "org"), 0700); err != nil {
+		t.Fatalf("cannot create organisation directory: %s", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(directory, "org", "cert.pem"), data, 0600); err != nil {
+		t.Fatalf("cannot write certificate: %s", err)
+	}
+
+	body := map[string]string{"machine": "machine"}
+	canonical, err := Canonicalize(body)
+	if err != nil {
+		t.Fatalf("cannot canonicalize body: %s", err)
+	}
+	digest := sha256.Sum256(canonical)
+
+	pkcs, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("cannot sign body: %s", err)
+	}
+	pss, err := rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest[:], nil)
+	if err != nil {
+		t.Fatalf("cannot sign body: %s", err)
+	}
+
+	testTable := []struct {
+		description string
+		signature   Signature
+		err         error
+	}{
+		{
+			"pkcs1 without meta data",
+			Signature{Value: base64.StdEncoding.EncodeToString(pkcs)},
+			nil,
+		},
+		{
+			"pkcs1 with hex serial number",
+			Signature{Value: base64.StdEncoding.EncodeToString(pkcs), Algorithm: "RS256", SerialNumber: "72-4E"},
+			nil,
+		},
+		{
+			"pss",
+			Signature{Value: base64.StdEncoding.EncodeToString(pss), Algorithm: "PS256"},
+			nil,
+		},
+		{
+			"pss without algorithm",
+			Signature{Value: base64.StdEncoding.EncodeToString(pss)},
+			ErrInvalidSignature,
+		},
+		{
+			"other serial number",
+			Signature{Value: base64.StdEncoding.EncodeToString(pkcs), SerialNumber: "72-4F"},
+			ErrInvalidSignature,
+		},
+		{
+			"unsupported algorithm",
+			Signature{Value: base64.StdEncoding.EncodeToString(pkcs), Algorithm: "HS256"},
+			ErrInvalidSignature,
+		},
+	}
+
+	verifier := NewVerifier(NewFileKeyStore(directory))
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			err := verifier.Verify([]string{"org"}, body, v.signature)
+			if !errors.Is(err, v.err) {
+				t.Errorf("returned error != expected error\n\t%s != %s", err, v.err)
+			}
+		})
+	}
+}
+
+type testVerifier struct {
+	organisations []string
+}
+
+func (v *testVerifier) Verify(organisations []string, body interface{}, signature Signature) error {
+	v.organisations = organisations
+	if signature.Value != "valid" {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func TestContractVerifier_Verify(t *testing.T) {
+	testTable := []struct {
+		description   string
+		rows          *dbMock.Rows
+		signature     Signature
+		organisations []string
+		err           error
+	}{
+		{
+			"validation not required",
+			dbMock.NewRows([]string{"validate_signature", "name"}).AddRow(false, "org"),
+			Signature{},
+			nil,
+			nil,
+		},
+		{
+			"valid signature",
+			dbMock.NewRows([]string{"validate_signature", "name"}).AddRow(true, "org").AddRow(true, "other"),
+			Signature{Value: "valid"},
+			[]string{"org", "other"},
+			nil,
+		},
+		{
+			"invalid signature",
+			dbMock.NewRows([]string{"validate_signature", "name"}).AddRow(true, nil),
+			Signature{Value: "invalid"},
+			nil,
+			ErrInvalidSignature,
+		},
+		{
+			"unknown contract",
+			dbMock.NewRows([]string{"validate_signature", "name"}),
+			Signature{Value: "valid"},
+			nil,
+			ErrContractNotFound,
+		},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			db, mock, err := dbMock.New()
+			if err != nil {
+				t.Fatalf("cannot create mocked database: %s", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("SELECT c.validate_signature, o.name FROM contracts").
+				WithArgs("contract").
+				WillReturnRows(v.rows)
+
+			verifier := &testVerifier{}
+			err = NewContractVerifier(db, verifier).Verify("contract", nil, v.signature)
+			if !errors.Is(err, v.err) {
+				t.Errorf("returned error != expected error\n\t%s != %s", err, v.err)
+			}
+
+			if !reflect.DeepEqual(verifier.organisations, v.organisations) {
+				t.Errorf("used organisations != expected organisations\n\t%v != %v", verifier.organisations, v.organisations)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("not all expectations were met: %s", err)
+			}
+		})
+	}
+}
+
+func TestSignature_JSON(t *testing.T) {
+	testTable := []struct {
+		description string
+		data        string
+		signature   Signature
+	}{
+		{"string", `"c2ln"`, Signature{Value: "c2ln"}},
+		{"with meta data", `{"signature":"c2ln","meta":{"algorithm":"ES256","serialNumber":"1"}}`, Signature{Value: "c2ln", Algorithm: "ES256", SerialNumber: "1"}},
+	}
+
+	for _, v := range testTable {
+		t.Run(v.description, func(t *testing.T) {
+			var sig Signature
+			if err := json.Unmarshal([]byte(v.data), &sig); err != nil {
+				t.Fatalf("cannot unmarshal signature: %s", err)
+			}
+			if sig != v.signature {
+				t.Errorf("expected %+v, got %+v", v.signature, sig)
+			}
+
+			data, err := json.Marshal(sig)
+			if err != nil {
+				t.Fatalf("cannot marshal signature: %s", err)
+			}
+			if string(data) != v.data {
+				t.Errorf("expected %s, got %s", v.data, data)
+			}
+		})
+	}
+}